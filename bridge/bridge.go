@@ -19,6 +19,8 @@ import (
 	"ehang.io/nps/lib/conn"
 	"ehang.io/nps/lib/crypt"
 	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/goroutine"
+	"ehang.io/nps/lib/lockout"
 	"ehang.io/nps/lib/version"
 	"ehang.io/nps/server/connection"
 	"ehang.io/nps/server/tool"
@@ -158,6 +160,7 @@ func (s *Bridge) GetHealthFromClient(id int, c *conn.Conn) {
 						if err := file.GetDb().UpdateHost(v); err != nil {
 							logs.Error("Update host error:", err)
 						}
+						goroutine.TrafficManager.InvalidateHosts()
 						v.Unlock()
 					}
 				}
@@ -188,6 +191,7 @@ func (s *Bridge) GetHealthFromClient(id int, c *conn.Conn) {
 						if err := file.GetDb().UpdateHost(v); err != nil {
 							logs.Error("Update host error:", err)
 						}
+						goroutine.TrafficManager.InvalidateHosts()
 						v.Unlock()
 					}
 				}
@@ -238,9 +242,21 @@ func (s *Bridge) cliProcess(c *conn.Conn) {
 	}
 	logs.Info("vKey %s:", string(buf))
 	//verify
+	// 连接数量多，校验成功后不清零失败次数，失败次数在窗口期后自动清零
+	ip := common.GetIpByAddr(c.Conn.RemoteAddr().String())
+	if err := lockout.Check(file.LockScopeBridge, ip); err != nil {
+		logs.Info("The client %s is locked: %s", c.Conn.RemoteAddr(), err)
+		s.verifyError(c)
+		return
+	}
 	id, err := file.GetDb().GetIdByVerifyKey(string(buf), c.Conn.RemoteAddr().String())
 	if err != nil {
 		logs.Info("Current client connection validation error, close this client:", c.Conn.RemoteAddr())
+		lockout.Fail(file.LockScopeBridge, ip)
+		s.verifyError(c)
+		return
+	} else if client, err := file.GetDb().GetClient(id); err == nil && goroutine.IsAccountExpired(client.AccountId) {
+		logs.Info("The account %d of client %d has expired, close this client: %s", client.AccountId, id, c.Conn.RemoteAddr())
 		s.verifyError(c)
 		return
 	} else {
@@ -326,6 +342,9 @@ func (s *Bridge) typeDeal(typeVal string, c *conn.Conn, id int, vs string) {
 			logs.Error("p2p error,", err.Error())
 		} else if t := file.GetDb().GetTaskByMd5Password(string(b)); t == nil {
 			logs.Error("p2p error, failed to match the key successfully")
+		} else if err := goroutine.CheckAccount(t.AccountId); err != nil {
+			// p2p 流量不经过服务端，账号到期或流量用尽时不再协助建立连接
+			logs.Warn("p2p error, task id %d, account %d: %s", t.Id, t.AccountId, err.Error())
 		} else {
 			if v, ok := s.Client.Load(t.Client.Id); !ok {
 				return
@@ -501,7 +520,12 @@ loop:
 					c.WriteAddFail()
 					break loop
 				} else {
-					file.GetDb().NewHost(h)
+					if err := file.GetDb().NewHost(h); err != nil {
+						fail = true
+						c.WriteAddFail()
+						break loop
+					}
+					goroutine.TrafficManager.InvalidateHosts()
 					c.WriteAddOk()
 				}
 			} else {
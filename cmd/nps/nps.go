@@ -1,19 +1,23 @@
 package main
 
 import (
+	"bufio"
 	"ehang.io/nps/bridge"
 	"ehang.io/nps/lib/daemon"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 
 	"ehang.io/nps/lib/file"
 	"ehang.io/nps/lib/install"
+	"ehang.io/nps/lib/payment"
 	"ehang.io/nps/lib/version"
 	"ehang.io/nps/server"
 	"ehang.io/nps/server/connection"
@@ -54,6 +58,11 @@ func main() {
 		}
 	}
 
+	// 生成密码哈希不需要配置文件
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		hashPassword(os.Args[2:])
+		return
+	}
 	if err := beego.LoadAppConfig("ini", filepath.Join(common.GetRunPath(), "conf", "nps.conf")); err != nil {
 		log.Fatalln("load config file error", err.Error())
 	}
@@ -174,6 +183,18 @@ func main() {
 		case "update":
 			install.UpdateNps()
 			return
+		case "migrate":
+			migrate(os.Args[2:])
+			return
+		case "import-json":
+			importJson(os.Args[2:])
+			return
+		case "config":
+			config(os.Args[2:])
+			return
+		case "reconcile":
+			reconcile(os.Args[2:])
+			return
 			//default:
 			//	logs.Error("command is not support")
 			//	return
@@ -183,6 +204,222 @@ func main() {
 	_ = s.Run()
 }
 
+// migrate database schema, usage: nps migrate up|down [steps]|status
+func migrate(args []string) {
+	var params []string
+	for _, v := range args {
+		if !strings.HasPrefix(v, "-") {
+			params = append(params, v)
+		}
+	}
+	action := "status"
+	if len(params) > 0 {
+		action = params[0]
+	}
+	store, err := file.OpenStore(file.GetDriverName())
+	if err != nil {
+		logs.Error(err)
+		return
+	}
+	switch action {
+	case "up":
+		n, err := store.MigrateUp()
+		if err != nil {
+			logs.Error(err)
+		}
+		logs.Info("%d migrations applied", n)
+	case "down":
+		steps := 1
+		if len(params) > 1 {
+			if steps, err = strconv.Atoi(params[1]); err != nil {
+				logs.Error("invalid migration steps %s", params[1])
+				return
+			}
+		}
+		n, err := store.MigrateDown(steps)
+		if err != nil {
+			logs.Error(err)
+		}
+		logs.Info("%d migrations rolled back", n)
+	case "status":
+		list, err := store.MigrationStatus()
+		if err != nil {
+			logs.Error(err)
+			return
+		}
+		for _, v := range list {
+			status := "pending"
+			if v.Applied {
+				status = "applied at " + v.AppliedAt
+			}
+			fmt.Printf("%04d_%s\t%s\n", v.Version, v.Name, status)
+		}
+	default:
+		logs.Error("Valid migrate actions: up, down [steps], status")
+	}
+}
+
+// import legacy json data files, usage: nps import-json [-dry-run] [dir]
+func importJson(args []string) {
+	dryRun := false
+	dir := filepath.Join(common.GetRunPath(), "conf")
+	for _, v := range args {
+		switch {
+		case v == "-dry-run" || v == "--dry-run":
+			dryRun = true
+		case !strings.HasPrefix(v, "-"):
+			dir = v
+		}
+	}
+	store, err := file.OpenStore(file.GetDriverName())
+	if err != nil {
+		logs.Error(err)
+		return
+	}
+	if _, err := store.MigrateUp(); err != nil {
+		logs.Error(err)
+		return
+	}
+	report, err := file.ImportLegacyJson(store, dir, dryRun)
+	for _, v := range report.Conflicts {
+		fmt.Println("conflict:", v)
+	}
+	if err != nil {
+		logs.Error(err)
+	}
+	logs.Info(report.Summary())
+}
+
+// export or apply the configuration document, usage: nps config export [-format=yaml|json] <file> | diff <file> | apply <file>
+func config(args []string) {
+	format := ""
+	var params []string
+	for _, v := range args {
+		if strings.HasPrefix(v, "-format=") || strings.HasPrefix(v, "--format=") {
+			format = v[strings.Index(v, "=")+1:]
+		} else if !strings.HasPrefix(v, "-") {
+			params = append(params, v)
+		}
+	}
+	if len(params) < 2 {
+		logs.Error("Usage: nps config export [-format=yaml|json] <file> | diff <file> | apply <file>")
+		return
+	}
+	store, err := file.OpenStore(file.GetDriverName())
+	if err != nil {
+		logs.Error(err)
+		return
+	}
+	if _, err := store.MigrateUp(); err != nil {
+		logs.Error(err)
+		return
+	}
+	switch params[0] {
+	case "export":
+		if format == "" {
+			format = "yaml"
+			if strings.HasSuffix(params[1], ".json") {
+				format = "json"
+			}
+		}
+		snap, err := file.ExportSnapshot(store)
+		if err != nil {
+			logs.Error(err)
+			return
+		}
+		b, err := snap.Marshal(format)
+		if err != nil {
+			logs.Error(err)
+			return
+		}
+		if err := os.WriteFile(params[1], b, 0600); err != nil {
+			logs.Error(err)
+		}
+	case "diff", "apply":
+		b, err := os.ReadFile(params[1])
+		if err != nil {
+			logs.Error(err)
+			return
+		}
+		snap, err := file.ParseSnapshot(b)
+		if err != nil {
+			logs.Error(err)
+			return
+		}
+		plan, err := file.ApplySnapshot(store, snap, params[0] == "diff")
+		if plan != nil {
+			fmt.Print(plan)
+		}
+		if err != nil {
+			logs.Error(err)
+		}
+	default:
+		logs.Error("Valid config actions: export, diff, apply")
+	}
+}
+
+// list orders whose status and account credits disagree, usage: nps reconcile [-settle]
+// with payment_provider configured, orders paid at the provider but not settled locally are listed too,
+// -settle settles them
+func reconcile(args []string) {
+	settle := false
+	for _, v := range args {
+		if v == "-settle" || v == "--settle" {
+			settle = true
+		}
+	}
+	store, err := file.OpenStore(file.GetDriverName())
+	if err != nil {
+		logs.Error(err)
+		return
+	}
+	if _, err := store.MigrateUp(); err != nil {
+		logs.Error(err)
+		return
+	}
+	provider, err := payment.Default()
+	if err != nil {
+		logs.Warn("payment provider is not available, only local orders are checked: %s", err.Error())
+		provider = nil
+	}
+	list, err := payment.Reconcile(store, provider, settle)
+	if err != nil {
+		logs.Error(err)
+		return
+	}
+	for _, m := range list {
+		fmt.Printf("%s\taccount=%s\tstatus=%s\tamount=%.2f\t%s\n",
+			m.Order.ExternalTransactionId, m.Order.AccountId, m.Order.OrderStatus, m.Order.OrderAmount, m.Reason)
+	}
+	logs.Info("%d mismatched orders", len(list))
+}
+
+// hashPassword 输出密码的哈希，用于配置文件中的 web_password，未指定密码时从标准输入读取
+func hashPassword(args []string) {
+	var password string
+	if len(args) > 0 {
+		password = args[0]
+	} else {
+		fmt.Fprint(os.Stderr, "password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			logs.Error(err)
+			return
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		logs.Error("password is empty")
+		return
+	}
+	hash, err := crypt.HashPassword(password)
+	if err != nil {
+		logs.Error(err)
+		return
+	}
+	fmt.Println(hash)
+}
+
 type nps struct {
 	exit chan struct{}
 }
@@ -0,0 +1,21 @@
+package crypt
+
+import "strings"
+
+// ApiKeyPrefix API 密钥的固定前缀，用于与 JWT 区分
+const ApiKeyPrefix = "nps_"
+
+// NewApiKey 生成 192 位随机数的 API 密钥
+func NewApiKey() string {
+	return ApiKeyPrefix + RandomHex(24)
+}
+
+// IsApiKey 判断字符串是否为 API 密钥格式
+func IsApiKey(key string) bool {
+	return strings.HasPrefix(key, ApiKeyPrefix) && len(key) == len(ApiKeyPrefix)+48
+}
+
+// HashApiKey 返回 API 密钥的 sha256，数据库中只保存该值
+func HashApiKey(key string) string {
+	return Sha256(key)
+}
@@ -0,0 +1,34 @@
+package crypt
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword 使用 bcrypt 计算密码的哈希
+func HashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(b), err
+}
+
+// IsPasswordHash 判断 s 是否为 bcrypt 哈希
+func IsPasswordHash(s string) bool {
+	return len(s) == 60 && (strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$"))
+}
+
+// CheckPassword 校验密码，stored 可以是 bcrypt 哈希或旧版本保存的明文，
+// 明文校验通过时 upgrade 为 true，调用方应改为保存哈希
+func CheckPassword(stored, password string) (ok, upgrade bool) {
+	if IsPasswordHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+	ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	return ok, ok && stored != ""
+}
+
+// EqualString 常量时间比较两个字符串
+func EqualString(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
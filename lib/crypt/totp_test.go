@@ -0,0 +1,27 @@
+package crypt
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestTotp(t *testing.T) {
+	// RFC 6238 附录 B 的 SHA1 测试向量，取后 6 位
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		if code, err := TotpCode(secret, TotpStep(time.Unix(unix, 0))); err != nil || code != want {
+			t.Fatalf("TotpCode at %d = %s %v, want %s", unix, code, err, want)
+		}
+	}
+	now := time.Unix(1111111109, 0)
+	if step, ok := ValidateTotp(secret, "081804", now.Add(30*time.Second)); !ok || step != TotpStep(now) {
+		t.Fatalf("previous step not accepted: %d %v", step, ok)
+	}
+	if _, ok := ValidateTotp(secret, "081804", now.Add(90*time.Second)); ok {
+		t.Fatal("expired code accepted")
+	}
+	if HashRecoveryCode("ABCDE-12345") != HashRecoveryCode("abcde12345") {
+		t.Fatal("recovery code hash should ignore case and hyphen")
+	}
+}
@@ -0,0 +1,63 @@
+package file
+
+import (
+	"time"
+)
+
+// AllowanceCycleStart 返回 now 所在额度周期的开始时间，即不晚于 now 的最近一个重置日零点，
+// 重置日大于当月天数时按当月最后一天计算
+func AllowanceCycleStart(now time.Time, day int) time.Time {
+	anchor := func(y int, m time.Month) time.Time {
+		d := day
+		if last := time.Date(y, m+1, 0, 0, 0, 0, 0, now.Location()).Day(); d > last {
+			d = last
+		}
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	}
+	t := anchor(now.Year(), now.Month())
+	if t.After(now) {
+		t = anchor(now.Year(), now.Month()-1)
+	}
+	return t
+}
+
+// ResetAllowances 把已进入新周期的账号的剩余额度重置为每月额度，返回被重置的账号，
+// 以 last_reset 作为条件更新，多次执行或并发执行不会重复重置
+func (s *DbUtils) ResetAllowances(now time.Time) ([]int, error) {
+	rows, err := s.SqlDB.Query("SELECT id, reset_day, last_reset FROM accounts WHERE reset_day > 0")
+	if err != nil {
+		return nil, err
+	}
+	type due struct {
+		id        int
+		lastReset int64
+	}
+	var list []due
+	for rows.Next() {
+		var id, day int
+		var last int64
+		if err := rows.Scan(&id, &day, &last); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if last < AllowanceCycleStart(now, day).Unix() {
+			list = append(list, due{id, last})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, d := range list {
+		res, err := s.SqlDB.Exec("UPDATE accounts SET allowance_left = allowance, last_reset = ? WHERE id = ? AND last_reset = ?",
+			now.Unix(), d.id, d.lastReset)
+		if err != nil {
+			return ids, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			ids = append(ids, d.id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,91 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowanceCycleStart(t *testing.T) {
+	day := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, c := range []struct {
+		now  string
+		day  int
+		want string
+	}{
+		{"2024-05-20 10:00", 15, "2024-05-15 00:00"},
+		{"2024-05-10 10:00", 15, "2024-04-15 00:00"},
+		{"2024-05-15 00:00", 15, "2024-05-15 00:00"},
+		{"2024-01-10 10:00", 15, "2023-12-15 00:00"},
+		{"2024-02-29 12:00", 31, "2024-02-29 00:00"},
+		{"2024-03-30 12:00", 31, "2024-02-29 00:00"},
+		{"2024-03-31 12:00", 31, "2024-03-31 00:00"},
+	} {
+		if got := AllowanceCycleStart(day(c.now), c.day); !got.Equal(day(c.want)) {
+			t.Errorf("AllowanceCycleStart(%s, %d) = %s, want %s", c.now, c.day, got, c.want)
+		}
+	}
+}
+
+func TestAllowance(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "user")
+	a, _ := db.GetAccountInfo(id)
+	a.Allowance, a.ResetDay = 1000, 1
+	if err := db.UpdateAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTraffic(id, 500); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 20, 10, 0, 0, 0, time.Local)
+	if ids, err := db.ResetAllowances(now); err != nil || len(ids) != 1 || ids[0] != id {
+		t.Fatalf("ResetAllowances %v %v", ids, err)
+	}
+	if ids, _ := db.ResetAllowances(now.Add(time.Hour)); len(ids) != 0 {
+		t.Fatalf("allowance reset twice in one cycle: %v", ids)
+	}
+	if n, _ := db.GetAccountFlowLimit(id); n != 1500<<10 {
+		t.Fatalf("flow limit %d", n)
+	}
+
+	// 先消耗额度，不足部分从购买的流量中扣除
+	if err := db.ConsumeTraffic(id, 800); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ = db.GetAccountInfo(id); a.AllowanceLeft != 200 || a.Flow.FlowLimit != 500 {
+		t.Fatalf("after 800: allowance %d flow %d", a.AllowanceLeft, a.Flow.FlowLimit)
+	}
+	if err := db.ConsumeTraffic(id, 300); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ = db.GetAccountInfo(id); a.AllowanceLeft != 0 || a.Flow.FlowLimit != 400 || a.Remaining() != 400 {
+		t.Fatalf("after 1100: allowance %d flow %d", a.AllowanceLeft, a.Flow.FlowLimit)
+	}
+
+	// 调整额度时按差值调整本周期剩余额度
+	a.Allowance = 2000
+	if err := db.UpdateAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ = db.GetAccountInfo(id); a.AllowanceLeft != 1000 {
+		t.Fatalf("allowance left %d after raising allowance", a.AllowanceLeft)
+	}
+
+	// 进入下一周期后重置额度，购买的流量保留
+	if ids, _ := db.ResetAllowances(time.Date(2024, 6, 1, 0, 0, 1, 0, time.Local)); len(ids) != 1 {
+		t.Fatalf("allowance not reset in new cycle: %v", ids)
+	}
+	if a, _ = db.GetAccountInfo(id); a.AllowanceLeft != 2000 || a.Flow.FlowLimit != 400 {
+		t.Fatalf("after reset: allowance %d flow %d", a.AllowanceLeft, a.Flow.FlowLimit)
+	}
+	a.ResetDay = 32
+	if err := db.UpdateAccount(a); err == nil {
+		t.Fatal("invalid reset day accepted")
+	}
+}
@@ -0,0 +1,164 @@
+package file
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// API 密钥的授权范围
+const (
+	ScopeTunnelRead  = "tunnel:read"  // 查看客户端、隧道与流量
+	ScopeTunnelWrite = "tunnel:write" // 修改客户端与隧道
+	ScopeHostRead    = "host:read"    // 查看域名解析
+	ScopeHostWrite   = "host:write"   // 修改域名解析
+	ScopeBillingRead = "billing:read" // 查看订单、发票与套餐
+)
+
+var apiScopes = []string{ScopeTunnelRead, ScopeTunnelWrite, ScopeHostRead, ScopeHostWrite, ScopeBillingRead}
+
+// ApiKey 账号的 API 密钥，密钥本身只在创建时返回一次
+type ApiKey struct {
+	Id         int
+	AccountId  int
+	Name       string
+	Prefix     string
+	KeyHash    string `json:"-"`
+	Scopes     []string
+	ExpireAt   int64
+	LastUsedAt int64
+	LastUsedIp string
+	RevokedAt  int64
+	CreatedAt  int64
+}
+
+// Valid 密钥在 now 时是否可用
+func (k *ApiKey) Valid(now time.Time) bool {
+	return k.RevokedAt == 0 && (k.ExpireAt == 0 || now.Unix() < k.ExpireAt)
+}
+
+// HasScope 密钥是否拥有授权范围
+func (k *ApiKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes 解析逗号分隔的授权范围，忽略重复项，存在未知的授权范围时返回错误
+func ParseScopes(s string) ([]string, error) {
+	scopes := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		known := false
+		for _, a := range apiScopes {
+			known = known || a == v
+		}
+		if !known {
+			return nil, errors.New("unknown scope " + v)
+		}
+		dup := false
+		for _, a := range scopes {
+			dup = dup || a == v
+		}
+		if !dup {
+			scopes = append(scopes, v)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// ApiKeyStore API 密钥相关的存储操作
+type ApiKeyStore interface {
+	CreateApiKey(k *ApiKey) error
+	GetApiKeyByHash(hash string) (*ApiKey, error)
+	ListApiKeys(accountId int) ([]*ApiKey, error)
+	RevokeApiKey(accountId, id int) error
+	TouchApiKey(id int, ip string, now time.Time) error
+}
+
+const apiKeyColumns = "id, account_id, name, prefix, key_hash, scopes, expire_at, last_used_at, last_used_ip, revoked_at, created_at"
+
+func scanApiKey(row interface{ Scan(...interface{}) error }) (*ApiKey, error) {
+	k := &ApiKey{}
+	var scopes string
+	if err := row.Scan(&k.Id, &k.AccountId, &k.Name, &k.Prefix, &k.KeyHash, &scopes,
+		&k.ExpireAt, &k.LastUsedAt, &k.LastUsedIp, &k.RevokedAt, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = strings.Split(scopes, ",")
+	return k, nil
+}
+
+// CreateApiKey 保存新的 API 密钥，调用方需要先设置 KeyHash
+func (s *DbUtils) CreateApiKey(k *ApiKey) error {
+	if k.KeyHash == "" || k.AccountId == 0 {
+		return errors.New("api key hash and account are required")
+	}
+	if k.CreatedAt == 0 {
+		k.CreatedAt = time.Now().Unix()
+	}
+	res, err := s.SqlDB.Exec("INSERT INTO api_keys (account_id, name, prefix, key_hash, scopes, expire_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		k.AccountId, k.Name, k.Prefix, k.KeyHash, strings.Join(k.Scopes, ","), k.ExpireAt, k.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	k.Id = int(id)
+	return err
+}
+
+// GetApiKeyByHash 按密钥的 sha256 查询，不存在时返回 nil
+func (s *DbUtils) GetApiKeyByHash(hash string) (*ApiKey, error) {
+	k, err := scanApiKey(s.SqlDB.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return k, err
+}
+
+// ListApiKeys 返回账号的所有 API 密钥，包括已吊销与已过期的
+func (s *DbUtils) ListApiKeys(accountId int) ([]*ApiKey, error) {
+	rows, err := s.SqlDB.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE account_id = ? ORDER BY id DESC", accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]*ApiKey, 0)
+	for rows.Next() {
+		k, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, k)
+	}
+	return list, rows.Err()
+}
+
+// RevokeApiKey 吊销账号的 API 密钥，立即生效
+func (s *DbUtils) RevokeApiKey(accountId, id int) error {
+	res, err := s.SqlDB.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND account_id = ? AND revoked_at = 0",
+		time.Now().Unix(), id, accountId)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("api key not found or already revoked")
+	}
+	return nil
+}
+
+// TouchApiKey 记录密钥最近一次使用的时间与来源地址，一分钟内只更新一次
+func (s *DbUtils) TouchApiKey(id int, ip string, now time.Time) error {
+	_, err := s.SqlDB.Exec("UPDATE api_keys SET last_used_at = ?, last_used_ip = ? WHERE id = ? AND last_used_at < ?",
+		now.Unix(), ip, id, now.Unix()-60)
+	return err
+}
@@ -0,0 +1,64 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScopes(t *testing.T) {
+	if s, err := ParseScopes(" tunnel:read, host:write,tunnel:read "); err != nil || len(s) != 2 || s[0] != ScopeTunnelRead || s[1] != ScopeHostWrite {
+		t.Fatalf("ParseScopes %v %v", s, err)
+	}
+	if _, err := ParseScopes("tunnel:read,admin"); err == nil {
+		t.Fatal("unknown scope accepted")
+	}
+	if _, err := ParseScopes(""); err == nil {
+		t.Fatal("empty scopes accepted")
+	}
+}
+
+func TestApiKeyStore(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "user")
+	k := &ApiKey{AccountId: id, Name: "ci", Prefix: "nps_abcdef", KeyHash: "hash", Scopes: []string{ScopeTunnelRead, ScopeBillingRead}}
+	if err := db.CreateApiKey(k); err != nil || k.Id == 0 {
+		t.Fatalf("CreateApiKey %d %v", k.Id, err)
+	}
+	if err := db.CreateApiKey(&ApiKey{AccountId: id, KeyHash: "hash", Scopes: []string{ScopeTunnelRead}}); err == nil {
+		t.Fatal("duplicate key hash accepted")
+	}
+	if got, err := db.GetApiKeyByHash("missing"); got != nil || err != nil {
+		t.Fatalf("GetApiKeyByHash missing %+v %v", got, err)
+	}
+	got, err := db.GetApiKeyByHash("hash")
+	if err != nil || got.AccountId != id || !got.HasScope(ScopeBillingRead) || got.HasScope(ScopeHostWrite) || !got.Valid(time.Now()) {
+		t.Fatalf("GetApiKeyByHash %+v %v", got, err)
+	}
+
+	now := time.Now()
+	if err := db.TouchApiKey(k.Id, "127.0.0.1", now); err != nil {
+		t.Fatal(err)
+	}
+	db.TouchApiKey(k.Id, "127.0.0.2", now.Add(time.Second))
+	if got, _ := db.GetApiKeyByHash("hash"); got.LastUsedAt != now.Unix() || got.LastUsedIp != "127.0.0.1" {
+		t.Fatalf("TouchApiKey %+v", got)
+	}
+
+	if err := db.RevokeApiKey(id+1, k.Id); err == nil {
+		t.Fatal("revoked another account's key")
+	}
+	if err := db.RevokeApiKey(id, k.Id); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetApiKeyByHash("hash"); got.Valid(time.Now()) {
+		t.Fatal("revoked key still valid")
+	}
+	if list, err := db.ListApiKeys(id); err != nil || len(list) != 1 || list[0].RevokedAt == 0 {
+		t.Fatalf("ListApiKeys %+v %v", list, err)
+	}
+
+	expired := &ApiKey{ExpireAt: now.Unix()}
+	if expired.Valid(now) || !expired.Valid(now.Add(-time.Second)) {
+		t.Fatal("unexpected expiry check")
+	}
+}
@@ -0,0 +1,134 @@
+package file
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// 优惠码类型
+const (
+	CouponPercent   = "percent"    // 按百分比折扣，Value 为减免的百分比
+	CouponFixed     = "fixed"      // 固定减免，Value 为减免金额(元)
+	CouponBonusFlow = "bonus_flow" // 赠送流量，Value 为赠送的流量(GB)
+)
+
+// Coupon 优惠码，MaxUses 为 0 表示不限次数，StartAt、EndAt 为 unix 时间戳，0 表示不限制
+type Coupon struct {
+	Id      int     `json:"id"`
+	Code    string  `json:"code"`
+	Kind    string  `json:"kind"`
+	Value   float64 `json:"value"`
+	MaxUses int     `json:"max_uses"`
+	Used    int     `json:"used"`
+	StartAt int64   `json:"start_at"`
+	EndAt   int64   `json:"end_at"`
+	Status  bool    `json:"status"`
+	Remark  string  `json:"remark"`
+}
+
+// CouponStore 优惠码相关的存储操作
+type CouponStore interface {
+	GetCoupons() ([]*Coupon, error)
+	GetCouponByCode(code string) (*Coupon, error)
+	SaveCoupon(c *Coupon) error
+	DelCoupon(id int) error
+	CountCouponUses(code string, accountId int) (int, error)
+}
+
+// ErrCouponNotFound 优惠码不存在
+var ErrCouponNotFound = errors.New("coupon not found")
+
+const couponColumns = "id, code, kind, value, max_uses, used, start_at, end_at, status, remark"
+
+func scanCoupon(row interface{ Scan(...interface{}) error }) (*Coupon, error) {
+	c := new(Coupon)
+	err := row.Scan(&c.Id, &c.Code, &c.Kind, &c.Value, &c.MaxUses, &c.Used, &c.StartAt, &c.EndAt, &c.Status, &c.Remark)
+	return c, err
+}
+
+// GetCoupons 返回全部优惠码
+func (s *DbUtils) GetCoupons() ([]*Coupon, error) {
+	rows, err := s.SqlDB.Query("SELECT " + couponColumns + " FROM coupons ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]*Coupon, 0)
+	for rows.Next() {
+		c, err := scanCoupon(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// GetCouponByCode 根据优惠码获取，优惠码不区分大小写
+func (s *DbUtils) GetCouponByCode(code string) (*Coupon, error) {
+	c, err := scanCoupon(s.SqlDB.QueryRow("SELECT "+couponColumns+" FROM coupons WHERE code = ?", strings.ToUpper(code)))
+	if err == sql.ErrNoRows {
+		return nil, ErrCouponNotFound
+	}
+	return c, err
+}
+
+// SaveCoupon 保存优惠码，Id 为 0 时新建并回填 Id，已使用次数不会被修改
+func (s *DbUtils) SaveCoupon(c *Coupon) error {
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	if c.Code == "" {
+		return errors.New("coupon code is required")
+	}
+	switch c.Kind {
+	case CouponPercent:
+		if c.Value <= 0 || c.Value >= 100 {
+			return errors.New("percent coupon value must be between 0 and 100")
+		}
+	case CouponFixed, CouponBonusFlow:
+		if c.Value <= 0 {
+			return errors.New("coupon value must be positive")
+		}
+	default:
+		return errors.New("coupon kind must be percent, fixed or bonus_flow")
+	}
+	if c.MaxUses < 0 || (c.EndAt > 0 && c.EndAt <= c.StartAt) {
+		return errors.New("invalid coupon usage limit or validity window")
+	}
+	if c.Id == 0 {
+		res, err := s.SqlDB.Exec(`INSERT INTO coupons (code, kind, value, max_uses, start_at, end_at, status, remark)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.Code, c.Kind, c.Value, c.MaxUses, c.StartAt, c.EndAt, c.Status, c.Remark)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		c.Id = int(id)
+		return err
+	}
+	res, err := s.SqlDB.Exec(`UPDATE coupons SET code = ?, kind = ?, value = ?, max_uses = ?, start_at = ?, end_at = ?,
+		status = ?, remark = ? WHERE id = ?`,
+		c.Code, c.Kind, c.Value, c.MaxUses, c.StartAt, c.EndAt, c.Status, c.Remark, c.Id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrCouponNotFound
+	}
+	return nil
+}
+
+// DelCoupon 删除优惠码，已下单的订单保留优惠内容
+func (s *DbUtils) DelCoupon(id int) error {
+	_, err := s.SqlDB.Exec("DELETE FROM coupons WHERE id = ?", id)
+	return err
+}
+
+// CountCouponUses 返回账号使用该优惠码并已支付（含已退款）的订单数
+func (s *DbUtils) CountCouponUses(code string, accountId int) (int, error) {
+	var n int
+	err := s.SqlDB.QueryRow("SELECT COUNT(*) FROM orders WHERE coupon_code = ? AND account_id = ? AND order_status IN (?, ?)",
+		strings.ToUpper(code), strconv.Itoa(accountId), OrderPaid, OrderRefunded).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,101 @@
+package file
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCoupons(t *testing.T) {
+	db := newTestSqliteDb(t)
+	c := &Coupon{Code: " spring ", Kind: CouponBonusFlow, Value: 5, MaxUses: 10, Status: true}
+	if err := db.SaveCoupon(c); err != nil || c.Id == 0 || c.Code != "SPRING" {
+		t.Fatalf("SaveCoupon %+v %v", c, err)
+	}
+	for _, bad := range []*Coupon{
+		{Code: "A", Kind: CouponPercent, Value: 100},
+		{Code: "B", Kind: "free", Value: 1},
+		{Code: "C", Kind: CouponFixed, Value: 1, StartAt: 10, EndAt: 5},
+		{Code: "spring", Kind: CouponFixed, Value: 1},
+	} {
+		if err := db.SaveCoupon(bad); err == nil {
+			t.Fatalf("invalid coupon %+v accepted", bad)
+		}
+	}
+	if _, err := db.GetCouponByCode("none"); err != ErrCouponNotFound {
+		t.Fatalf("expected ErrCouponNotFound, got %v", err)
+	}
+
+	id := newTestAccount(t, db, "user")
+	o := &Order{AccountId: strconv.Itoa(id), Flow: 2, OrderStatus: OrderPending, PaymentType: "traffic",
+		ExternalTransactionId: "PAY1", CouponCode: "SPRING", BonusFlow: 5}
+	if err := db.CreateOrder(o); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := db.CountCouponUses("spring", id); n != 0 {
+		t.Fatalf("unpaid order counted as coupon use")
+	}
+	if _, err := db.SettleOrder("PAY1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetCouponByCode("Spring"); got.Used != 1 {
+		t.Fatalf("coupon used %d times", got.Used)
+	}
+	if n, _ := db.CountCouponUses("spring", id); n != 1 {
+		t.Fatalf("coupon uses %d", n)
+	}
+	if info, _ := db.GetAccountInfo(id); info.Flow.FlowLimit != 7*1024*1024 {
+		t.Fatalf("bonus flow not credited: %d", info.Flow.FlowLimit)
+	}
+	if list, err := db.ReconcileOrders(); err != nil || len(list) != 0 {
+		t.Fatalf("unexpected mismatches %v %v", list, err)
+	}
+	if err := db.DelCoupon(c.Id); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := db.GetCoupons(); len(list) != 0 {
+		t.Fatalf("coupon not deleted: %v", list)
+	}
+}
+
+func TestCouponLimitAtSettlement(t *testing.T) {
+	db := newTestSqliteDb(t)
+	if err := db.SaveCoupon(&Coupon{Code: "ONCE", Kind: CouponBonusFlow, Value: 1, MaxUses: 2, Status: true}); err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := newTestAccount(t, db, "a"), newTestAccount(t, db, "b"), newTestAccount(t, db, "c")
+	order := func(id int, externalId string) {
+		o := &Order{AccountId: strconv.Itoa(id), Flow: 1, OrderStatus: OrderPending, PaymentType: "traffic",
+			ExternalTransactionId: externalId, CouponCode: "ONCE", BonusFlow: 1}
+		if err := db.CreateOrder(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 下单时同一账号的未支付订单都能通过校验，结算时只有第一个有效
+	order(a, "A1")
+	order(a, "A2")
+	if _, err := db.SettleOrder("A1"); err != nil {
+		t.Fatal(err)
+	}
+	if o, err := db.SettleOrder("A2"); err != ErrCouponLimit || o.OrderStatus != OrderPending {
+		t.Fatalf("second use by the same account %+v %v", o, err)
+	}
+	if info, _ := db.GetAccountInfo(a); info.Flow.FlowLimit != 2*1024*1024 {
+		t.Fatalf("flow credited twice: %d", info.Flow.FlowLimit)
+	}
+
+	// 总使用次数达到上限后不再入账
+	order(b, "B1")
+	order(c, "C1")
+	if _, err := db.SettleOrder("B1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SettleOrder("C1"); err != ErrCouponLimit {
+		t.Fatalf("coupon used beyond max uses: %v", err)
+	}
+	if got, _ := db.GetCouponByCode("once"); got.Used != 2 {
+		t.Fatalf("coupon used %d times", got.Used)
+	}
+	if list, err := db.ReconcileOrders(); err != nil || len(list) != 0 {
+		t.Fatalf("unexpected mismatches %v %v", list, err)
+	}
+}
@@ -2,6 +2,7 @@ package file
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"ehang.io/nps/lib/common"
 	"ehang.io/nps/lib/crypt"
@@ -17,43 +19,54 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 )
 
-// DbUtils 提供MySQL存储操作
+// DbUtils 提供MySQL存储操作，实现 Store 接口
 type DbUtils struct {
-	SqlDB *sql.DB
+	SqlDB  *sql.DB
+	driver string
 }
 
+// NewMysqlDb 使用 mysql_dsn 建立 MySQL 数据库连接
+func NewMysqlDb(dsn string) (*DbUtils, error) {
+	if dsn == "" {
+		return nil, errors.New("mysql_dsn not found in nps.conf")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// 可选：设置数据库连接池参数，例如 db.SetMaxOpenConns(x)
+	return &DbUtils{
+		SqlDB:  db,
+		driver: DriverMysql,
+	}, nil
+}
+
+// 列表接口允许的排序字段，键为前端字段名，值为对应的列或表达式
 var (
-	Db   *DbUtils
-	once sync.Once
+	clientSortFields = map[string]string{
+		"Id":        "id",
+		"Remark":    "remark",
+		"VerifyKey": "verify_key",
+		"Addr":      "addr",
+		"AccountId": "account_id",
+		"InletFlow": "inlet_flow",
+		"Status":    "status",
+	}
+	tunnelSortFields = map[string]string{
+		"Id":               "id",
+		"ClientId":         "client_id",
+		"Remark":           "remark",
+		"Port":             "port",
+		"Target":           "target",
+		"Client.VerifyKey": "(SELECT verify_key FROM clients WHERE clients.id = tasks.client_id)",
+	}
 )
 
-// GetDb 建立 MySQL 数据库连接，并返回 DbUtils 实例
-func GetDb() *DbUtils {
-	once.Do(func() {
-		// 请确保安装 MySQL 驱动：执行 go get github.com/go-sql-driver/mysql
-		// 使用合适的 DSN 连接 MySQL，请根据实际情况修改用户名、密码、地址与数据库名称
-		// Get the DSN from nps.conf configuration
-		dsn := common.GetConfig("mysql_dsn")
-		if dsn == "" {
-			panic("mysql_dsn not found in nps.conf")
-		}
-		db, err := sql.Open("mysql", dsn)
-		if err != nil {
-			panic(err)
-		}
-		// 可选：设置数据库连接池参数，例如 db.SetMaxOpenConns(x)
-		Db = &DbUtils{
-			SqlDB: db,
-		}
-	})
-	return Db
-}
-
-// 辅助函数：根据排序键获取已排序的客户端ID列表（通过数据库查询，示例中假设表名为 clients）
-func GetSortedClientIDs(orderBy string, order string) ([]int, error) {
-	query := fmt.Sprintf("SELECT id FROM clients ORDER BY %s %s", orderBy, order)
+// GetSortedClientIDs 根据排序键获取已排序的客户端ID列表，排序键必须在 clientSortFields 中
+func (s *DbUtils) GetSortedClientIDs(orderBy string, order string) ([]int, error) {
+	query, args := NewQuery("clients").OrderBy(orderBy, order, clientSortFields, "id").SelectSql("id")
 	fmt.Println("SQL Query:", query)
-	rows, err := GetDb().SqlDB.Query(query)
+	rows, err := s.SqlDB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -67,8 +80,6 @@ func GetSortedClientIDs(orderBy string, order string) ([]int, error) {
 		}
 		keys = append(keys, id)
 	}
-	// 为防万一再做一次排序
-	sort.Ints(keys)
 	return keys, nil
 }
 
@@ -88,28 +99,24 @@ func GetMapKeys(m sync.Map, isSort bool, sortKey, order string) []int {
 // GetClientList 从 MySQL 中按条件获取客户端列表及总数
 // 修改为返回 ([]*Client, int) 两个值，错误通过 panic 抛出。
 func (s *DbUtils) GetClientList(start, length int, search, sortField, order string, clientId int) ([]*Client, int) {
-	where := "WHERE no_display = 0"
+	q := NewQuery("clients").Where("no_display = 0")
 	if clientId != 0 {
-		where += fmt.Sprintf(" AND id = %d", clientId)
-	}
-	if search != "" {
-		// 假设在数据库中 search 对应 id、verify_key、remark 字段的匹配
-		where += fmt.Sprintf(" AND (id = '%s' OR verify_key LIKE '%%%s%%' OR remark LIKE '%%%s%%')", search, search, search)
-	}
-	if sortField == "" {
-		sortField = "id"
+		q.Where("id = ?", clientId)
 	}
+	// search 对应 id、verify_key、remark 字段的匹配
+	q.Search(search, []string{"id"}, []string{"verify_key", "remark"})
+	q.OrderBy(sortField, order, clientSortFields, "id").Page(start, length)
 	// 查询总数
-	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM clients %s", where)
+	countQuery, countArgs := q.CountSql()
 	fmt.Println("SQL Query for count:", countQuery)
 	var cnt int
-	if err := s.SqlDB.QueryRow(countQuery).Scan(&cnt); err != nil {
+	if err := s.SqlDB.QueryRow(countQuery, countArgs...).Scan(&cnt); err != nil {
 		panic(err)
 	}
 	// 查询数据
-	query := fmt.Sprintf("SELECT id, verify_key, remark, IFNULL(inlet_flow, 0) as inlet_flow,status,account_id FROM clients %s ORDER BY %s %s LIMIT %d, %d", where, sortField, order, start, length)
+	query, args := q.SelectSql("id, verify_key, remark, IFNULL(inlet_flow, 0) as inlet_flow,status,account_id")
 	fmt.Println("SQL Query for data:", query)
-	rows, err := s.SqlDB.Query(query)
+	rows, err := s.SqlDB.Query(query, args...)
 	if err != nil {
 		panic(err)
 	}
@@ -223,11 +230,21 @@ func (s *DbUtils) UpdateTask(t *Tunnel) error {
 	return err
 }
 
+// globalConfig globals 表中 config 字段保存的内容
+type globalConfig struct {
+	BlackIpList []string
+	RequireTotp bool `json:",omitempty"`
+}
+
 // SaveGlobal 保存全局配置信息
 func (s *DbUtils) SaveGlobal(t *Glob) error {
-	updateQuery := "UPDATE globals SET config = ? LIMIT 1"
-	fmt.Println("SQL Exec:", updateQuery, "with parameter:", "")
-	_, err := s.SqlDB.Exec(updateQuery, "")
+	b, err := json.Marshal(&globalConfig{BlackIpList: t.BlackIpList, RequireTotp: t.RequireTotp})
+	if err != nil {
+		return err
+	}
+	updateQuery := "UPDATE globals SET config = ? WHERE id = 1"
+	fmt.Println("SQL Exec:", updateQuery, "with parameter:", string(b))
+	_, err = s.SqlDB.Exec(updateQuery, string(b))
 	return err
 }
 
@@ -368,22 +385,20 @@ func (s *DbUtils) NewHost(t *Host) error {
 
 // GetHost 按条件获取 host 列表及总数
 func (s *DbUtils) GetHost(start, length int, id int, search string) ([]*Host, int, error) {
-	where := "WHERE 1=1"
-	if search != "" {
-		where += fmt.Sprintf(" AND (id = '%s' OR host LIKE '%%%s%%' OR remark LIKE '%%%s%%')", search, search, search)
-	}
+	q := NewQuery("tasks").Search(search, []string{"id"}, []string{"host", "remark"})
 	if id != 0 {
-		where += fmt.Sprintf(" AND client_id = %d", id)
+		q.Where("client_id = ?", id)
 	}
-	countQuery := "SELECT COUNT(*) FROM tasks " + where
+	q.OrderBy("", "", nil, "id").Page(start, length)
+	countQuery, countArgs := q.CountSql()
 	fmt.Println("SQL Query for count:", countQuery)
 	var cnt int
-	if err := s.SqlDB.QueryRow(countQuery).Scan(&cnt); err != nil {
+	if err := s.SqlDB.QueryRow(countQuery, countArgs...).Scan(&cnt); err != nil {
 		return nil, 0, err
 	}
-	query := fmt.Sprintf("SELECT id, host, location, scheme, remark,client_id,account_id FROM tasks %s LIMIT %d, %d", where, start, length)
+	query, args := q.SelectSql("id, host, location, scheme, remark,client_id,account_id")
 	fmt.Println("SQL Query for data:", query)
-	rows, err := s.SqlDB.Query(query)
+	rows, err := s.SqlDB.Query(query, args...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -473,10 +488,10 @@ func (s *DbUtils) IsPubClient(id int) bool {
 
 // GetClient 根据 ID 获取客户端记录
 func (s *DbUtils) GetClient(id int) (*Client, error) {
-	query := "SELECT id, verify_key, web_user_name, rate_limit, remark, no_display FROM clients WHERE id = ? LIMIT 1"
+	query := "SELECT id, account_id, verify_key, web_user_name, rate_limit, remark, no_display FROM clients WHERE id = ? LIMIT 1"
 	fmt.Println("SQL Query:", query, "with parameter:", id)
 	var c Client
-	err := s.SqlDB.QueryRow(query, id).Scan(&c.Id, &c.VerifyKey, &c.WebUserName, &c.RateLimit, &c.Remark, &c.NoDisplay)
+	err := s.SqlDB.QueryRow(query, id).Scan(&c.Id, &c.AccountId, &c.VerifyKey, &c.WebUserName, &c.RateLimit, &c.Remark, &c.NoDisplay)
 	if err != nil {
 		return nil, errors.New("未找到客户端")
 	}
@@ -490,8 +505,16 @@ func (s *DbUtils) GetClient(id int) (*Client, error) {
 
 // GetGlobal 获取全局配置信息
 func (s *DbUtils) GetGlobal() *Glob {
-	// 外部 Glob 类型不包含 Config 字段，此处直接返回空的 Glob
-	return &Glob{}
+	var config string
+	if err := s.SqlDB.QueryRow("SELECT IFNULL(config, '') FROM globals WHERE id = 1").Scan(&config); err != nil || config == "" {
+		return &Glob{}
+	}
+	var c globalConfig
+	if err := json.Unmarshal([]byte(config), &c); err != nil {
+		logs.Error("parse global config error", err)
+		return &Glob{}
+	}
+	return &Glob{BlackIpList: c.BlackIpList, RequireTotp: c.RequireTotp}
 }
 
 // GetNewClientId 获取新的客户端ID
@@ -521,32 +544,28 @@ func (s *DbUtils) GetNewHostId() int {
 func (s *DbUtils) CreateOrder(order *Order) error {
 	insertQuery := `INSERT INTO orders (
 		app_id, order_amount, months, order_status, 
-		payment_type, external_transaction_id,  account_id,flow
-	) VALUES ( ?, ?, ?, ?, ?, ?,?,?)`
+		payment_type, external_transaction_id,  account_id,flow, plan_id, created_at,
+		coupon_code, discount, bonus_flow
+	) VALUES ( ?, ?, ?, ?, ?, ?,?,?,?,?,?,?,?)`
 
-	_, err := s.SqlDB.Exec(
+	if order.CreatedAt == 0 {
+		order.CreatedAt = time.Now().Unix()
+	}
+	res, err := s.SqlDB.Exec(
 		insertQuery,
 		order.AppId, order.OrderAmount, order.Months, order.OrderStatus,
-		order.PaymentType, order.ExternalTransactionId, order.AccountId, order.Flow,
+		order.PaymentType, order.ExternalTransactionId, order.AccountId, order.Flow, order.PlanId, order.CreatedAt,
+		order.CouponCode, order.Discount, order.BonusFlow,
 	)
+	if err != nil {
+		return err
+	}
+	order.OrderId, err = res.LastInsertId()
 	return err
 }
 
 func (s *DbUtils) GetOrderById(orderId int64) (*Order, error) {
-	query := `SELECT 
-		order_id, app_id, order_amount, months, order_status,
-		payment_type, external_transaction_id, created_at, account_id
-		FROM orders WHERE order_id = ? LIMIT 1`
-
-	var order Order
-	err := s.SqlDB.QueryRow(query, orderId).Scan(
-		&order.OrderId, &order.AppId, &order.OrderAmount, &order.Months, &order.OrderStatus,
-		&order.PaymentType, &order.ExternalTransactionId, &order.CreatedAt, &order.AccountId,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return &order, nil
+	return scanOrder(s.SqlDB.QueryRow("SELECT "+orderColumns+" FROM orders WHERE order_id = ? LIMIT 1", orderId))
 }
 
 func (s *DbUtils) GetNewOrderId() int64 {
@@ -597,6 +616,57 @@ func (s *DbUtils) GetAllTasks() ([]*Tunnel, error) {
 	return tasks, nil
 }
 
+// GetTunnelList 按模式、客户端与搜索条件分页获取隧道列表及总数
+// mode 为空时只返回 clientId 对应客户端的隧道
+func (s *DbUtils) GetTunnelList(start, length int, mode string, clientId int, search, sortField, order string) ([]*Tunnel, int, error) {
+	q := NewQuery("tasks").Where("status = 1")
+	if mode != "" {
+		q.Where("mode = ?", mode)
+		if clientId != 0 {
+			q.Where("client_id = ?", clientId)
+		}
+	} else {
+		q.Where("client_id = ?", clientId)
+	}
+	q.Search(search, []string{"id", "port"}, []string{"password", "remark", "target"})
+	q.OrderBy(sortField, order, tunnelSortFields, "id").Page(start, length)
+
+	countQuery, countArgs := q.CountSql()
+	fmt.Println("SQL Query for count:", countQuery)
+	var cnt int
+	if err := s.SqlDB.QueryRow(countQuery, countArgs...).Scan(&cnt); err != nil {
+		return nil, 0, err
+	}
+	query, args := q.SelectSql(`id, account_id, port, server_ip, mode, status, run_status, client_id,
+		ports, password, remark, target_addr, no_store, is_http, local_path,
+		strip_pre, header_change, host_change, location, host, scheme,
+		IFNULL(cert_file_path, ''), IFNULL(key_file_path, ''), is_close, auto_https, IFNULL(target, ''), external_service_domain`)
+	fmt.Println("SQL Query for data:", query)
+	rows, err := s.SqlDB.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var tasks []*Tunnel
+	for rows.Next() {
+		var t Tunnel
+		t.Target = &Target{}
+		t.Flow = &Flow{}
+		if err := rows.Scan(
+			&t.Id, &t.AccountId, &t.Port, &t.ServerIp, &t.Mode, &t.Status, &t.RunStatus, &t.ClientId,
+			&t.Ports, &t.Password, &t.Remark, &t.TargetAddr, &t.NoStore, &t.IsHttp, &t.LocalPath,
+			&t.StripPre, &t.HeaderChange, &t.HostChange, &t.Location, &t.Host, &t.Scheme,
+			&t.CertFilePath, &t.KeyFilePath, &t.IsClose, &t.AutoHttps, &t.Target.TargetStr, &t.ExternalServiceDomain,
+		); err != nil {
+			return nil, 0, err
+		}
+		t.Client = &Client{Id: t.ClientId, Flow: &Flow{}, Cnf: &Config{}}
+		tasks = append(tasks, &t)
+	}
+	return tasks, cnt, nil
+}
+
 func (s *DbUtils) GetUserTasks(accountId int, clientId int) ([]*Tunnel, error) {
 	// 查询指定账户的任务记录，使用完整的字段列表
 	query := `SELECT 
@@ -639,10 +709,10 @@ func (s *DbUtils) GetUserTasks(accountId int, clientId int) ([]*Tunnel, error) {
 }
 
 func (s *DbUtils) GetByUsername(username string) (*Account, error) {
-	query := "SELECT id, web_user_name, IFNULL(web_password, '') as web_password, IFNULL(nick_name, '') as nick_name, IFNULL(head_img_url, '') as head_img_url, rate_limit, remark FROM accounts WHERE status = 1 and web_user_name = ?"
+	query := "SELECT id, web_user_name, IFNULL(web_password, '') as web_password, IFNULL(nick_name, '') as nick_name, IFNULL(head_img_url, '') as head_img_url, rate_limit, remark, role, reseller_id FROM accounts WHERE status = 1 and web_user_name = ?"
 	fmt.Println("SQL Query:", query, "with parameter:", username)
 	var account Account
-	err := s.SqlDB.QueryRow(query, username).Scan(&account.Id, &account.WebUserName, &account.WebPassword, &account.NickName, &account.HeadImgUrl, &account.RateLimit, &account.Remark)
+	err := s.SqlDB.QueryRow(query, username).Scan(&account.Id, &account.WebUserName, &account.WebPassword, &account.NickName, &account.HeadImgUrl, &account.RateLimit, &account.Remark, &account.Role, &account.ResellerId)
 
 	if err != nil {
 		fmt.Println("GetByUsername err:", err)
@@ -652,10 +722,10 @@ func (s *DbUtils) GetByUsername(username string) (*Account, error) {
 }
 
 func (s *DbUtils) GetByUsernameNoErr(username string) *Account {
-	query := "SELECT id, web_user_name, IFNULL(web_password, '') as web_password, IFNULL(nick_name, '') as nick_name, IFNULL(head_img_url, '') as head_img_url, rate_limit, remark FROM accounts WHERE status = 1 and web_user_name = ?"
+	query := "SELECT id, web_user_name, IFNULL(web_password, '') as web_password, IFNULL(nick_name, '') as nick_name, IFNULL(head_img_url, '') as head_img_url, rate_limit, remark, role, reseller_id FROM accounts WHERE status = 1 and web_user_name = ?"
 	fmt.Println("SQL Query:", query, "with parameter:", username)
 	var account Account
-	err := s.SqlDB.QueryRow(query, username).Scan(&account.Id, &account.WebUserName, &account.WebPassword, &account.NickName, &account.HeadImgUrl, &account.RateLimit, &account.Remark)
+	err := s.SqlDB.QueryRow(query, username).Scan(&account.Id, &account.WebUserName, &account.WebPassword, &account.NickName, &account.HeadImgUrl, &account.RateLimit, &account.Remark, &account.Role, &account.ResellerId)
 
 	if err != nil {
 		fmt.Println("GetByUsername err:", err)
@@ -664,7 +734,7 @@ func (s *DbUtils) GetByUsernameNoErr(username string) *Account {
 }
 
 func (s *DbUtils) GetAllClients() ([]*Client, error) {
-	query := "SELECT id, verify_key, web_user_name, IFNULL(web_password, ''), rate_limit, remark, no_display FROM clients WHERE status = 1"
+	query := "SELECT id, account_id, verify_key, web_user_name, IFNULL(web_password, ''), rate_limit, remark, no_display FROM clients WHERE status = 1"
 	rows, err := s.SqlDB.Query(query)
 	if err != nil {
 		fmt.Println("GetAllClients err:", err)
@@ -676,7 +746,7 @@ func (s *DbUtils) GetAllClients() ([]*Client, error) {
 	for rows.Next() {
 		var c Client
 		var webPassword string
-		if err := rows.Scan(&c.Id, &c.VerifyKey, &c.WebUserName, &webPassword, &c.RateLimit, &c.Remark, &c.NoDisplay); err != nil {
+		if err := rows.Scan(&c.Id, &c.AccountId, &c.VerifyKey, &c.WebUserName, &webPassword, &c.RateLimit, &c.Remark, &c.NoDisplay); err != nil {
 			return nil, err
 		}
 		c.WebPassword = webPassword
@@ -700,10 +770,94 @@ func (s *DbUtils) NewAccount(c *Account) error {
 		c.Rate = rate.NewRate(int64(c.RateLimit * 1024))
 	}
 	c.Rate.Start()
+	password, err := hashWebPassword(c.WebPassword)
+	if err != nil {
+		return err
+	}
+	c.WebPassword = password
+
+	if c.Role == "" {
+		c.Role = RoleOwner
+	} else if !ValidRole(c.Role) {
+		return errors.New("invalid role " + c.Role)
+	}
+
+	insertQuery := "INSERT INTO accounts (web_user_name, web_password,nick_name,head_img_url, rate_limit, remark, role, reseller_id, email, email_verified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	fmt.Println("SQL Exec:", insertQuery, "with parameters:", c.WebUserName, c.NickName, c.HeadImgUrl, c.RateLimit, c.Remark, c.Role, c.ResellerId, c.Email, c.EmailVerified)
+	res, err := s.SqlDB.Exec(insertQuery, c.WebUserName, c.WebPassword, c.NickName, c.HeadImgUrl, c.RateLimit, c.Remark, c.Role, c.ResellerId, c.Email, c.EmailVerified)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	c.Id = int(id)
+	return err
+}
+
+// hashWebPassword 返回保存到数据库的 web 登录密码，明文密码转换为 bcrypt 哈希，空密码与已是哈希的保持不变
+func hashWebPassword(password string) (string, error) {
+	if password == "" || crypt.IsPasswordHash(password) {
+		return password, nil
+	}
+	return crypt.HashPassword(password)
+}
 
-	insertQuery := "INSERT INTO accounts (web_user_name, web_password,nick_name,head_img_url, rate_limit, remark) VALUES (?, ?, ?, ?, ?, ?)"
-	fmt.Println("SQL Exec:", insertQuery, "with parameters:", c.WebUserName, c.WebPassword, c.NickName, c.HeadImgUrl, c.RateLimit, c.Remark)
-	_, err := s.SqlDB.Exec(insertQuery, c.WebUserName, c.WebPassword, c.NickName, c.HeadImgUrl, c.RateLimit, c.Remark)
+// UpdatePassword 修改账号的 web 登录密码，明文密码保存为哈希
+func (s *DbUtils) UpdatePassword(accountId int, password string) error {
+	hash, err := hashWebPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = s.SqlDB.Exec("UPDATE accounts SET web_password = ? WHERE id = ?", hash, accountId)
+	return err
+}
+
+// GetAllAccounts 获取全部账号
+func (s *DbUtils) GetAllAccounts() ([]*Account, error) {
+	query := `SELECT id, web_user_name, web_password, nick_name, head_img_url, rate_limit, remark, status,
+		flow, IFNULL(expire_time, ''), allowance, allowance_left, reset_day, last_reset, role, reseller_id FROM accounts ORDER BY id`
+	rows, err := s.SqlDB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []*Account
+	for rows.Next() {
+		a := NewAccount()
+		var flow, allowance, allowanceLeft float64
+		if err := rows.Scan(&a.Id, &a.WebUserName, &a.WebPassword, &a.NickName, &a.HeadImgUrl, &a.RateLimit, &a.Remark, &a.Status,
+			&flow, &a.ExpireTime, &allowance, &allowanceLeft, &a.ResetDay, &a.LastReset, &a.Role, &a.ResellerId); err != nil {
+			return nil, err
+		}
+		a.Flow.FlowLimit = int64(flow)
+		a.Allowance, a.AllowanceLeft = int64(allowance), int64(allowanceLeft)
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// UpdateAccount 更新账号信息，ExpireTime 为空表示不限制到期时间
+func (s *DbUtils) UpdateAccount(a *Account) error {
+	if a.ResetDay < 0 || a.ResetDay > 31 {
+		return fmt.Errorf("invalid reset day %d, must be between 0 and 31", a.ResetDay)
+	}
+	var flow int64
+	if a.Flow != nil {
+		flow = a.Flow.FlowLimit
+	}
+	password, err := hashWebPassword(a.WebPassword)
+	if err != nil {
+		return err
+	}
+	a.WebPassword = password
+	// 调整每月额度时按差值调整本周期剩余额度，allowance_left 须在 allowance 之前赋值，MySQL 按顺序计算
+	query := `UPDATE accounts SET web_user_name = ?, web_password = ?, nick_name = ?, head_img_url = ?, rate_limit = ?,
+		remark = ?, status = ?, flow = ?, expire_time = NULLIF(?, ''),
+		allowance_left = CASE WHEN allowance_left + ? - allowance < 0 THEN 0 ELSE allowance_left + ? - allowance END,
+		allowance = ?, reset_day = ? WHERE id = ?`
+	fmt.Println("SQL Exec:", query, "with parameters:", a.WebUserName, a.RateLimit, a.Remark, a.Status, flow, a.ExpireTime, a.Allowance, a.ResetDay, a.Id)
+	_, err = s.SqlDB.Exec(query, a.WebUserName, a.WebPassword, a.NickName, a.HeadImgUrl, a.RateLimit,
+		a.Remark, a.Status, flow, a.ExpireTime, a.Allowance, a.Allowance, a.Allowance, a.ResetDay, a.Id)
 	return err
 }
 
@@ -818,6 +972,18 @@ func (s *DbUtils) GetHostById(id int) (*Host, error) {
 
 // GetInfoByHost 根据请求中的 host 与 URL 信息返回匹配的 host 记录
 func (s *DbUtils) GetInfoByHost(host string, r *http.Request) (*Host, error) {
+	hosts, err := s.GetHostsByDomain(host, r.URL.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	if h := MatchHostLocation(hosts, r.RequestURI); h != nil {
+		return h, nil
+	}
+	return nil, errors.New("The host could not be parsed")
+}
+
+// GetHostsByDomain 返回域名在该 scheme 下所有启用的 host 记录，同一域名的不同 location 对应不同的记录
+func (s *DbUtils) GetHostsByDomain(host, scheme string) ([]*Host, error) {
 	ip := common.GetIpByAddr(host)
 
 	// 查询匹配的host记录，使用更多字段
@@ -826,19 +992,16 @@ func (s *DbUtils) GetInfoByHost(host string, r *http.Request) (*Host, error) {
 		no_store, is_close, auto_https, IFNULL(target, '')
 		FROM tasks t1 WHERE host = ? AND scheme IN (?, 'all') AND is_close = 0`
 
-	fmt.Println("SQL Query1:", query, "with parameters:", ip, r.URL.Scheme)
-	logs.Info("SQL Query2:%s %s %s  %s ", query, "with parameters:", ip, r.URL.Scheme)
-	rows, err := s.SqlDB.Query(query, ip, r.URL.Scheme)
+	logs.Debug("query hosts of %s with scheme %s", ip, scheme)
+	rows, err := s.SqlDB.Query(query, ip, scheme)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var selected *Host
+	var hosts []*Host
 	for rows.Next() {
-		var h Host
-		h.Target = &Target{}
-		h.Flow = &Flow{}
+		h := &Host{Target: &Target{}, Flow: &Flow{}}
 		var clientId int
 		var accountId int
 
@@ -850,7 +1013,8 @@ func (s *DbUtils) GetInfoByHost(host string, r *http.Request) (*Host, error) {
 			continue
 		}
 		// 初始化Client对象
-		h.Client = &Client{Id: clientId}
+		// 域名解析的流量记入其所属账号
+		h.Client = &Client{Id: clientId, AccountId: accountId}
 		h.Client.Cnf = &Config{}
 		h.Client.Flow = &Flow{}
 		h.AccountId = accountId
@@ -858,37 +1022,27 @@ func (s *DbUtils) GetInfoByHost(host string, r *http.Request) (*Host, error) {
 		if h.Location == "" {
 			h.Location = "/"
 		}
+		hosts = append(hosts, h)
+	}
+	return hosts, rows.Err()
+}
 
-		// 选择最匹配的记录
-		if strings.HasPrefix(r.RequestURI, h.Location) {
+// MatchHostLocation 返回 location 与请求路径最长匹配的记录，没有匹配时返回 nil
+func MatchHostLocation(hosts []*Host, requestUri string) *Host {
+	var selected *Host
+	for _, h := range hosts {
+		if strings.HasPrefix(requestUri, h.Location) {
 			if selected == nil || len(h.Location) > len(selected.Location) {
-				selected = &h
+				selected = h
 			}
 		}
 	}
-
-	if selected != nil {
-		return selected, nil
-	}
-	return nil, errors.New("The host could not be parsed")
+	return selected
 }
 
 // GetOrderByExternalId 根据外部交易ID获取订单
 func (s *DbUtils) GetOrderByExternalId(externalId string) (*Order, error) {
-	query := `SELECT 
-		order_id, app_id, order_amount, flow, months, order_status,
-		payment_type, external_transaction_id, account_id
-		FROM orders WHERE external_transaction_id = ? LIMIT 1`
-
-	var order Order
-	err := s.SqlDB.QueryRow(query, externalId).Scan(
-		&order.OrderId, &order.AppId, &order.OrderAmount, &order.Flow, &order.Months, &order.OrderStatus,
-		&order.PaymentType, &order.ExternalTransactionId, &order.AccountId,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return &order, nil
+	return scanOrder(s.SqlDB.QueryRow("SELECT "+orderColumns+" FROM orders WHERE external_transaction_id = ? LIMIT 1", externalId))
 }
 
 // UpdateOrder 更新订单状态
@@ -905,26 +1059,50 @@ func (s *DbUtils) AddTraffic(accountId int, flow float64) error {
 	return err
 }
 
-// AddMonths 给账号添加月数
-func (s *DbUtils) AddMonths(accountId int, months int) error {
-	query := `UPDATE accounts SET expire_time = DATE_ADD(
-		CASE 
+// addMonthsSql 延长到期时间的语句，已到期或未设置到期时间的从当前时间开始计算
+func (s *DbUtils) addMonthsSql() string {
+	if s.driver == DriverSqlite {
+		return `UPDATE accounts SET expire_time = datetime(
+			CASE
+				WHEN expire_time IS NULL OR expire_time < datetime('now', 'localtime') THEN datetime('now', 'localtime')
+				ELSE expire_time
+			END,
+			'+' || ? || ' months'
+		) WHERE id = ?`
+	}
+	return `UPDATE accounts SET expire_time = DATE_ADD(
+		CASE
 			WHEN expire_time IS NULL OR expire_time < NOW() THEN NOW()
-			ELSE expire_time 
-		END, 
+			ELSE expire_time
+		END,
 		INTERVAL ? MONTH
 	) WHERE id = ?`
-	_, err := s.SqlDB.Exec(query, months, accountId)
+}
+
+// subMonthsSql 缩短到期时间的语句，用于退款撤销月数
+func (s *DbUtils) subMonthsSql() string {
+	if s.driver == DriverSqlite {
+		return "UPDATE accounts SET expire_time = datetime(expire_time, '-' || ? || ' months') WHERE id = ? AND expire_time IS NOT NULL AND expire_time <> ''"
+	}
+	return "UPDATE accounts SET expire_time = DATE_SUB(expire_time, INTERVAL ? MONTH) WHERE id = ? AND expire_time IS NOT NULL"
+}
+
+// AddMonths 给账号添加月数
+func (s *DbUtils) AddMonths(accountId int, months int) error {
+	_, err := s.SqlDB.Exec(s.addMonthsSql(), months, accountId)
 	return err
 }
 
 // GetAccountInfo 获取完整账户信息
 func (s *DbUtils) GetAccountInfo(accountId int) (*Account, error) {
-	query := "SELECT id, web_user_name, IFNULL(web_password, '') as web_password, flow, IFNULL(expire_time, '') as expire_time, rate_limit, remark FROM accounts WHERE id = ?"
+	query := `SELECT id, web_user_name, IFNULL(web_password, '') as web_password, flow, IFNULL(expire_time, '') as expire_time, rate_limit, remark, plan_id, max_clients, max_tunnels,
+		allowance, allowance_left, reset_day, last_reset, status, role, reseller_id,
+		IFNULL(nick_name, ''), IFNULL(head_img_url, ''), email, email_verified FROM accounts WHERE id = ?`
 	var account Account
 	account.Flow = new(Flow) // 初始化Flow对象
 
 	var flowStr, expireTimeStr string
+	var allowance, allowanceLeft float64
 	err := s.SqlDB.QueryRow(query, accountId).Scan(
 		&account.Id,
 		&account.WebUserName,
@@ -933,10 +1111,25 @@ func (s *DbUtils) GetAccountInfo(accountId int) (*Account, error) {
 		&expireTimeStr,
 		&account.RateLimit,
 		&account.Remark,
+		&account.PlanId,
+		&account.MaxClientNum,
+		&account.MaxTunnelNum,
+		&allowance,
+		&allowanceLeft,
+		&account.ResetDay,
+		&account.LastReset,
+		&account.Status,
+		&account.Role,
+		&account.ResellerId,
+		&account.NickName,
+		&account.HeadImgUrl,
+		&account.Email,
+		&account.EmailVerified,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("获取账户信息失败: %v", err)
 	}
+	account.Allowance, account.AllowanceLeft = int64(allowance), int64(allowanceLeft)
 
 	// 将字符串类型的flow转换为float64 (单位KB)
 	flow, err := strconv.ParseFloat(flowStr, 64)
@@ -953,27 +1146,28 @@ func (s *DbUtils) GetAccountInfo(accountId int) (*Account, error) {
 	return &account, nil
 }
 
+// GetAccountFlowLimit 返回账号剩余可用的流量字节数，为本周期剩余额度与购买的流量余额之和
 func (s *DbUtils) GetAccountFlowLimit(accountID int) (int64, error) {
-	query := "SELECT flow FROM accounts WHERE id = ?"
-	var flowStr string
-	err := s.SqlDB.QueryRow(query, accountID).Scan(&flowStr)
+	var flow, allowanceLeft float64
+	err := s.SqlDB.QueryRow("SELECT IFNULL(flow, 0), allowance_left FROM accounts WHERE id = ?", accountID).Scan(&flow, &allowanceLeft)
 	if err != nil {
 		return 0, err
 	}
-
-	// 处理空值情况
-	if flowStr == "" {
-		return 0, nil
-	}
-
-	// 解析字符串为float64
-	flow, err := strconv.ParseFloat(flowStr, 64)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse flow limit: %v", err)
+	if flow < 0 {
+		flow = 0
 	}
+	// 账号流量以 KB 为单位，转换为字节
+	return int64((flow + allowanceLeft) * (1 << 10)), nil
+}
 
-	// 转换为字节 (1MB = 1<<20 bytes)
-	return int64(flow * (1 << 20)), nil
+// ConsumeTraffic 扣除账号使用的流量(KB)，先扣本周期剩余额度，不足部分从购买的流量余额中扣除
+// flow 须在 allowance_left 之前赋值，MySQL 按顺序计算，SQLite 均使用更新前的值
+func (s *DbUtils) ConsumeTraffic(accountId int, kb float64) error {
+	_, err := s.SqlDB.Exec(`UPDATE accounts SET
+		flow = IFNULL(flow, 0) - (CASE WHEN ? > allowance_left THEN ? - allowance_left ELSE 0 END),
+		allowance_left = CASE WHEN ? > allowance_left THEN 0 ELSE allowance_left - ? END
+		WHERE id = ?`, kb, kb, kb, kb, accountId)
+	return err
 }
 
 func (s *DbUtils) UpdateHost(h *Host) error {
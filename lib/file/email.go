@@ -0,0 +1,76 @@
+package file
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// 一次性链接的用途
+const (
+	TokenVerifyEmail   = "verify" // 验证邮箱
+	TokenResetPassword = "reset"  // 找回密码
+)
+
+// ErrTokenInvalid 链接不存在、已使用或已过期
+var ErrTokenInvalid = errors.New("the link is invalid or has expired")
+
+// EmailStore 邮箱验证与找回密码相关的存储操作
+type EmailStore interface {
+	SetAccountEmail(accountId int, email string, verified bool) error
+	GetAccountIdByEmail(email string) (int, error)
+	CreateAccountToken(accountId int, purpose, hash, email string, expireAt int64) error
+	UseAccountToken(purpose, hash string, now time.Time) (accountId int, email string, err error)
+}
+
+// SetAccountEmail 修改账号邮箱与验证状态
+func (s *DbUtils) SetAccountEmail(accountId int, email string, verified bool) error {
+	_, err := s.SqlDB.Exec("UPDATE accounts SET email = ?, email_verified = ? WHERE id = ?", email, verified, accountId)
+	return err
+}
+
+// GetAccountIdByEmail 返回已验证该邮箱的启用账号，没有时返回 0
+func (s *DbUtils) GetAccountIdByEmail(email string) (int, error) {
+	var id int
+	err := s.SqlDB.QueryRow("SELECT id FROM accounts WHERE email = ? AND email_verified = 1 AND status = 1 ORDER BY id LIMIT 1", email).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// CreateAccountToken 保存一次性链接的 token，同一账号同一用途之前未使用的链接失效
+func (s *DbUtils) CreateAccountToken(accountId int, purpose, hash, email string, expireAt int64) error {
+	tx, err := s.SqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	if _, err := tx.Exec("UPDATE account_tokens SET used_at = ? WHERE account_id = ? AND purpose = ? AND used_at = 0", now, accountId, purpose); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO account_tokens (token_hash, account_id, purpose, email, expire_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		hash, accountId, purpose, email, expireAt, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseAccountToken 使用一次性链接，返回所属账号与生成链接时的邮箱，每个链接只能使用一次
+func (s *DbUtils) UseAccountToken(purpose, hash string, now time.Time) (int, string, error) {
+	res, err := s.SqlDB.Exec("UPDATE account_tokens SET used_at = ? WHERE token_hash = ? AND purpose = ? AND used_at = 0 AND expire_at > ?",
+		now.Unix(), hash, purpose, now.Unix())
+	if err != nil {
+		return 0, "", err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, "", err
+	} else if n == 0 {
+		return 0, "", ErrTokenInvalid
+	}
+	var id int
+	var email string
+	err = s.SqlDB.QueryRow("SELECT account_id, email FROM account_tokens WHERE token_hash = ?", hash).Scan(&id, &email)
+	return id, email, err
+}
@@ -0,0 +1,66 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountEmail(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "user")
+	if a, err := db.GetAccountInfo(id); err != nil || a.Email != "" || !a.EmailVerified {
+		t.Fatalf("new account %+v %v", a, err)
+	}
+	a := NewAccount()
+	a.WebUserName, a.WebPassword, a.Email, a.EmailVerified = "reg", "pass", "reg@example.com", false
+	if err := db.NewAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetAccountInfo(a.Id); err != nil || got.Email != "reg@example.com" || got.EmailVerified {
+		t.Fatalf("registered account %+v %v", got, err)
+	}
+	if got, _ := db.GetAccountIdByEmail("reg@example.com"); got != 0 {
+		t.Fatalf("unverified email found account %d", got)
+	}
+	if err := db.SetAccountEmail(a.Id, "reg@example.com", true); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetAccountIdByEmail("reg@example.com"); err != nil || got != a.Id {
+		t.Fatalf("GetAccountIdByEmail %d %v", got, err)
+	}
+}
+
+func TestAccountToken(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "user")
+	now := time.Now()
+	exp := now.Add(time.Hour).Unix()
+	if err := db.CreateAccountToken(id, TokenResetPassword, "h1", "a@example.com", exp); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.UseAccountToken(TokenVerifyEmail, "h1", now); err != ErrTokenInvalid {
+		t.Fatalf("token used for another purpose %v", err)
+	}
+	if got, email, err := db.UseAccountToken(TokenResetPassword, "h1", now); err != nil || got != id || email != "a@example.com" {
+		t.Fatalf("UseAccountToken %d %s %v", got, email, err)
+	}
+	if _, _, err := db.UseAccountToken(TokenResetPassword, "h1", now); err != ErrTokenInvalid {
+		t.Fatalf("token used twice %v", err)
+	}
+
+	if err := db.CreateAccountToken(id, TokenResetPassword, "h2", "a@example.com", exp); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateAccountToken(id, TokenResetPassword, "h3", "a@example.com", exp); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.UseAccountToken(TokenResetPassword, "h2", now); err != ErrTokenInvalid {
+		t.Fatalf("replaced token accepted %v", err)
+	}
+	if _, _, err := db.UseAccountToken(TokenResetPassword, "h3", now.Add(2*time.Hour)); err != ErrTokenInvalid {
+		t.Fatalf("expired token accepted %v", err)
+	}
+	if _, _, err := db.UseAccountToken(TokenResetPassword, "h3", now); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,244 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"ehang.io/nps/lib/common"
+)
+
+// 旧版基于文件存储的 nps 使用的数据文件
+const (
+	LegacyClientsFile = "clients.json"
+	LegacyTasksFile   = "tasks.json"
+	LegacyHostsFile   = "hosts.json"
+)
+
+// ImportConflict 导入时发现的冲突记录，冲突记录不会被写入
+type ImportConflict struct {
+	File   string
+	Id     int
+	Reason string
+}
+
+func (c *ImportConflict) String() string {
+	return fmt.Sprintf("%s id %d: %s", c.File, c.Id, c.Reason)
+}
+
+// ImportReport 导入结果
+type ImportReport struct {
+	DryRun    bool
+	Clients   int
+	Tasks     int
+	Hosts     int
+	Conflicts []*ImportConflict
+}
+
+func (r *ImportReport) conflict(file string, id int, format string, a ...interface{}) {
+	r.Conflicts = append(r.Conflicts, &ImportConflict{File: file, Id: id, Reason: fmt.Sprintf(format, a...)})
+}
+
+// LoadLegacyFile 读取旧版数据文件，文件中每条记录为一个 JSON 对象，以 CONN_DATA_SEQ 分隔
+// 文件不存在时视为没有记录
+func LoadLegacyFile(path string, f func(value string) error) error {
+	b, err := common.ReadAllFromFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, v := range strings.Split(string(b), common.CONN_DATA_SEQ) {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if err := f(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportLegacyJson 将 dir 下的 clients.json、tasks.json、hosts.json 导入到 store
+// 旧文件中的隧道与域名解析 id 各自独立编号，而当前存储将两者保存在同一张表中，因此统一重新分配 id；
+// 客户端 id 未被占用时保留原值，否则重新分配，并同步修改其隧道与域名解析的归属。
+// dryRun 为 true 时只检查冲突，不写入任何数据
+func ImportLegacyJson(store Store, dir string, dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{DryRun: dryRun}
+	clientIds := make(map[int]int) // 旧客户端 id -> 导入后的客户端 id
+	vkeys := make(map[string]int)
+	nextClientId := store.GetNewClientId()
+	nextTaskId := store.GetNewTaskId()
+
+	err := LoadLegacyFile(filepath.Join(dir, LegacyClientsFile), func(value string) error {
+		c := new(Client)
+		if err := json.Unmarshal([]byte(value), c); err != nil {
+			return fmt.Errorf("%s: %v", LegacyClientsFile, err)
+		}
+		if id, ok := vkeys[c.VerifyKey]; ok {
+			report.conflict(LegacyClientsFile, c.Id, "duplicate vkey %s in file, tunnels are attached to client %d", c.VerifyKey, id)
+			clientIds[c.Id] = id
+			return nil
+		}
+		if !store.VerifyVkey(c.VerifyKey, 0) {
+			id, _ := store.GetClientIdByVkey(c.VerifyKey)
+			report.conflict(LegacyClientsFile, c.Id, "vkey %s already exists, tunnels are attached to client %d", c.VerifyKey, id)
+			clientIds[c.Id] = id
+			vkeys[c.VerifyKey] = id
+			return nil
+		}
+		oldId := c.Id
+		if _, err := store.GetClient(c.Id); err == nil || c.Id <= 0 {
+			c.Id = nextClientId
+		}
+		if c.Id >= nextClientId {
+			nextClientId = c.Id + 1
+		}
+		clientIds[oldId] = c.Id
+		vkeys[c.VerifyKey] = c.Id
+		if c.Cnf == nil {
+			c.Cnf = new(Config)
+		}
+		if c.Flow == nil {
+			c.Flow = new(Flow)
+		}
+		c.Rate = nil
+		if !dryRun {
+			if err := store.NewClient(c); err != nil {
+				return fmt.Errorf("%s: client %d: %v", LegacyClientsFile, oldId, err)
+			}
+		}
+		report.Clients++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	existing, err := store.GetAllTasks()
+	if err != nil {
+		return report, err
+	}
+	ports := make(map[int]bool)
+	passwords := make(map[string]bool)
+	for _, t := range existing {
+		if t.Mode == "secret" || t.Mode == "p2p" {
+			passwords[t.Password] = true
+		} else if t.Port != 0 {
+			ports[t.Port] = true
+		}
+	}
+
+	err = LoadLegacyFile(filepath.Join(dir, LegacyTasksFile), func(value string) error {
+		t := new(Tunnel)
+		if err := json.Unmarshal([]byte(value), t); err != nil {
+			return fmt.Errorf("%s: %v", LegacyTasksFile, err)
+		}
+		oldId := t.Id
+		if t.Client == nil {
+			report.conflict(LegacyTasksFile, oldId, "the tunnel has no client")
+			return nil
+		}
+		clientId, ok := clientIds[t.Client.Id]
+		if !ok {
+			report.conflict(LegacyTasksFile, oldId, "client %d is not in %s", t.Client.Id, LegacyClientsFile)
+			return nil
+		}
+		if t.Mode == "secret" || t.Mode == "p2p" {
+			if passwords[t.Password] {
+				report.conflict(LegacyTasksFile, oldId, "%s key %s already exists", t.Mode, t.Password)
+				return nil
+			}
+			passwords[t.Password] = true
+		} else if t.Port != 0 {
+			if ports[t.Port] {
+				report.conflict(LegacyTasksFile, oldId, "port %d is already used", t.Port)
+				return nil
+			}
+			ports[t.Port] = true
+		}
+		t.Id = nextTaskId
+		nextTaskId++
+		t.ClientId = clientId
+		t.Client = &Client{Id: clientId}
+		if t.Target == nil {
+			t.Target = new(Target)
+		}
+		if !dryRun {
+			if err := store.NewTask(t); err != nil {
+				return fmt.Errorf("%s: tunnel %d: %v", LegacyTasksFile, oldId, err)
+			}
+		}
+		report.Tasks++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	hosts := make(map[string][]string) // host+location -> scheme
+	err = LoadLegacyFile(filepath.Join(dir, LegacyHostsFile), func(value string) error {
+		h := new(Host)
+		if err := json.Unmarshal([]byte(value), h); err != nil {
+			return fmt.Errorf("%s: %v", LegacyHostsFile, err)
+		}
+		oldId := h.Id
+		if h.Client == nil {
+			report.conflict(LegacyHostsFile, oldId, "the host has no client")
+			return nil
+		}
+		clientId, ok := clientIds[h.Client.Id]
+		if !ok {
+			report.conflict(LegacyHostsFile, oldId, "client %d is not in %s", h.Client.Id, LegacyClientsFile)
+			return nil
+		}
+		if h.Location == "" {
+			h.Location = "/"
+		}
+		key := h.Host + h.Location
+		h.Id = 0
+		if schemeConflict(hosts[key], h.Scheme) || store.IsHostExist(h) {
+			report.conflict(LegacyHostsFile, oldId, "host %s%s already exists", h.Host, h.Location)
+			return nil
+		}
+		hosts[key] = append(hosts[key], h.Scheme)
+		h.Id = nextTaskId
+		nextTaskId++
+		h.Client = &Client{Id: clientId}
+		if h.Target == nil {
+			h.Target = new(Target)
+		}
+		if !dryRun {
+			if err := store.NewHost(h); err != nil {
+				return fmt.Errorf("%s: host %d: %v", LegacyHostsFile, oldId, err)
+			}
+		}
+		report.Hosts++
+		return nil
+	})
+	return report, err
+}
+
+// schemeConflict 判断同一 host 与 location 下的 scheme 是否重叠，all 与任意 scheme 重叠
+func schemeConflict(schemes []string, scheme string) bool {
+	for _, v := range schemes {
+		if v == scheme || v == "all" || scheme == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary 返回导入结果的简要说明
+func (r *ImportReport) Summary() string {
+	action := "imported"
+	if r.DryRun {
+		action = "would import"
+	}
+	return action + " " + strconv.Itoa(r.Clients) + " clients, " + strconv.Itoa(r.Tasks) + " tunnels, " +
+		strconv.Itoa(r.Hosts) + " hosts, " + strconv.Itoa(len(r.Conflicts)) + " conflicts"
+}
@@ -0,0 +1,86 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeLegacyFile(t *testing.T, dir, name string, records ...string) {
+	data := strings.Join(records, "\n"+"*#*")
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportLegacyJson(t *testing.T) {
+	db := newTestSqliteDb(t)
+	exist := NewClient("exist", false, false)
+	exist.Id = 1
+	if err := db.NewClient(exist); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.NewTask(&Tunnel{Id: 1, ClientId: 1, Mode: "tcp", Port: 8001, Status: true, Target: &Target{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeLegacyFile(t, dir, LegacyClientsFile,
+		`{"Id":1,"VerifyKey":"new","Status":true,"Cnf":{},"Flow":{}}`,
+		`{"Id":2,"VerifyKey":"exist","Status":true}`,
+		`{"Id":3,"VerifyKey":"new","Status":true}`,
+	)
+	writeLegacyFile(t, dir, LegacyTasksFile,
+		`{"Id":1,"Port":8001,"Mode":"tcp","Status":true,"Client":{"Id":1}}`,
+		`{"Id":2,"Port":8002,"Mode":"tcp","Status":true,"Client":{"Id":2},"Target":{"TargetStr":"127.0.0.1:80"}}`,
+		`{"Id":3,"Port":8002,"Mode":"udp","Status":true,"Client":{"Id":1}}`,
+		`{"Id":4,"Mode":"secret","Password":"p","Status":true,"Client":{"Id":3}}`,
+		`{"Id":5,"Port":8005,"Mode":"tcp","Status":true,"Client":{"Id":9}}`,
+	)
+	writeLegacyFile(t, dir, LegacyHostsFile,
+		`{"Id":1,"Host":"a.com","Scheme":"all","Client":{"Id":1}}`,
+		`{"Id":2,"Host":"a.com","Location":"/","Scheme":"http","Client":{"Id":2}}`,
+		`{"Id":3,"Host":"a.com","Location":"/api","Scheme":"https","Client":{"Id":2}}`,
+	)
+
+	report, err := ImportLegacyJson(db, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Clients != 1 || report.Tasks != 2 || report.Hosts != 2 || len(report.Conflicts) != 6 {
+		t.Fatalf("unexpected dry run report %s: %v", report.Summary(), report.Conflicts)
+	}
+	if _, cnt := db.GetClientList(0, 10, "", "", "", 0); cnt != 1 {
+		t.Fatalf("dry run wrote %d clients", cnt)
+	}
+	if tasks, _ := db.GetAllTasks(); len(tasks) != 1 {
+		t.Fatalf("dry run wrote %d tasks", len(tasks))
+	}
+
+	report, err = ImportLegacyJson(db, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Clients != 1 || report.Tasks != 2 || report.Hosts != 2 || len(report.Conflicts) != 6 {
+		t.Fatalf("unexpected import report %s: %v", report.Summary(), report.Conflicts)
+	}
+	newId, err := db.GetClientIdByVkey("new")
+	if err != nil || newId == 1 {
+		t.Fatalf("client new got id %d, err %v", newId, err)
+	}
+	if _, cnt, err := db.GetTunnelList(0, 10, "secret", newId, "", "", ""); err != nil || cnt != 1 {
+		t.Fatalf("client %d has %d tunnels, err %v", newId, cnt, err)
+	}
+	if _, cnt, _ := db.GetTunnelList(0, 10, "tcp", 1, "", "", ""); cnt != 2 {
+		t.Fatalf("existing client has %d tcp tunnels", cnt)
+	}
+	if _, cnt, _ := db.GetHost(0, 10, 1, "a.com"); cnt != 1 {
+		t.Fatalf("existing client has %d hosts", cnt)
+	}
+
+	report, err = ImportLegacyJson(db, dir, true)
+	if err != nil || report.Clients+report.Tasks+report.Hosts != 0 {
+		t.Fatalf("second import would write %s, err %v", report.Summary(), err)
+	}
+}
@@ -0,0 +1,154 @@
+package file
+
+import (
+	"database/sql"
+	"time"
+)
+
+// 登录失败计数的范围
+const (
+	LockScopeIp     = "ip"     // web 登录、API 密钥与 auth_key 的来源地址
+	LockScopeUser   = "user"   // web 登录的用户名
+	LockScopeBridge = "bridge" // 客户端连接的来源地址，校验 vkey
+)
+
+// LockPolicy 锁定策略，窗口期内失败次数达到上限后锁定，每次锁定的时长翻倍
+type LockPolicy struct {
+	MaxFailures int           // 窗口期内允许的失败次数
+	Window      time.Duration // 失败计数的窗口期
+	LockTime    time.Duration // 第一次锁定的时长
+	MaxLockTime time.Duration // 锁定时长的上限
+	ResetAfter  time.Duration // 超过该时间没有失败时锁定次数清零
+}
+
+// LockDuration 第 level+1 次锁定的时长
+func (p LockPolicy) LockDuration(level int) time.Duration {
+	d := p.LockTime
+	for i := 0; i < level && d < p.MaxLockTime; i++ {
+		d *= 2
+	}
+	if d > p.MaxLockTime {
+		d = p.MaxLockTime
+	}
+	return d
+}
+
+// LoginLock 一个地址或用户名的失败计数与锁定状态
+type LoginLock struct {
+	Scope       string `json:"scope"`
+	Subject     string `json:"subject"`
+	Failures    int    `json:"failures"`     // 当前窗口期内的失败次数
+	Level       int    `json:"level"`        // 已经锁定的次数
+	WindowStart int64  `json:"window_start"` // 当前窗口期开始时间
+	LockedUntil int64  `json:"locked_until"` // 锁定到期时间
+	UpdatedAt   int64  `json:"updated_at"`   // 最近一次失败时间
+}
+
+// LoginLockStore 登录失败计数相关的存储操作
+type LoginLockStore interface {
+	GetLoginLockedUntil(scope, subject string, now time.Time) (int64, error)
+	RecordLoginFailure(scope, subject string, p LockPolicy, now time.Time) (int64, error)
+	ResetLoginFailures(scope, subject string) error
+	ListLoginLocks(now time.Time) ([]*LoginLock, error)
+	DeleteLoginLock(scope, subject string) (bool, error)
+	DeleteStaleLoginLocks(before time.Time) (int64, error)
+}
+
+// GetLoginLockedUntil 返回锁定到期时间，未锁定时返回 0
+func (s *DbUtils) GetLoginLockedUntil(scope, subject string, now time.Time) (int64, error) {
+	var until int64
+	err := s.SqlDB.QueryRow("SELECT locked_until FROM login_locks WHERE scope = ? AND subject = ? AND locked_until > ?",
+		scope, subject, now.Unix()).Scan(&until)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return until, err
+}
+
+// RecordLoginFailure 记录一次失败，达到上限时锁定并返回锁定到期时间，否则返回 0
+// 计数使用单条 UPDATE 累加，多个服务端实例同时记录时不会丢失
+func (s *DbUtils) RecordLoginFailure(scope, subject string, p LockPolicy, now time.Time) (int64, error) {
+	ts := now.Unix()
+	if _, err := s.SqlDB.Exec(s.insertIgnoreSql()+"login_locks (scope, subject, window_start, updated_at) VALUES (?, ?, ?, ?)",
+		scope, subject, ts, ts); err != nil {
+		return 0, err
+	}
+	// mysql 按顺序使用已赋值的列，updated_at 与 window_start 需要放在引用它们的列之后
+	if _, err := s.SqlDB.Exec(`UPDATE login_locks SET
+		failures = CASE WHEN window_start < ? THEN 1 ELSE failures + 1 END,
+		level = CASE WHEN updated_at < ? THEN 0 ELSE level END,
+		window_start = CASE WHEN window_start < ? THEN ? ELSE window_start END,
+		updated_at = ?
+		WHERE scope = ? AND subject = ?`,
+		ts-int64(p.Window/time.Second), ts-int64(p.ResetAfter/time.Second), ts-int64(p.Window/time.Second), ts, ts,
+		scope, subject); err != nil {
+		return 0, err
+	}
+	var failures, level int
+	var until int64
+	if err := s.SqlDB.QueryRow("SELECT failures, level, locked_until FROM login_locks WHERE scope = ? AND subject = ?",
+		scope, subject).Scan(&failures, &level, &until); err != nil {
+		return 0, err
+	}
+	if failures < p.MaxFailures {
+		if until > ts {
+			return until, nil
+		}
+		return 0, nil
+	}
+	until = now.Add(p.LockDuration(level)).Unix()
+	// 只有一个实例能完成锁定，其他实例读取锁定结果
+	res, err := s.SqlDB.Exec("UPDATE login_locks SET failures = 0, level = level + 1, window_start = ?, locked_until = ? WHERE scope = ? AND subject = ? AND failures >= ?",
+		ts, until, scope, subject, p.MaxFailures)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return s.GetLoginLockedUntil(scope, subject, now)
+	}
+	return until, nil
+}
+
+// ResetLoginFailures 登录成功后清零失败次数，保留锁定次数，之后再次被锁定时锁定时长继续翻倍
+func (s *DbUtils) ResetLoginFailures(scope, subject string) error {
+	_, err := s.SqlDB.Exec("UPDATE login_locks SET failures = 0 WHERE scope = ? AND subject = ? AND failures > 0", scope, subject)
+	return err
+}
+
+// ListLoginLocks 返回仍在锁定中的地址与用户名
+func (s *DbUtils) ListLoginLocks(now time.Time) ([]*LoginLock, error) {
+	rows, err := s.SqlDB.Query("SELECT scope, subject, failures, level, window_start, locked_until, updated_at FROM login_locks WHERE locked_until > ? ORDER BY locked_until DESC",
+		now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*LoginLock
+	for rows.Next() {
+		l := &LoginLock{}
+		if err := rows.Scan(&l.Scope, &l.Subject, &l.Failures, &l.Level, &l.WindowStart, &l.LockedUntil, &l.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	return list, rows.Err()
+}
+
+// DeleteLoginLock 解除锁定并清除失败记录，返回 false 表示没有记录
+func (s *DbUtils) DeleteLoginLock(scope, subject string) (bool, error) {
+	res, err := s.SqlDB.Exec("DELETE FROM login_locks WHERE scope = ? AND subject = ?", scope, subject)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteStaleLoginLocks 删除 before 之后没有失败且不在锁定中的记录
+func (s *DbUtils) DeleteStaleLoginLocks(before time.Time) (int64, error) {
+	res, err := s.SqlDB.Exec("DELETE FROM login_locks WHERE updated_at < ? AND locked_until < ?", before.Unix(), time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,81 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockDuration(t *testing.T) {
+	p := LockPolicy{LockTime: time.Minute, MaxLockTime: 10 * time.Minute}
+	for level, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute} {
+		if got := p.LockDuration(level); got != want {
+			t.Fatalf("LockDuration(%d) = %v, want %v", level, got, want)
+		}
+	}
+}
+
+func TestLoginLockStore(t *testing.T) {
+	db := newTestSqliteDb(t)
+	p := LockPolicy{MaxFailures: 3, Window: time.Minute, LockTime: time.Minute, MaxLockTime: time.Hour, ResetAfter: 24 * time.Hour}
+	now := time.Now()
+	fail := func(at time.Time) int64 {
+		until, err := db.RecordLoginFailure(LockScopeIp, "1.2.3.4", p, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return until
+	}
+
+	// 窗口期外的失败重新计数
+	fail(now)
+	fail(now.Add(time.Second))
+	if until := fail(now.Add(2 * time.Minute)); until != 0 {
+		t.Fatal("failures outside the window locked")
+	}
+	fail(now.Add(2*time.Minute + time.Second))
+	until := fail(now.Add(2*time.Minute + 2*time.Second))
+	if want := now.Add(3*time.Minute + 2*time.Second).Unix(); until != want {
+		t.Fatalf("first lock until %d, want %d", until, want)
+	}
+	if got, _ := db.GetLoginLockedUntil(LockScopeIp, "1.2.3.4", now.Add(2*time.Minute+3*time.Second)); got != until {
+		t.Fatalf("GetLoginLockedUntil %d", got)
+	}
+	if got, _ := db.GetLoginLockedUntil(LockScopeUser, "1.2.3.4", now); got != 0 {
+		t.Fatal("scopes are not separated")
+	}
+	if list, _ := db.ListLoginLocks(now.Add(2*time.Minute + 3*time.Second)); len(list) != 1 || list[0].Level != 1 {
+		t.Fatalf("ListLoginLocks %+v", list)
+	}
+
+	// 第二次锁定时长翻倍，登录成功不清零锁定次数
+	later := now.Add(10 * time.Minute)
+	fail(later)
+	if err := db.ResetLoginFailures(LockScopeIp, "1.2.3.4"); err != nil {
+		t.Fatal(err)
+	}
+	fail(later)
+	fail(later)
+	if until := fail(later); until != later.Add(2*time.Minute).Unix() {
+		t.Fatalf("second lock until %d", until)
+	}
+
+	// 长时间没有失败后锁定次数清零
+	much := later.Add(48 * time.Hour)
+	fail(much)
+	fail(much)
+	if until := fail(much); until != much.Add(time.Minute).Unix() {
+		t.Fatalf("lock after reset until %d", until)
+	}
+
+	if ok, err := db.DeleteLoginLock(LockScopeIp, "1.2.3.4"); err != nil || !ok {
+		t.Fatalf("DeleteLoginLock %v %v", ok, err)
+	}
+	if got, _ := db.GetLoginLockedUntil(LockScopeIp, "1.2.3.4", much); got != 0 {
+		t.Fatal("unbanned subject still locked")
+	}
+
+	fail(now)
+	if n, err := db.DeleteStaleLoginLocks(now.Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("DeleteStaleLoginLocks %d %v", n, err)
+	}
+}
@@ -0,0 +1,252 @@
+package file
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+// 数据库迁移脚本按驱动存放在 migrations/<driver>/ 下，
+// 文件名格式为 <版本号>_<名称>.up.sql 与 <版本号>_<名称>.down.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+const migrationTable = "schema_migrations"
+
+// Migration 一个版本的数据库迁移
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus 迁移版本的执行状态
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt string
+}
+
+// MigrationStore 数据库结构的创建与升级
+type MigrationStore interface {
+	MigrateUp() (int, error)
+	MigrateDown(steps int) (int, error)
+	MigrationStatus() ([]*MigrationStatus, error)
+}
+
+// LoadMigrations 读取指定驱动的全部迁移脚本，按版本号升序返回
+func LoadMigrations(driver string) ([]*Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for driver %s: %v", driver, err)
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		content, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+	list := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// splitStatements 按行尾分号拆分迁移脚本，忽略 -- 注释
+func splitStatements(script string) []string {
+	var stmts []string
+	var cur strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur.WriteString(line)
+		cur.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(cur.String()), ";"))
+			cur.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(cur.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+	return stmts
+}
+
+func (s *DbUtils) ensureMigrationTable() error {
+	query := "CREATE TABLE IF NOT EXISTS " + migrationTable + " (version INT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL DEFAULT '', applied_at VARCHAR(32) NOT NULL DEFAULT '')"
+	_, err := s.SqlDB.Exec(query)
+	return err
+}
+
+// appliedVersions 返回已执行的迁移版本及执行时间
+func (s *DbUtils) appliedVersions() (map[int]string, error) {
+	if err := s.ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+	rows, err := s.SqlDB.Query("SELECT version, applied_at FROM " + migrationTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var at string
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runMigration 在事务中执行一个方向的迁移脚本并记录版本
+// 注意：MySQL 的 DDL 会隐式提交，失败时需要人工检查数据库状态
+func (s *DbUtils) runMigration(m *Migration, up bool) error {
+	script := m.Down
+	if up {
+		script = m.Up
+	}
+	tx, err := s.SqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d_%s failed: %v", m.Version, m.Name, err)
+		}
+	}
+	if up {
+		_, err = tx.Exec("INSERT INTO "+migrationTable+" (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().Format("2006-01-02 15:04:05"))
+	} else {
+		_, err = tx.Exec("DELETE FROM "+migrationTable+" WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp 依次执行所有未执行的迁移，返回本次执行的数量
+func (s *DbUtils) MigrateUp() (int, error) {
+	migrations, err := LoadMigrations(s.driver)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := s.appliedVersions()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := s.runMigration(m, true); err != nil {
+			return n, err
+		}
+		logs.Info("database migration %d_%s applied", m.Version, m.Name)
+		n++
+	}
+	return n, nil
+}
+
+// MigrateDown 按版本号倒序回滚 steps 个已执行的迁移，返回本次回滚的数量
+func (s *DbUtils) MigrateDown(steps int) (int, error) {
+	if steps <= 0 {
+		return 0, errors.New("the number of migrations to roll back must be positive")
+	}
+	migrations, err := LoadMigrations(s.driver)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := s.appliedVersions()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for i := len(migrations) - 1; i >= 0 && n < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return n, fmt.Errorf("migration %d_%s can not be rolled back", m.Version, m.Name)
+		}
+		if err := s.runMigration(m, false); err != nil {
+			return n, err
+		}
+		logs.Info("database migration %d_%s rolled back", m.Version, m.Name)
+		n++
+	}
+	return n, nil
+}
+
+// MigrationStatus 返回全部迁移及其执行状态
+func (s *DbUtils) MigrationStatus() ([]*MigrationStatus, error) {
+	migrations, err := LoadMigrations(s.driver)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		list = append(list, &MigrationStatus{
+			Version:   m.Version,
+			Name:      m.Name,
+			Applied:   ok,
+			AppliedAt: at,
+		})
+	}
+	return list, nil
+}
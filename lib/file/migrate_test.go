@@ -0,0 +1,151 @@
+package file
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestSqliteDb(t *testing.T) *SqliteDb {
+	db, err := NewSqliteDb(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.SqlDB.Close() })
+	return db
+}
+
+func TestLoadMigrations(t *testing.T) {
+	for _, driver := range []string{DriverMysql, DriverSqlite} {
+		list, err := LoadMigrations(driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 0 || list[0].Version != 1 {
+			t.Fatalf("%s: unexpected migrations %v", driver, list)
+		}
+		for i := 1; i < len(list); i++ {
+			if list[i].Version <= list[i-1].Version {
+				t.Fatalf("%s: migrations are not ordered", driver)
+			}
+		}
+	}
+	mysql, _ := LoadMigrations(DriverMysql)
+	sqlite, _ := LoadMigrations(DriverSqlite)
+	if len(mysql) != len(sqlite) {
+		t.Fatalf("mysql has %d migrations, sqlite has %d", len(mysql), len(sqlite))
+	}
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	db := newTestSqliteDb(t)
+	if n, err := db.MigrateUp(); err != nil || n != 0 {
+		t.Fatalf("second MigrateUp applied %d, err %v", n, err)
+	}
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range status {
+		if !v.Applied {
+			t.Fatalf("migration %d is not applied", v.Version)
+		}
+	}
+	if n, err := db.MigrateDown(len(status)); err != nil || n != len(status) {
+		t.Fatalf("MigrateDown rolled back %d, err %v", n, err)
+	}
+	if _, err := db.SqlDB.Exec("SELECT 1 FROM clients"); err == nil {
+		t.Fatal("clients table still exists after rolling back all migrations")
+	}
+	if n, err := db.MigrateUp(); err != nil || n != len(status) {
+		t.Fatalf("MigrateUp applied %d, err %v", n, err)
+	}
+}
+
+// 升级前手工建立的 orders 表没有索引，迁移不能依赖 0001 中的索引已经存在
+func TestMigrateLegacyOrders(t *testing.T) {
+	db, err := NewSqliteDb(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.SqlDB.Close() })
+	if _, err := db.SqlDB.Exec(`CREATE TABLE orders (
+		order_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		app_id TEXT NOT NULL DEFAULT '',
+		account_id TEXT NOT NULL DEFAULT '',
+		order_amount REAL NOT NULL DEFAULT 0,
+		flow REAL NOT NULL DEFAULT 0,
+		months INTEGER NOT NULL DEFAULT 0,
+		order_status TEXT NOT NULL DEFAULT '',
+		payment_type TEXT NOT NULL DEFAULT '',
+		external_transaction_id TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL DEFAULT 0
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SqlDB.Exec("INSERT INTO orders (account_id, flow, order_status, payment_type, external_transaction_id) VALUES ('1', 2, 'paid', 'traffic', 'tx-1')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SqlDB.Exec("INSERT INTO orders (external_transaction_id) VALUES ('tx-1')"); err == nil {
+		t.Fatal("external_transaction_id is not unique after migration")
+	}
+	var credits int
+	if err := db.SqlDB.QueryRow("SELECT COUNT(*) FROM order_credits").Scan(&credits); err != nil || credits != 1 {
+		t.Fatalf("order_credits %d %v", credits, err)
+	}
+}
+
+// mysql 不支持 DROP INDEX IF EXISTS，删除索引前需要查询 information_schema
+func TestMysqlDropIndexGuarded(t *testing.T) {
+	list, err := LoadMigrations(DriverMysql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range list {
+		for _, stmt := range splitStatements(m.Up) {
+			if strings.HasPrefix(strings.ToUpper(stmt), "ALTER TABLE") && strings.Contains(strings.ToUpper(stmt), "DROP INDEX") {
+				t.Errorf("migration %d_%s drops an index unconditionally: %s", m.Version, m.Name, stmt)
+			}
+		}
+	}
+}
+
+func TestSqliteStore(t *testing.T) {
+	db := newTestSqliteDb(t)
+	c := NewClient("vkey", false, false)
+	c.Id = db.GetNewClientId()
+	if err := db.NewClient(c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetClient(c.Id)
+	if err != nil || got.VerifyKey != "vkey" {
+		t.Fatalf("GetClient returned %v, %v", got, err)
+	}
+	if db.VerifyVkey("vkey", 0) {
+		t.Fatal("duplicate vkey passed verification")
+	}
+	a := NewAccount()
+	a.WebUserName = "user"
+	if err := db.NewAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	account, err := db.GetByUsername("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddMonths(account.Id, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTraffic(account.Id, 1024); err != nil {
+		t.Fatal(err)
+	}
+	info, err := db.GetAccountInfo(account.Id)
+	if err != nil || info.ExpireTime == "" || info.Flow.FlowLimit != 1024 {
+		t.Fatalf("GetAccountInfo returned %+v, %v", info, err)
+	}
+}
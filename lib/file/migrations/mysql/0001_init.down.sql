@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS globals;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS clients;
+DROP TABLE IF EXISTS accounts;
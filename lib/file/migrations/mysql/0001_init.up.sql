@@ -0,0 +1,93 @@
+-- 初始表结构，使用 IF NOT EXISTS 以兼容已手工建表的旧部署
+CREATE TABLE IF NOT EXISTS accounts (
+    id INT NOT NULL AUTO_INCREMENT,
+    web_user_name VARCHAR(128) NOT NULL DEFAULT '',
+    web_password VARCHAR(255) NOT NULL DEFAULT '',
+    nick_name VARCHAR(128) NOT NULL DEFAULT '',
+    head_img_url VARCHAR(512) NOT NULL DEFAULT '',
+    rate_limit INT NOT NULL DEFAULT 0,
+    remark VARCHAR(255) NOT NULL DEFAULT '',
+    status TINYINT(1) NOT NULL DEFAULT 1,
+    flow DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    expire_time DATETIME NULL,
+    create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY idx_accounts_web_user_name (web_user_name)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS clients (
+    id INT NOT NULL,
+    account_id INT NOT NULL DEFAULT 0,
+    verify_key VARCHAR(128) NOT NULL DEFAULT '',
+    addr VARCHAR(64) NOT NULL DEFAULT '',
+    remark VARCHAR(255) NOT NULL DEFAULT '',
+    status TINYINT(1) NOT NULL DEFAULT 1,
+    rate_limit INT NOT NULL DEFAULT 0,
+    inlet_flow BIGINT NOT NULL DEFAULT 0,
+    no_display TINYINT(1) NOT NULL DEFAULT 0,
+    web_user_name VARCHAR(128) NOT NULL DEFAULT '',
+    web_password VARCHAR(255) NOT NULL DEFAULT '',
+    create_time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY idx_clients_verify_key (verify_key),
+    KEY idx_clients_account_id (account_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+-- tasks 同时保存隧道与域名解析(host)记录
+CREATE TABLE IF NOT EXISTS tasks (
+    id INT NOT NULL,
+    account_id INT NOT NULL DEFAULT 0,
+    client_id INT NOT NULL DEFAULT 0,
+    port INT NOT NULL DEFAULT 0,
+    server_ip VARCHAR(64) NOT NULL DEFAULT '',
+    mode VARCHAR(32) NOT NULL DEFAULT '',
+    status TINYINT(1) NOT NULL DEFAULT 1,
+    run_status TINYINT(1) NOT NULL DEFAULT 0,
+    ports VARCHAR(255) NOT NULL DEFAULT '',
+    password VARCHAR(255) NOT NULL DEFAULT '',
+    remark VARCHAR(255) NOT NULL DEFAULT '',
+    target_addr VARCHAR(255) NOT NULL DEFAULT '',
+    no_store TINYINT(1) NOT NULL DEFAULT 0,
+    is_http TINYINT(1) NOT NULL DEFAULT 0,
+    local_path VARCHAR(255) NOT NULL DEFAULT '',
+    strip_pre VARCHAR(255) NOT NULL DEFAULT '',
+    header_change VARCHAR(1024) NOT NULL DEFAULT '',
+    host_change VARCHAR(255) NOT NULL DEFAULT '',
+    location VARCHAR(255) NOT NULL DEFAULT '',
+    host VARCHAR(255) NOT NULL DEFAULT '',
+    scheme VARCHAR(16) NOT NULL DEFAULT '',
+    cert_file_path TEXT NULL,
+    key_file_path TEXT NULL,
+    is_close TINYINT(1) NOT NULL DEFAULT 0,
+    auto_https TINYINT(1) NOT NULL DEFAULT 0,
+    target TEXT NULL,
+    external_service_domain VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (id),
+    KEY idx_tasks_client_id (client_id),
+    KEY idx_tasks_account_id (account_id),
+    KEY idx_tasks_host (host)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS orders (
+    order_id BIGINT NOT NULL AUTO_INCREMENT,
+    app_id VARCHAR(64) NOT NULL DEFAULT '',
+    account_id VARCHAR(32) NOT NULL DEFAULT '',
+    order_amount DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    flow DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    months INT NOT NULL DEFAULT 0,
+    order_status VARCHAR(32) NOT NULL DEFAULT '',
+    payment_type VARCHAR(32) NOT NULL DEFAULT '',
+    external_transaction_id VARCHAR(128) NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (order_id),
+    KEY idx_orders_external_transaction_id (external_transaction_id),
+    KEY idx_orders_account_id (account_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+CREATE TABLE IF NOT EXISTS globals (
+    id INT NOT NULL AUTO_INCREMENT,
+    config TEXT NULL,
+    PRIMARY KEY (id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+INSERT INTO globals (id, config) SELECT 1, '' FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM globals);
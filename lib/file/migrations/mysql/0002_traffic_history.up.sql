@@ -0,0 +1,12 @@
+-- 流量历史，raw 为按 flow_store_interval 采样的原始数据，hour、day 为按小时、按天汇总的数据
+CREATE TABLE IF NOT EXISTS traffic_samples (
+    kind VARCHAR(16) NOT NULL,
+    object_id INT NOT NULL,
+    resolution VARCHAR(8) NOT NULL,
+    bucket BIGINT NOT NULL,
+    in_bytes BIGINT NOT NULL DEFAULT 0,
+    out_bytes BIGINT NOT NULL DEFAULT 0,
+    conns BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (kind, object_id, resolution, bucket),
+    KEY idx_traffic_samples_bucket (resolution, bucket)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
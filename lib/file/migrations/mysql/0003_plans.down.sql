@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP COLUMN plan_id;
+ALTER TABLE accounts DROP COLUMN plan_id, DROP COLUMN max_clients, DROP COLUMN max_tunnels;
+DROP TABLE IF EXISTS plans;
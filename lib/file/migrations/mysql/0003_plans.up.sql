@@ -0,0 +1,22 @@
+-- 套餐目录，flow 为套餐包含的流量(GB)，months 为套餐周期(月)，0 表示只购买流量不延长到期时间
+CREATE TABLE IF NOT EXISTS plans (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(64) NOT NULL,
+    flow DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    rate_limit INT NOT NULL DEFAULT 0,
+    max_clients INT NOT NULL DEFAULT 0,
+    max_tunnels INT NOT NULL DEFAULT 0,
+    months INT NOT NULL DEFAULT 0,
+    price DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    status TINYINT(1) NOT NULL DEFAULT 1,
+    remark VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_plans_name (name)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+ALTER TABLE accounts
+    ADD COLUMN plan_id INT NOT NULL DEFAULT 0,
+    ADD COLUMN max_clients INT NOT NULL DEFAULT 0,
+    ADD COLUMN max_tunnels INT NOT NULL DEFAULT 0;
+
+ALTER TABLE orders ADD COLUMN plan_id INT NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS order_credits;
+
+ALTER TABLE orders
+    DROP INDEX uk_orders_external_transaction_id,
+    ADD KEY idx_orders_external_transaction_id (external_transaction_id);
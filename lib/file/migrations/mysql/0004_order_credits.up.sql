@@ -0,0 +1,29 @@
+-- 外部交易号作为订单结算的幂等键
+-- 升级前手工建立的 orders 表可能没有普通索引，存在时才删除
+SET @drop_index = (SELECT IF(COUNT(*) > 0, 'ALTER TABLE orders DROP INDEX idx_orders_external_transaction_id', 'DO 0')
+    FROM information_schema.statistics
+    WHERE table_schema = DATABASE() AND table_name = 'orders' AND index_name = 'idx_orders_external_transaction_id');
+PREPARE drop_index FROM @drop_index;
+EXECUTE drop_index;
+DEALLOCATE PREPARE drop_index;
+ALTER TABLE orders ADD UNIQUE KEY uk_orders_external_transaction_id (external_transaction_id);
+
+-- 订单入账记录，与订单状态在同一事务中写入，flow 单位为 KB
+CREATE TABLE IF NOT EXISTS order_credits (
+    order_id BIGINT NOT NULL,
+    account_id INT NOT NULL DEFAULT 0,
+    flow DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    months INT NOT NULL DEFAULT 0,
+    plan_id INT NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (order_id),
+    KEY idx_order_credits_account_id (account_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+-- 已支付的历史订单视为已入账
+INSERT INTO order_credits (order_id, account_id, flow, months, plan_id, created_at)
+SELECT order_id, CAST(account_id AS SIGNED),
+    CASE WHEN payment_type IN ('traffic', 'plan') THEN flow * 1024 * 1024 ELSE 0 END,
+    CASE WHEN payment_type = 'traffic' THEN 0 ELSE months END,
+    plan_id, UNIX_TIMESTAMP()
+FROM orders WHERE order_status = 'paid';
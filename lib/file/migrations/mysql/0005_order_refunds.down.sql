@@ -0,0 +1,8 @@
+ALTER TABLE orders DROP INDEX idx_orders_created_at;
+
+ALTER TABLE order_credits
+    DROP COLUMN refunded_at,
+    DROP COLUMN prev_rate_limit,
+    DROP COLUMN prev_max_clients,
+    DROP COLUMN prev_max_tunnels,
+    DROP COLUMN prev_plan_id;
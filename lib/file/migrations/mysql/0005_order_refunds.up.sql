@@ -0,0 +1,9 @@
+-- 退款时撤销入账，prev_* 记录套餐订单入账前账号的限制，用于退款时恢复
+ALTER TABLE order_credits
+    ADD COLUMN refunded_at BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN prev_rate_limit INT NOT NULL DEFAULT 0,
+    ADD COLUMN prev_max_clients INT NOT NULL DEFAULT 0,
+    ADD COLUMN prev_max_tunnels INT NOT NULL DEFAULT 0,
+    ADD COLUMN prev_plan_id INT NOT NULL DEFAULT 0;
+
+ALTER TABLE orders ADD KEY idx_orders_created_at (created_at);
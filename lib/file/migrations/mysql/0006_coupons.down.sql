@@ -0,0 +1,7 @@
+ALTER TABLE orders
+    DROP INDEX idx_orders_coupon_code,
+    DROP COLUMN coupon_code,
+    DROP COLUMN discount,
+    DROP COLUMN bonus_flow;
+
+DROP TABLE IF EXISTS coupons;
@@ -0,0 +1,22 @@
+-- 优惠码，kind 为 percent（value 为折扣百分比）、fixed（value 为减免金额）或 bonus_flow（value 为赠送流量 GB）
+-- max_uses 为 0 表示不限次数，start_at、end_at 为 unix 时间戳，0 表示不限制
+CREATE TABLE IF NOT EXISTS coupons (
+    id INT NOT NULL AUTO_INCREMENT,
+    code VARCHAR(64) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    value DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    max_uses INT NOT NULL DEFAULT 0,
+    used INT NOT NULL DEFAULT 0,
+    start_at BIGINT NOT NULL DEFAULT 0,
+    end_at BIGINT NOT NULL DEFAULT 0,
+    status TINYINT(1) NOT NULL DEFAULT 1,
+    remark VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_coupons_code (code)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+ALTER TABLE orders
+    ADD COLUMN coupon_code VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN discount DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN bonus_flow DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    ADD KEY idx_orders_coupon_code (coupon_code);
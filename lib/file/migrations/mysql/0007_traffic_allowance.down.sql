@@ -0,0 +1,5 @@
+ALTER TABLE accounts
+    DROP COLUMN allowance,
+    DROP COLUMN allowance_left,
+    DROP COLUMN reset_day,
+    DROP COLUMN last_reset;
@@ -0,0 +1,7 @@
+-- 每月流量额度，allowance 为每个周期的额度(KB)，allowance_left 为本周期剩余额度(KB)，flow 为购买的流量余额(KB)
+-- reset_day 为每月重置额度的日期，0 表示不重置，last_reset 为上次重置的 unix 时间戳
+ALTER TABLE accounts
+    ADD COLUMN allowance DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    ADD COLUMN allowance_left DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    ADD COLUMN reset_day INT NOT NULL DEFAULT 0,
+    ADD COLUMN last_reset BIGINT NOT NULL DEFAULT 0;
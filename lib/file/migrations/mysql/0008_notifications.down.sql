@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS notify_sent;
+DROP TABLE IF EXISTS notify_settings;
@@ -0,0 +1,18 @@
+-- 账号的通知设置，thresholds 为逗号分隔的流量额度使用百分比
+CREATE TABLE IF NOT EXISTS notify_settings (
+    account_id INT NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    webhook_url VARCHAR(512) NOT NULL DEFAULT '',
+    thresholds VARCHAR(64) NOT NULL DEFAULT '80,95,100',
+    expiry TINYINT(1) NOT NULL DEFAULT 1,
+    enabled TINYINT(1) NOT NULL DEFAULT 1,
+    PRIMARY KEY (account_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+-- 已发送的通知，用于去重，条件解除后删除以便下个周期再次通知
+CREATE TABLE IF NOT EXISTS notify_sent (
+    account_id INT NOT NULL,
+    event_key VARCHAR(128) NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (account_id, event_key)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
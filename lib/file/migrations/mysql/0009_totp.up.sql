@@ -0,0 +1,17 @@
+-- 两步验证密钥，account_id 为 0 表示配置文件中的管理员，last_step 为最近一次使用的时间步，防止验证码重放
+CREATE TABLE IF NOT EXISTS totp (
+    account_id INT NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    enabled TINYINT(1) NOT NULL DEFAULT 0,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (account_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+-- 恢复码的 sha256，每个恢复码只能使用一次
+CREATE TABLE IF NOT EXISTS totp_recovery (
+    account_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (account_id, code_hash)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
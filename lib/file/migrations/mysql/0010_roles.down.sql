@@ -0,0 +1,3 @@
+ALTER TABLE accounts
+    DROP COLUMN role,
+    DROP COLUMN reseller_id;
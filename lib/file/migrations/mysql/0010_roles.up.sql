@@ -0,0 +1,4 @@
+-- 账号角色，admin、operator、readonly、reseller、owner，reseller_id 为管理该账号的代理商账号 id，0 表示没有
+ALTER TABLE accounts
+    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'owner',
+    ADD COLUMN reseller_id INT NOT NULL DEFAULT 0;
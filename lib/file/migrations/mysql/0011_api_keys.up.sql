@@ -0,0 +1,18 @@
+-- 账号的 API 密钥，只保存密钥的 sha256，prefix 为密钥开头的几位便于用户区分，
+-- scopes 为逗号分隔的授权范围，expire_at 为 0 表示永不过期，revoked_at 不为 0 表示已吊销
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INT NOT NULL AUTO_INCREMENT,
+    account_id INT NOT NULL,
+    name VARCHAR(64) NOT NULL DEFAULT '',
+    prefix VARCHAR(16) NOT NULL DEFAULT '',
+    key_hash CHAR(64) NOT NULL,
+    scopes VARCHAR(255) NOT NULL DEFAULT '',
+    expire_at BIGINT NOT NULL DEFAULT 0,
+    last_used_at BIGINT NOT NULL DEFAULT 0,
+    last_used_ip VARCHAR(64) NOT NULL DEFAULT '',
+    revoked_at BIGINT NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_api_keys_hash (key_hash),
+    KEY idx_api_keys_account (account_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,18 @@
+-- 登录会话，每次登录签发 token 时创建，access token 中的 sid 指向本表，
+-- refresh_hash 为当前 refresh token 的 sha256，每次刷新时轮换，revoked_at 不为 0 表示已退出登录
+CREATE TABLE IF NOT EXISTS login_sessions (
+    id CHAR(32) NOT NULL,
+    account_id INT NOT NULL DEFAULT 0,
+    username VARCHAR(255) NOT NULL DEFAULT '',
+    role VARCHAR(16) NOT NULL DEFAULT '',
+    mfa TINYINT(1) NOT NULL DEFAULT 0,
+    refresh_hash CHAR(64) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at BIGINT NOT NULL DEFAULT 0,
+    last_seen_at BIGINT NOT NULL DEFAULT 0,
+    expire_at BIGINT NOT NULL DEFAULT 0,
+    revoked_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    KEY idx_login_sessions_account (account_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
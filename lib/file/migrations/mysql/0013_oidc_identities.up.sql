@@ -0,0 +1,9 @@
+-- OpenID Connect 登录的身份与账号的关联，按 issuer 与 sub 识别用户，避免与同名的本地账号混淆
+CREATE TABLE IF NOT EXISTS oidc_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    account_id INT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (issuer, subject),
+    KEY idx_oidc_identities_account (account_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
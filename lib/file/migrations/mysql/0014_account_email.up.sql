@@ -0,0 +1,16 @@
+-- 账号邮箱，email_verified 为 0 的账号需要验证邮箱后才能添加客户端，已有账号与管理员创建的账号默认视为已验证
+ALTER TABLE accounts ADD COLUMN email VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN email_verified TINYINT(1) NOT NULL DEFAULT 1;
+
+-- 邮箱验证与找回密码的一次性链接，只保存 token 的 sha256，purpose 为 verify 或 reset
+CREATE TABLE IF NOT EXISTS account_tokens (
+    token_hash CHAR(64) NOT NULL,
+    account_id INT NOT NULL,
+    purpose VARCHAR(16) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    expire_at BIGINT NOT NULL DEFAULT 0,
+    used_at BIGINT NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (token_hash),
+    KEY idx_account_tokens_account (account_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,12 @@
+-- 登录失败计数与锁定，保存在数据库中，重启后仍然有效且多个服务端实例共享
+CREATE TABLE IF NOT EXISTS login_locks (
+    scope VARCHAR(16) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    level INT NOT NULL DEFAULT 0,
+    window_start BIGINT NOT NULL DEFAULT 0,
+    locked_until BIGINT NOT NULL DEFAULT 0,
+    updated_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (scope, subject),
+    KEY idx_login_locks_updated (updated_at)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
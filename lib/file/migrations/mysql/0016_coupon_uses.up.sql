@@ -0,0 +1,13 @@
+-- 优惠码的使用记录，每个账号同一优惠码只有一条，订单结算时写入，保证同一账号并发结算多个订单时只有一个使用优惠码
+CREATE TABLE IF NOT EXISTS coupon_uses (
+    code VARCHAR(64) NOT NULL,
+    account_id VARCHAR(32) NOT NULL,
+    order_id BIGINT NOT NULL,
+    created_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (code, account_id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
+
+INSERT IGNORE INTO coupon_uses (code, account_id, order_id, created_at)
+SELECT coupon_code, account_id, MIN(order_id), MIN(created_at) FROM orders
+WHERE coupon_code <> '' AND order_status IN ('paid', 'refunded')
+GROUP BY coupon_code, account_id;
@@ -0,0 +1,4 @@
+ALTER TABLE order_credits
+    DROP COLUMN allowance,
+    DROP COLUMN prev_allowance,
+    DROP COLUMN prev_reset_day;
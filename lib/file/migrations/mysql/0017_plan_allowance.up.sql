@@ -0,0 +1,6 @@
+-- 套餐的流量作为账号的每月额度入账，allowance 为入账的每月额度(KB)，0 表示未修改额度（包括升级前按流量余额入账的套餐订单）
+-- prev_* 记录入账前账号的额度设置，用于退款时恢复
+ALTER TABLE order_credits
+    ADD COLUMN allowance DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    ADD COLUMN prev_allowance DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    ADD COLUMN prev_reset_day INT NOT NULL DEFAULT 0;
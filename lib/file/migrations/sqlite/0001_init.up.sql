@@ -0,0 +1,88 @@
+-- 初始表结构，与 mysql/0001_init.up.sql 保持一致
+CREATE TABLE IF NOT EXISTS accounts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    web_user_name TEXT NOT NULL DEFAULT '',
+    web_password TEXT NOT NULL DEFAULT '',
+    nick_name TEXT NOT NULL DEFAULT '',
+    head_img_url TEXT NOT NULL DEFAULT '',
+    rate_limit INTEGER NOT NULL DEFAULT 0,
+    remark TEXT NOT NULL DEFAULT '',
+    status INTEGER NOT NULL DEFAULT 1,
+    flow REAL NOT NULL DEFAULT 0,
+    expire_time TEXT NULL,
+    create_time TEXT NOT NULL DEFAULT (datetime('now', 'localtime'))
+);
+CREATE INDEX IF NOT EXISTS idx_accounts_web_user_name ON accounts (web_user_name);
+
+CREATE TABLE IF NOT EXISTS clients (
+    id INTEGER PRIMARY KEY,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    verify_key TEXT NOT NULL DEFAULT '',
+    addr TEXT NOT NULL DEFAULT '',
+    remark TEXT NOT NULL DEFAULT '',
+    status INTEGER NOT NULL DEFAULT 1,
+    rate_limit INTEGER NOT NULL DEFAULT 0,
+    inlet_flow INTEGER NOT NULL DEFAULT 0,
+    no_display INTEGER NOT NULL DEFAULT 0,
+    web_user_name TEXT NOT NULL DEFAULT '',
+    web_password TEXT NOT NULL DEFAULT '',
+    create_time TEXT NOT NULL DEFAULT (datetime('now', 'localtime'))
+);
+CREATE INDEX IF NOT EXISTS idx_clients_verify_key ON clients (verify_key);
+CREATE INDEX IF NOT EXISTS idx_clients_account_id ON clients (account_id);
+
+-- tasks 同时保存隧道与域名解析(host)记录
+CREATE TABLE IF NOT EXISTS tasks (
+    id INTEGER PRIMARY KEY,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    client_id INTEGER NOT NULL DEFAULT 0,
+    port INTEGER NOT NULL DEFAULT 0,
+    server_ip TEXT NOT NULL DEFAULT '',
+    mode TEXT NOT NULL DEFAULT '',
+    status INTEGER NOT NULL DEFAULT 1,
+    run_status INTEGER NOT NULL DEFAULT 0,
+    ports TEXT NOT NULL DEFAULT '',
+    password TEXT NOT NULL DEFAULT '',
+    remark TEXT NOT NULL DEFAULT '',
+    target_addr TEXT NOT NULL DEFAULT '',
+    no_store INTEGER NOT NULL DEFAULT 0,
+    is_http INTEGER NOT NULL DEFAULT 0,
+    local_path TEXT NOT NULL DEFAULT '',
+    strip_pre TEXT NOT NULL DEFAULT '',
+    header_change TEXT NOT NULL DEFAULT '',
+    host_change TEXT NOT NULL DEFAULT '',
+    location TEXT NOT NULL DEFAULT '',
+    host TEXT NOT NULL DEFAULT '',
+    scheme TEXT NOT NULL DEFAULT '',
+    cert_file_path TEXT NOT NULL DEFAULT '',
+    key_file_path TEXT NOT NULL DEFAULT '',
+    is_close INTEGER NOT NULL DEFAULT 0,
+    auto_https INTEGER NOT NULL DEFAULT 0,
+    target TEXT NULL,
+    external_service_domain TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_tasks_client_id ON tasks (client_id);
+CREATE INDEX IF NOT EXISTS idx_tasks_account_id ON tasks (account_id);
+CREATE INDEX IF NOT EXISTS idx_tasks_host ON tasks (host);
+
+CREATE TABLE IF NOT EXISTS orders (
+    order_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    app_id TEXT NOT NULL DEFAULT '',
+    account_id TEXT NOT NULL DEFAULT '',
+    order_amount REAL NOT NULL DEFAULT 0,
+    flow REAL NOT NULL DEFAULT 0,
+    months INTEGER NOT NULL DEFAULT 0,
+    order_status TEXT NOT NULL DEFAULT '',
+    payment_type TEXT NOT NULL DEFAULT '',
+    external_transaction_id TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_orders_external_transaction_id ON orders (external_transaction_id);
+CREATE INDEX IF NOT EXISTS idx_orders_account_id ON orders (account_id);
+
+CREATE TABLE IF NOT EXISTS globals (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    config TEXT NULL
+);
+
+INSERT INTO globals (id, config) SELECT 1, '' WHERE NOT EXISTS (SELECT 1 FROM globals);
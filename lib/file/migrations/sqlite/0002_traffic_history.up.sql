@@ -0,0 +1,12 @@
+-- 流量历史，与 mysql/0002_traffic_history.up.sql 保持一致
+CREATE TABLE IF NOT EXISTS traffic_samples (
+    kind TEXT NOT NULL,
+    object_id INTEGER NOT NULL,
+    resolution TEXT NOT NULL,
+    bucket INTEGER NOT NULL,
+    in_bytes INTEGER NOT NULL DEFAULT 0,
+    out_bytes INTEGER NOT NULL DEFAULT 0,
+    conns INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (kind, object_id, resolution, bucket)
+);
+CREATE INDEX IF NOT EXISTS idx_traffic_samples_bucket ON traffic_samples (resolution, bucket);
@@ -0,0 +1,5 @@
+ALTER TABLE orders DROP COLUMN plan_id;
+ALTER TABLE accounts DROP COLUMN max_tunnels;
+ALTER TABLE accounts DROP COLUMN max_clients;
+ALTER TABLE accounts DROP COLUMN plan_id;
+DROP TABLE IF EXISTS plans;
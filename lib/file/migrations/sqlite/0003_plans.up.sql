@@ -0,0 +1,19 @@
+-- 套餐目录，与 mysql/0003_plans.up.sql 保持一致
+CREATE TABLE IF NOT EXISTS plans (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    flow REAL NOT NULL DEFAULT 0,
+    rate_limit INTEGER NOT NULL DEFAULT 0,
+    max_clients INTEGER NOT NULL DEFAULT 0,
+    max_tunnels INTEGER NOT NULL DEFAULT 0,
+    months INTEGER NOT NULL DEFAULT 0,
+    price REAL NOT NULL DEFAULT 0,
+    status INTEGER NOT NULL DEFAULT 1,
+    remark TEXT NOT NULL DEFAULT ''
+);
+
+ALTER TABLE accounts ADD COLUMN plan_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN max_clients INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN max_tunnels INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE orders ADD COLUMN plan_id INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS order_credits;
+DROP INDEX IF EXISTS uk_orders_external_transaction_id;
+CREATE INDEX IF NOT EXISTS idx_orders_external_transaction_id ON orders (external_transaction_id);
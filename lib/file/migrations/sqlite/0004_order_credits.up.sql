@@ -0,0 +1,20 @@
+-- 外部交易号作为订单结算的幂等键，与 mysql/0004_order_credits.up.sql 保持一致
+DROP INDEX IF EXISTS idx_orders_external_transaction_id;
+CREATE UNIQUE INDEX IF NOT EXISTS uk_orders_external_transaction_id ON orders (external_transaction_id);
+
+CREATE TABLE IF NOT EXISTS order_credits (
+    order_id INTEGER PRIMARY KEY,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    flow REAL NOT NULL DEFAULT 0,
+    months INTEGER NOT NULL DEFAULT 0,
+    plan_id INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_order_credits_account_id ON order_credits (account_id);
+
+INSERT INTO order_credits (order_id, account_id, flow, months, plan_id, created_at)
+SELECT order_id, CAST(account_id AS INTEGER),
+    CASE WHEN payment_type IN ('traffic', 'plan') THEN flow * 1024 * 1024 ELSE 0 END,
+    CASE WHEN payment_type = 'traffic' THEN 0 ELSE months END,
+    plan_id, CAST(strftime('%s', 'now') AS INTEGER)
+FROM orders WHERE order_status = 'paid';
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_orders_created_at;
+ALTER TABLE order_credits DROP COLUMN refunded_at;
+ALTER TABLE order_credits DROP COLUMN prev_rate_limit;
+ALTER TABLE order_credits DROP COLUMN prev_max_clients;
+ALTER TABLE order_credits DROP COLUMN prev_max_tunnels;
+ALTER TABLE order_credits DROP COLUMN prev_plan_id;
@@ -0,0 +1,8 @@
+-- 与 mysql/0005_order_refunds.up.sql 保持一致
+ALTER TABLE order_credits ADD COLUMN refunded_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE order_credits ADD COLUMN prev_rate_limit INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE order_credits ADD COLUMN prev_max_clients INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE order_credits ADD COLUMN prev_max_tunnels INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE order_credits ADD COLUMN prev_plan_id INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_orders_created_at ON orders (created_at);
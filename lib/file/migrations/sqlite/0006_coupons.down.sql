@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_orders_coupon_code;
+ALTER TABLE orders DROP COLUMN bonus_flow;
+ALTER TABLE orders DROP COLUMN discount;
+ALTER TABLE orders DROP COLUMN coupon_code;
+DROP TABLE IF EXISTS coupons;
@@ -0,0 +1,18 @@
+-- 与 mysql/0006_coupons.up.sql 保持一致
+CREATE TABLE IF NOT EXISTS coupons (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL UNIQUE,
+    kind TEXT NOT NULL,
+    value REAL NOT NULL DEFAULT 0,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    used INTEGER NOT NULL DEFAULT 0,
+    start_at INTEGER NOT NULL DEFAULT 0,
+    end_at INTEGER NOT NULL DEFAULT 0,
+    status INTEGER NOT NULL DEFAULT 1,
+    remark TEXT NOT NULL DEFAULT ''
+);
+
+ALTER TABLE orders ADD COLUMN coupon_code TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN discount REAL NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN bonus_flow REAL NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_orders_coupon_code ON orders (coupon_code);
@@ -0,0 +1,4 @@
+ALTER TABLE accounts DROP COLUMN last_reset;
+ALTER TABLE accounts DROP COLUMN reset_day;
+ALTER TABLE accounts DROP COLUMN allowance_left;
+ALTER TABLE accounts DROP COLUMN allowance;
@@ -0,0 +1,5 @@
+-- 与 mysql/0007_traffic_allowance.up.sql 保持一致
+ALTER TABLE accounts ADD COLUMN allowance REAL NOT NULL DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN allowance_left REAL NOT NULL DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN reset_day INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN last_reset INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,16 @@
+-- 与 mysql/0008_notifications.up.sql 保持一致
+CREATE TABLE IF NOT EXISTS notify_settings (
+    account_id INTEGER PRIMARY KEY,
+    email TEXT NOT NULL DEFAULT '',
+    webhook_url TEXT NOT NULL DEFAULT '',
+    thresholds TEXT NOT NULL DEFAULT '80,95,100',
+    expiry INTEGER NOT NULL DEFAULT 1,
+    enabled INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS notify_sent (
+    account_id INTEGER NOT NULL,
+    event_key TEXT NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (account_id, event_key)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS totp_recovery;
+DROP TABLE IF EXISTS totp;
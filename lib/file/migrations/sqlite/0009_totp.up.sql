@@ -0,0 +1,15 @@
+-- 与 mysql/0009_totp.up.sql 保持一致
+CREATE TABLE IF NOT EXISTS totp (
+    account_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled INTEGER NOT NULL DEFAULT 0,
+    last_step INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS totp_recovery (
+    account_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (account_id, code_hash)
+);
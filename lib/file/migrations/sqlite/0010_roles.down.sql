@@ -0,0 +1,2 @@
+ALTER TABLE accounts DROP COLUMN reseller_id;
+ALTER TABLE accounts DROP COLUMN role;
@@ -0,0 +1,3 @@
+-- 与 mysql/0010_roles.up.sql 保持一致
+ALTER TABLE accounts ADD COLUMN role TEXT NOT NULL DEFAULT 'owner';
+ALTER TABLE accounts ADD COLUMN reseller_id INTEGER NOT NULL DEFAULT 0;
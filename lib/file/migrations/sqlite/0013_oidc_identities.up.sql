@@ -0,0 +1,10 @@
+-- 与 mysql/0013_oidc_identities.up.sql 保持一致
+CREATE TABLE IF NOT EXISTS oidc_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    account_id INTEGER NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_oidc_identities_account ON oidc_identities (account_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS account_tokens;
+ALTER TABLE accounts DROP COLUMN email_verified;
+ALTER TABLE accounts DROP COLUMN email;
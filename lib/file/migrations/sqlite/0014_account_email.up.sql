@@ -0,0 +1,15 @@
+-- 与 mysql/0014_account_email.up.sql 保持一致
+ALTER TABLE accounts ADD COLUMN email TEXT NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS account_tokens (
+    token_hash TEXT PRIMARY KEY,
+    account_id INTEGER NOT NULL,
+    purpose TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    expire_at INTEGER NOT NULL DEFAULT 0,
+    used_at INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_tokens_account ON account_tokens (account_id);
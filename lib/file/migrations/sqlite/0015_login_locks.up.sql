@@ -0,0 +1,13 @@
+-- 与 mysql/0015_login_locks.up.sql 保持一致
+CREATE TABLE IF NOT EXISTS login_locks (
+    scope TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    level INTEGER NOT NULL DEFAULT 0,
+    window_start INTEGER NOT NULL DEFAULT 0,
+    locked_until INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (scope, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_locks_updated ON login_locks (updated_at);
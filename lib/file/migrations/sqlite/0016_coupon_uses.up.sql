@@ -0,0 +1,13 @@
+-- 与 mysql/0016_coupon_uses.up.sql 保持一致
+CREATE TABLE IF NOT EXISTS coupon_uses (
+    code TEXT NOT NULL,
+    account_id TEXT NOT NULL,
+    order_id INTEGER NOT NULL,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (code, account_id)
+);
+
+INSERT OR IGNORE INTO coupon_uses (code, account_id, order_id, created_at)
+SELECT coupon_code, account_id, MIN(order_id), MIN(created_at) FROM orders
+WHERE coupon_code <> '' AND order_status IN ('paid', 'refunded')
+GROUP BY coupon_code, account_id;
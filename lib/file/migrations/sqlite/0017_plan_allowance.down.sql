@@ -0,0 +1,3 @@
+ALTER TABLE order_credits DROP COLUMN allowance;
+ALTER TABLE order_credits DROP COLUMN prev_allowance;
+ALTER TABLE order_credits DROP COLUMN prev_reset_day;
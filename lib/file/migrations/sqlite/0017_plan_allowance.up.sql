@@ -0,0 +1,4 @@
+-- 与 mysql/0017_plan_allowance.up.sql 保持一致
+ALTER TABLE order_credits ADD COLUMN allowance REAL NOT NULL DEFAULT 0;
+ALTER TABLE order_credits ADD COLUMN prev_allowance REAL NOT NULL DEFAULT 0;
+ALTER TABLE order_credits ADD COLUMN prev_reset_day INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,137 @@
+package file
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultThresholds 默认的流量额度使用百分比提醒阈值
+const DefaultThresholds = "80,95,100"
+
+// NotifySetting 账号的通知设置，Thresholds 为逗号分隔的流量额度使用百分比，Expiry 表示是否发送到期提醒
+type NotifySetting struct {
+	AccountId  int    `json:"account_id"`
+	Email      string `json:"email"`
+	WebhookUrl string `json:"webhook_url"`
+	Thresholds string `json:"thresholds"`
+	Expiry     bool   `json:"expiry"`
+	Enabled    bool   `json:"enabled"`
+}
+
+// NotifyStore 通知相关的存储操作
+type NotifyStore interface {
+	GetNotifySetting(accountId int) (*NotifySetting, error)
+	SaveNotifySetting(n *NotifySetting) error
+	MarkNotified(accountId int, key string) (bool, error)
+	ClearNotified(accountId int, key string) error
+	GetNotified(accountId int) ([]string, error)
+}
+
+// ThresholdList 解析后的阈值，按从小到大排序
+func (n *NotifySetting) ThresholdList() []int {
+	var list []int
+	for _, v := range strings.Split(n.Thresholds, ",") {
+		if p, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			list = append(list, p)
+		}
+	}
+	sort.Ints(list)
+	return list
+}
+
+// GetNotifySetting 返回账号的通知设置，未设置时返回默认设置
+func (s *DbUtils) GetNotifySetting(accountId int) (*NotifySetting, error) {
+	n := &NotifySetting{AccountId: accountId}
+	err := s.SqlDB.QueryRow("SELECT email, webhook_url, thresholds, expiry, enabled FROM notify_settings WHERE account_id = ?", accountId).
+		Scan(&n.Email, &n.WebhookUrl, &n.Thresholds, &n.Expiry, &n.Enabled)
+	if err == sql.ErrNoRows {
+		return &NotifySetting{AccountId: accountId, Thresholds: DefaultThresholds, Expiry: true, Enabled: true}, nil
+	}
+	return n, err
+}
+
+// SaveNotifySetting 保存账号的通知设置
+func (s *DbUtils) SaveNotifySetting(n *NotifySetting) error {
+	if n.AccountId <= 0 {
+		return errors.New("account is required")
+	}
+	if n.Email != "" && !strings.Contains(n.Email, "@") {
+		return errors.New("invalid email address")
+	}
+	if n.WebhookUrl != "" {
+		if u, err := url.Parse(n.WebhookUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("webhook url must be an http or https url")
+		}
+	}
+	var list []string
+	for _, p := range n.ThresholdList() {
+		if p <= 0 || p > 100 {
+			return errors.New("thresholds must be between 1 and 100")
+		}
+		list = append(list, strconv.Itoa(p))
+	}
+	n.Thresholds = strings.Join(list, ",")
+	res, err := s.SqlDB.Exec("UPDATE notify_settings SET email = ?, webhook_url = ?, thresholds = ?, expiry = ?, enabled = ? WHERE account_id = ?",
+		n.Email, n.WebhookUrl, n.Thresholds, n.Expiry, n.Enabled, n.AccountId)
+	if err != nil {
+		return err
+	}
+	if c, _ := res.RowsAffected(); c > 0 {
+		return nil
+	}
+	var exists int
+	if err := s.SqlDB.QueryRow("SELECT COUNT(*) FROM notify_settings WHERE account_id = ?", n.AccountId).Scan(&exists); err != nil || exists > 0 {
+		return err
+	}
+	_, err = s.SqlDB.Exec("INSERT INTO notify_settings (account_id, email, webhook_url, thresholds, expiry, enabled) VALUES (?, ?, ?, ?, ?, ?)",
+		n.AccountId, n.Email, n.WebhookUrl, n.Thresholds, n.Expiry, n.Enabled)
+	return err
+}
+
+// insertIgnoreSql 忽略主键冲突的插入语句前缀
+func (s *DbUtils) insertIgnoreSql() string {
+	if s.driver == DriverSqlite {
+		return "INSERT OR IGNORE INTO "
+	}
+	return "INSERT IGNORE INTO "
+}
+
+// MarkNotified 记录账号已发送 key 对应的通知，返回 false 表示之前已经发送过
+func (s *DbUtils) MarkNotified(accountId int, key string) (bool, error) {
+	res, err := s.SqlDB.Exec(s.insertIgnoreSql()+"notify_sent (account_id, event_key, created_at) VALUES (?, ?, ?)",
+		accountId, key, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ClearNotified 删除账号 key 对应的通知记录，使其可以再次发送
+func (s *DbUtils) ClearNotified(accountId int, key string) error {
+	_, err := s.SqlDB.Exec("DELETE FROM notify_sent WHERE account_id = ? AND event_key = ?", accountId, key)
+	return err
+}
+
+// GetNotified 返回账号已发送的通知
+func (s *DbUtils) GetNotified(accountId int) ([]string, error) {
+	rows, err := s.SqlDB.Query("SELECT event_key FROM notify_sent WHERE account_id = ? ORDER BY event_key", accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		list = append(list, key)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,59 @@
+package file
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNotifySettings(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "user")
+	n, err := db.GetNotifySetting(id)
+	if err != nil || n.Thresholds != DefaultThresholds || !n.Enabled || !n.Expiry {
+		t.Fatalf("default setting %+v %v", n, err)
+	}
+	for _, bad := range []*NotifySetting{
+		{AccountId: id, Email: "nobody"},
+		{AccountId: id, WebhookUrl: "ftp://example.com/hook"},
+		{AccountId: id, Thresholds: "50,120"},
+		{Email: "a@example.com"},
+	} {
+		if err := db.SaveNotifySetting(bad); err == nil {
+			t.Fatalf("invalid setting %+v accepted", bad)
+		}
+	}
+	n = &NotifySetting{AccountId: id, Email: "a@example.com", WebhookUrl: "https://example.com/hook", Thresholds: "95, 50", Enabled: true}
+	for i := 0; i < 2; i++ {
+		if err := db.SaveNotifySetting(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := db.GetNotifySetting(id)
+	if err != nil || got.Thresholds != "50,95" || got.Expiry || got.Email != "a@example.com" {
+		t.Fatalf("saved setting %+v %v", got, err)
+	}
+	if l := got.ThresholdList(); !reflect.DeepEqual(l, []int{50, 95}) {
+		t.Fatalf("ThresholdList %v", l)
+	}
+}
+
+func TestMarkNotified(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "user")
+	if first, err := db.MarkNotified(id, "quota:80"); err != nil || !first {
+		t.Fatalf("first mark %v %v", first, err)
+	}
+	if first, _ := db.MarkNotified(id, "quota:80"); first {
+		t.Fatal("notification marked twice")
+	}
+	db.MarkNotified(id, "quota:95")
+	if err := db.ClearNotified(id, "quota:80"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := db.GetNotified(id); !reflect.DeepEqual(keys, []string{"quota:95"}) {
+		t.Fatalf("GetNotified %v", keys)
+	}
+	if first, _ := db.MarkNotified(id, "quota:80"); !first {
+		t.Fatal("cleared notification not re-armed")
+	}
+}
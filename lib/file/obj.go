@@ -79,6 +79,16 @@ type Account struct {
 	WebPassword     string     //the password of web login
 	ConfigConnAllow bool       //is allow connected by config file
 	MaxTunnelNum    int
+	MaxClientNum    int    // 允许创建的客户端数，0 表示不限制
+	PlanId          int    // 当前套餐
+	Allowance       int64  // 每月流量额度(KB)，Flow.FlowLimit 为购买的流量余额，优先消耗额度
+	AllowanceLeft   int64  // 本周期剩余的流量额度(KB)
+	ResetDay        int    // 每月重置额度的日期，0 表示不重置
+	LastReset       int64  // 上次重置额度的 unix 时间戳
+	Role            string // 角色，见 RoleAdmin 等
+	ResellerId      int    // 管理该账号的代理商账号，0 表示没有
+	Email           string // 邮箱，用于找回密码
+	EmailVerified   bool   // 邮箱是否已验证，未验证的账号不能添加客户端
 	BlackIpList     []string
 	CreateTime      string
 	LastOnlineTime  string
@@ -87,17 +97,51 @@ type Account struct {
 
 func NewAccount() *Account {
 	return &Account{
-		Cnf:       new(Config),
-		Id:        0,
-		Remark:    "",
-		Status:    true,
-		RateLimit: 0,
-		Flow:      new(Flow),
-		Rate:      nil,
-		RWMutex:   sync.RWMutex{},
+		Cnf:           new(Config),
+		Id:            0,
+		Remark:        "",
+		Status:        true,
+		RateLimit:     0,
+		Role:          RoleOwner,
+		Flow:          new(Flow),
+		EmailVerified: true,
+		Rate:          nil,
+		RWMutex:       sync.RWMutex{},
 	}
 }
 
+// 数据库与配置中到期时间可能的格式，不带时区的按服务器本地时区解析
+var expireTimeLayouts = []string{"2006-01-02 15:04:05", time.RFC3339, "2006-01-02"}
+
+// ExpireAt 返回账号的到期时间，未设置到期时间时 ok 为 false
+func (s *Account) ExpireAt() (t time.Time, ok bool) {
+	v := strings.TrimSpace(s.ExpireTime)
+	if v == "" {
+		return t, false
+	}
+	for _, layout := range expireTimeLayouts {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return t, false
+}
+
+// Remaining 账号剩余可用的流量(KB)，为本周期剩余额度与购买的流量余额之和
+func (s *Account) Remaining() int64 {
+	var flow int64
+	if s.Flow != nil && s.Flow.FlowLimit > 0 {
+		flow = s.Flow.FlowLimit
+	}
+	return s.AllowanceLeft + flow
+}
+
+// IsExpired 账号在 now 时是否已到期，未设置或无法解析到期时间的账号不会到期
+func (s *Account) IsExpired(now time.Time) bool {
+	t, ok := s.ExpireAt()
+	return ok && !now.Before(t)
+}
+
 func NewClient(vKey string, noStore bool, noDisplay bool) *Client {
 	return &Client{
 		Cnf:       new(Config),
@@ -263,6 +307,10 @@ type Order struct {
 	ExternalTransactionId string  `json:"external_transaction_id"` // 外部交易号
 	CreatedAt             int64   `json:"created_at"`              // 创建时间
 	AccountId             string  `json:"account_id"`              // 用户账号
+	PlanId                int     `json:"plan_id"`                 // 购买的套餐，0 表示按流量或月数购买
+	CouponCode            string  `json:"coupon_code"`             // 使用的优惠码
+	Discount              float64 `json:"discount"`                // 优惠码减免的金额
+	BonusFlow             float64 `json:"bonus_flow"`              // 优惠码赠送的流量(GB)
 	sync.RWMutex
 }
 
@@ -287,5 +335,6 @@ func (s *Target) GetRandomTarget() (string, error) {
 
 type Glob struct {
 	BlackIpList []string
+	RequireTotp bool // 要求所有用户开启两步验证
 	sync.RWMutex
 }
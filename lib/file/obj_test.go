@@ -0,0 +1,31 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountExpireAt(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	cases := []struct {
+		expireTime string
+		expired    bool
+	}{
+		{"", false},
+		{"invalid", false},
+		{"2024-05-01 11:59:59", true},
+		{"2024-05-01 12:00:00", true},
+		{"2024-05-01 12:00:01", false},
+		{"2024-06-01", false},
+		{now.Add(-time.Minute).Format(time.RFC3339), true},
+	}
+	for _, c := range cases {
+		a := &Account{ExpireTime: c.expireTime}
+		if got := a.IsExpired(now); got != c.expired {
+			t.Errorf("IsExpired(%q) = %v, want %v", c.expireTime, got, c.expired)
+		}
+	}
+	if _, ok := (&Account{}).ExpireAt(); ok {
+		t.Error("empty expire time should not be set")
+	}
+}
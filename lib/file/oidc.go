@@ -0,0 +1,37 @@
+package file
+
+import (
+	"database/sql"
+	"time"
+)
+
+// OidcStore OpenID Connect 身份关联相关的存储操作
+type OidcStore interface {
+	GetOidcAccountId(issuer, subject string) (int, error)
+	LinkOidcIdentity(issuer, subject string, accountId int) error
+	HasOidcIdentity(accountId int) (bool, error)
+}
+
+// GetOidcAccountId 返回身份关联的账号 id，未关联时返回 0
+func (s *DbUtils) GetOidcAccountId(issuer, subject string) (int, error) {
+	var id int
+	err := s.SqlDB.QueryRow("SELECT account_id FROM oidc_identities WHERE issuer = ? AND subject = ?", issuer, subject).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// LinkOidcIdentity 关联身份与账号，同一身份只能关联一个账号
+func (s *DbUtils) LinkOidcIdentity(issuer, subject string, accountId int) error {
+	_, err := s.SqlDB.Exec("INSERT INTO oidc_identities (issuer, subject, account_id, created_at) VALUES (?, ?, ?, ?)",
+		issuer, subject, accountId, time.Now().Unix())
+	return err
+}
+
+// HasOidcIdentity 账号是否关联了身份提供方的身份
+func (s *DbUtils) HasOidcIdentity(accountId int) (bool, error) {
+	var n int
+	err := s.SqlDB.QueryRow("SELECT COUNT(*) FROM oidc_identities WHERE account_id = ?", accountId).Scan(&n)
+	return n > 0, err
+}
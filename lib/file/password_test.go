@@ -0,0 +1,56 @@
+package file
+
+import (
+	"testing"
+
+	"ehang.io/nps/lib/crypt"
+)
+
+func TestAccountPasswordHash(t *testing.T) {
+	db := newTestSqliteDb(t)
+	a := NewAccount()
+	a.WebUserName, a.WebPassword = "user", "secret"
+	if err := db.NewAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetByUsername("user")
+	if err != nil || !crypt.IsPasswordHash(got.WebPassword) {
+		t.Fatalf("password not hashed: %q %v", got.WebPassword, err)
+	}
+	if ok, upgrade := crypt.CheckPassword(got.WebPassword, "secret"); !ok || upgrade {
+		t.Fatalf("CheckPassword %v %v", ok, upgrade)
+	}
+	if ok, _ := crypt.CheckPassword(got.WebPassword, "wrong"); ok {
+		t.Fatal("wrong password accepted")
+	}
+
+	// 旧版本保存的明文密码
+	if _, err := db.SqlDB.Exec("UPDATE accounts SET web_password = ? WHERE id = ?", "plain", got.Id); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = db.GetByUsername("user")
+	if ok, upgrade := crypt.CheckPassword(got.WebPassword, "plain"); !ok || !upgrade {
+		t.Fatalf("plaintext CheckPassword %v %v", ok, upgrade)
+	}
+	if err := db.UpdatePassword(got.Id, "plain"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = db.GetByUsername("user")
+	if ok, upgrade := crypt.CheckPassword(got.WebPassword, "plain"); !ok || upgrade {
+		t.Fatalf("upgraded CheckPassword %v %v", ok, upgrade)
+	}
+
+	// 更新账号时已是哈希的密码保持不变
+	full, err := db.GetAccountInfo(got.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := full.WebPassword
+	full.Remark, full.Status = "changed", true
+	if err := db.UpdateAccount(full); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = db.GetByUsername("user"); got.WebPassword != hash {
+		t.Fatal("password hash rewritten on update")
+	}
+}
@@ -0,0 +1,154 @@
+package file
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Plan 套餐，Flow 为套餐每月的流量额度(GB)，RateLimit 为带宽上限(KB/s)，
+// Months 为套餐周期，0 表示只购买流量不延长到期时间，各项上限为 0 表示不限制
+type Plan struct {
+	Id         int     `json:"id"`
+	Name       string  `json:"name"`
+	Flow       float64 `json:"flow"`
+	RateLimit  int     `json:"rate_limit"`
+	MaxClients int     `json:"max_clients"`
+	MaxTunnels int     `json:"max_tunnels"`
+	Months     int     `json:"months"`
+	Price      float64 `json:"price"`
+	Status     bool    `json:"status"`
+	Remark     string  `json:"remark"`
+}
+
+// PlanStore 套餐相关的存储操作
+type PlanStore interface {
+	GetPlans(onlyEnabled bool) ([]*Plan, error)
+	GetPlan(id int) (*Plan, error)
+	SavePlan(p *Plan) error
+	DelPlan(id int) error
+	ApplyPlan(accountId int, p *Plan) error
+}
+
+// ErrPlanNotFound 套餐不存在
+var ErrPlanNotFound = errors.New("plan not found")
+
+const planColumns = "id, name, flow, rate_limit, max_clients, max_tunnels, months, price, status, remark"
+
+func scanPlan(row interface{ Scan(...interface{}) error }) (*Plan, error) {
+	p := new(Plan)
+	err := row.Scan(&p.Id, &p.Name, &p.Flow, &p.RateLimit, &p.MaxClients, &p.MaxTunnels, &p.Months, &p.Price, &p.Status, &p.Remark)
+	return p, err
+}
+
+// GetPlans 返回套餐列表，onlyEnabled 为 true 时只返回上架的套餐
+func (s *DbUtils) GetPlans(onlyEnabled bool) ([]*Plan, error) {
+	q := NewQuery("plans").OrderBy("", "", nil, "price, id")
+	if onlyEnabled {
+		q.Where("status = 1")
+	}
+	query, args := q.SelectSql(planColumns)
+	rows, err := s.SqlDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*Plan
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// GetPlan 根据 id 获取套餐
+func (s *DbUtils) GetPlan(id int) (*Plan, error) {
+	p, err := scanPlan(s.SqlDB.QueryRow("SELECT "+planColumns+" FROM plans WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrPlanNotFound
+	}
+	return p, err
+}
+
+// SavePlan 保存套餐，Id 为 0 时新建并回填 Id
+func (s *DbUtils) SavePlan(p *Plan) error {
+	if p.Name == "" {
+		return errors.New("plan name is required")
+	}
+	if p.Flow < 0 || p.RateLimit < 0 || p.MaxClients < 0 || p.MaxTunnels < 0 || p.Months < 0 || p.Price < 0 {
+		return errors.New("plan limits and price must not be negative")
+	}
+	if p.Id == 0 {
+		res, err := s.SqlDB.Exec(`INSERT INTO plans (name, flow, rate_limit, max_clients, max_tunnels, months, price, status, remark)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			p.Name, p.Flow, p.RateLimit, p.MaxClients, p.MaxTunnels, p.Months, p.Price, p.Status, p.Remark)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		p.Id = int(id)
+		return err
+	}
+	res, err := s.SqlDB.Exec(`UPDATE plans SET name = ?, flow = ?, rate_limit = ?, max_clients = ?, max_tunnels = ?,
+		months = ?, price = ?, status = ?, remark = ? WHERE id = ?`,
+		p.Name, p.Flow, p.RateLimit, p.MaxClients, p.MaxTunnels, p.Months, p.Price, p.Status, p.Remark, p.Id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrPlanNotFound
+	}
+	return nil
+}
+
+// DelPlan 删除套餐，已购买该套餐的账号保留其额度
+func (s *DbUtils) DelPlan(id int) error {
+	_, err := s.SqlDB.Exec("DELETE FROM plans WHERE id = ?", id)
+	return err
+}
+
+// ApplyPlan 在同一事务中把套餐的每月额度、带宽、客户端与隧道数上限及周期应用到账号
+func (s *DbUtils) ApplyPlan(accountId int, p *Plan) error {
+	tx, err := s.SqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.applyPlanTx(tx, accountId, p); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyPlanTx 套餐的流量为账号的每月额度，购买时开始新的额度周期，未设置重置日的账号以购买当天为每月重置日；
+// 套餐没有流量时不修改账号的额度
+func (s *DbUtils) applyPlanTx(tx *sql.Tx, accountId int, p *Plan) error {
+	res, err := tx.Exec("UPDATE accounts SET rate_limit = ?, max_clients = ?, max_tunnels = ?, plan_id = ? WHERE id = ?",
+		p.RateLimit, p.MaxClients, p.MaxTunnels, p.Id, accountId)
+	if err != nil {
+		return fmt.Errorf("apply plan %d to account %d: %v", p.Id, accountId, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("apply plan %d: account %d not found", p.Id, accountId)
+	}
+	if p.Flow > 0 {
+		// 账号流量以 KB 为单位
+		now := time.Now()
+		allowance := p.Flow * 1024 * 1024
+		if _, err := tx.Exec(`UPDATE accounts SET allowance = ?, allowance_left = ?, last_reset = ?,
+			reset_day = CASE WHEN reset_day > 0 THEN reset_day ELSE ? END WHERE id = ?`,
+			allowance, allowance, now.Unix(), now.Day(), accountId); err != nil {
+			return fmt.Errorf("apply plan %d to account %d: %v", p.Id, accountId, err)
+		}
+	}
+	if p.Months > 0 {
+		if _, err := tx.Exec(s.addMonthsSql(), p.Months, accountId); err != nil {
+			return fmt.Errorf("apply plan %d to account %d: %v", p.Id, accountId, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package file
+
+import "testing"
+
+func TestPlans(t *testing.T) {
+	db := newTestSqliteDb(t)
+	basic := &Plan{Name: "basic", Flow: 10, RateLimit: 512, MaxClients: 2, MaxTunnels: 5, Months: 1, Price: 9.9, Status: true}
+	pro := &Plan{Name: "pro", Flow: 100, RateLimit: 4096, MaxClients: 10, MaxTunnels: 50, Months: 1, Price: 49, Status: false}
+	for _, p := range []*Plan{pro, basic} {
+		if err := db.SavePlan(p); err != nil || p.Id == 0 {
+			t.Fatalf("save plan %s: %v", p.Name, err)
+		}
+	}
+	if err := db.SavePlan(&Plan{Name: "basic"}); err == nil {
+		t.Fatal("duplicate plan name accepted")
+	}
+	if err := db.SavePlan(&Plan{Name: "bad", Price: -1}); err == nil {
+		t.Fatal("negative price accepted")
+	}
+	if list, err := db.GetPlans(true); err != nil || len(list) != 1 || list[0].Name != "basic" {
+		t.Fatalf("enabled plans %v %v", list, err)
+	}
+	if list, _ := db.GetPlans(false); len(list) != 2 || list[0].Name != "basic" {
+		t.Fatalf("plans are not ordered by price: %v", list)
+	}
+	pro.Status = true
+	if err := db.SavePlan(pro); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := db.GetPlan(pro.Id); err != nil || !p.Status || p.MaxTunnels != 50 {
+		t.Fatalf("GetPlan %+v %v", p, err)
+	}
+	if _, err := db.GetPlan(100); err != ErrPlanNotFound {
+		t.Fatalf("expected ErrPlanNotFound, got %v", err)
+	}
+
+	a := NewAccount()
+	a.WebUserName = "user"
+	if err := db.NewAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	accounts, _ := db.GetAllAccounts()
+	id := accounts[len(accounts)-1].Id
+	if err := db.ApplyPlan(id, basic); err != nil {
+		t.Fatal(err)
+	}
+	info, err := db.GetAccountInfo(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PlanId != basic.Id || info.RateLimit != 512 || info.MaxClientNum != 2 || info.MaxTunnelNum != 5 ||
+		info.Allowance != 10*1024*1024 || info.AllowanceLeft != 10*1024*1024 || info.ResetDay == 0 || info.Flow.FlowLimit != 0 || info.ExpireTime == "" {
+		t.Fatalf("plan not applied: %+v", info)
+	}
+	if err := db.ApplyPlan(id+100, basic); err == nil {
+		t.Fatal("applied plan to missing account")
+	}
+	if err := db.DelPlan(basic.Id); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := db.GetPlans(false); len(list) != 1 {
+		t.Fatalf("plan not deleted: %v", list)
+	}
+}
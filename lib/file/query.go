@@ -0,0 +1,115 @@
+package file
+
+import (
+	"strconv"
+	"strings"
+)
+
+// likeEscape LIKE 查询使用的转义字符，MySQL 与 SQLite 均支持
+const likeEscape = "!"
+
+// Query 参数化的列表查询构造器，用于分页、排序与模糊搜索
+// 表名、列名与排序表达式只能来自代码中的常量或白名单，用户输入一律通过占位符传入
+type Query struct {
+	table   string
+	where   []string
+	args    []interface{}
+	orderBy string
+	start   int
+	length  int
+}
+
+// NewQuery 创建针对 table 的查询
+func NewQuery(table string) *Query {
+	return &Query{table: table}
+}
+
+// Where 追加一个使用 ? 占位符的条件，多个条件之间为 AND 关系
+func (q *Query) Where(cond string, args ...interface{}) *Query {
+	q.where = append(q.where, "("+cond+")")
+	q.args = append(q.args, args...)
+	return q
+}
+
+// Search 在 likeColumns 中模糊匹配 term，term 为整数时同时精确匹配 intColumns
+func (q *Query) Search(term string, intColumns []string, likeColumns []string) *Query {
+	if term == "" {
+		return q
+	}
+	var conds []string
+	var args []interface{}
+	if n, err := strconv.Atoi(term); err == nil {
+		for _, col := range intColumns {
+			conds = append(conds, col+" = ?")
+			args = append(args, n)
+		}
+	}
+	pattern := "%" + EscapeLike(term) + "%"
+	for _, col := range likeColumns {
+		conds = append(conds, col+" LIKE ? ESCAPE '"+likeEscape+"'")
+		args = append(args, pattern)
+	}
+	if len(conds) == 0 {
+		return q
+	}
+	return q.Where(strings.Join(conds, " OR "), args...)
+}
+
+// OrderBy 按白名单 allowed 将前端字段名映射为排序表达式，不在白名单中的字段使用 def
+// order 只接受 asc 或 desc（忽略大小写），其余值按 asc 处理
+func (q *Query) OrderBy(field, order string, allowed map[string]string, def string) *Query {
+	expr, ok := allowed[field]
+	if !ok {
+		expr = def
+	}
+	if expr == "" {
+		return q
+	}
+	if strings.ToLower(order) == "desc" {
+		q.orderBy = expr + " DESC"
+	} else {
+		q.orderBy = expr + " ASC"
+	}
+	return q
+}
+
+// Page 设置分页，length <= 0 表示不分页
+func (q *Query) Page(start, length int) *Query {
+	if start < 0 {
+		start = 0
+	}
+	q.start = start
+	q.length = length
+	return q
+}
+
+func (q *Query) whereSql() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.where, " AND ")
+}
+
+// SelectSql 返回查询 columns 的语句及参数
+func (q *Query) SelectSql(columns string) (string, []interface{}) {
+	query := "SELECT " + columns + " FROM " + q.table + q.whereSql()
+	args := append([]interface{}{}, q.args...)
+	if q.orderBy != "" {
+		query += " ORDER BY " + q.orderBy
+	}
+	if q.length > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, q.length, q.start)
+	}
+	return query, args
+}
+
+// CountSql 返回统计总数的语句及参数，不受排序与分页影响
+func (q *Query) CountSql() (string, []interface{}) {
+	return "SELECT COUNT(*) FROM " + q.table + q.whereSql(), append([]interface{}{}, q.args...)
+}
+
+// EscapeLike 转义 LIKE 通配符，使 term 按字面量匹配
+func EscapeLike(term string) string {
+	return strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_").Replace(term)
+}
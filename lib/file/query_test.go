@@ -0,0 +1,91 @@
+package file
+
+import (
+	"strings"
+	"testing"
+)
+
+var hostileInputs = []string{
+	"' OR '1'='1",
+	"1' OR 1=1 --",
+	"1; DROP TABLE clients; --",
+	"%%",
+	"a_p_a",
+	"!%",
+	"id DESC, (SELECT 1)",
+	"\\' OR 1=1 #",
+}
+
+func TestQueryKeepsInputOutOfSql(t *testing.T) {
+	for _, in := range hostileInputs {
+		q := NewQuery("clients").Where("no_display = 0")
+		q.Search(in, []string{"id"}, []string{"verify_key", "remark"})
+		q.OrderBy(in, in, clientSortFields, "id").Page(0, 10)
+		query, args := q.SelectSql("id")
+		if strings.Contains(query, in) {
+			t.Fatalf("input %q leaked into sql %s", in, query)
+		}
+		if !strings.HasSuffix(query, "ORDER BY id ASC LIMIT ? OFFSET ?") {
+			t.Fatalf("unexpected order clause for %q: %s", in, query)
+		}
+		if strings.Count(query, "?") != len(args) {
+			t.Fatalf("placeholders and args mismatch: %s %v", query, args)
+		}
+		countQuery, countArgs := q.CountSql()
+		if strings.Contains(countQuery, "ORDER BY") || strings.Count(countQuery, "?") != len(countArgs) {
+			t.Fatalf("unexpected count sql %s %v", countQuery, countArgs)
+		}
+	}
+}
+
+func TestQueryOrderBy(t *testing.T) {
+	q := NewQuery("clients").OrderBy("InletFlow", "DESC", clientSortFields, "id")
+	if query, _ := q.SelectSql("id"); query != "SELECT id FROM clients ORDER BY inlet_flow DESC" {
+		t.Fatalf("unexpected sql %s", query)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := EscapeLike("50%_a!b"); got != "50!%!_a!!b" {
+		t.Fatalf("EscapeLike returned %s", got)
+	}
+}
+
+func TestListWithHostileInputs(t *testing.T) {
+	db := newTestSqliteDb(t)
+	for i, key := range []string{"alpha", "beta", "50%off"} {
+		c := NewClient(key, false, false)
+		c.Id = i + 1
+		c.Remark = "remark " + key
+		if err := db.NewClient(c); err != nil {
+			t.Fatal(err)
+		}
+		task := &Tunnel{Id: i + 1, ClientId: c.Id, Mode: "tcp", Port: 9000 + i, Status: true, Password: key, Remark: key, Target: &Target{TargetStr: "127.0.0.1:80"}}
+		if err := db.NewTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, in := range hostileInputs {
+		if list, cnt := db.GetClientList(0, 10, in, in, in, 0); cnt != 0 || len(list) != 0 {
+			t.Fatalf("client search %q matched %d rows", in, cnt)
+		}
+		if list, cnt, err := db.GetHost(0, 10, 0, in); err != nil || cnt != 0 || len(list) != 0 {
+			t.Fatalf("host search %q matched %d rows, err %v", in, cnt, err)
+		}
+		if list, cnt, err := db.GetTunnelList(0, 10, "tcp", 0, in, in, in); err != nil || cnt != 0 || len(list) != 0 {
+			t.Fatalf("tunnel search %q matched %d rows, err %v", in, cnt, err)
+		}
+		if ids, err := db.GetSortedClientIDs(in, in); err != nil || len(ids) != 3 {
+			t.Fatalf("sorted ids with %q returned %v, err %v", in, ids, err)
+		}
+	}
+	if list, cnt := db.GetClientList(0, 10, "50%", "", "", 0); cnt != 1 || list[0].VerifyKey != "50%off" {
+		t.Fatalf("literal %% search returned %d rows", cnt)
+	}
+	if list, cnt := db.GetClientList(0, 1, "", "Id", "desc", 0); cnt != 3 || len(list) != 1 || list[0].Id != 3 {
+		t.Fatalf("paged client list returned %d rows of %d", len(list), cnt)
+	}
+	if list, cnt, err := db.GetTunnelList(0, 10, "tcp", 0, "9001", "Port", "desc"); err != nil || cnt != 1 || list[0].Port != 9001 {
+		t.Fatalf("tunnel port search returned %d rows, err %v", cnt, err)
+	}
+}
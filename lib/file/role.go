@@ -0,0 +1,78 @@
+package file
+
+import (
+	"errors"
+)
+
+// 账号角色
+const (
+	RoleAdmin    = "admin"    // 管理员，拥有全部权限
+	RoleOperator = "operator" // 运维人员，可以查看与修改所有账号的客户端、隧道与域名解析，管理账号
+	RoleReadOnly = "readonly" // 只读人员，可以查看所有账号的数据
+	RoleReseller = "reseller" // 代理商，管理自己创建的账号
+	RoleOwner    = "owner"    // 普通用户，只能访问自己的数据
+)
+
+// 权限
+const (
+	PermRead    = "read"    // 查看客户端、隧道、域名解析与流量
+	PermWrite   = "write"   // 修改客户端、隧道与域名解析
+	PermSelf    = "self"    // 修改自己的通知、两步验证设置与在线支付
+	PermAccount = "account" // 管理账号
+	PermBilling = "billing" // 管理套餐、优惠码与退款
+	PermSystem  = "system"  // 全局配置、配置导入导出与两步验证策略
+)
+
+var rolePerms = map[string][]string{
+	RoleAdmin:    {PermRead, PermWrite, PermSelf, PermAccount, PermBilling, PermSystem},
+	RoleOperator: {PermRead, PermWrite, PermSelf, PermAccount},
+	RoleReadOnly: {PermRead, PermSelf},
+	RoleReseller: {PermRead, PermWrite, PermSelf, PermAccount},
+	RoleOwner:    {PermRead, PermWrite, PermSelf},
+}
+
+// ValidRole 判断角色是否存在
+func ValidRole(role string) bool {
+	_, ok := rolePerms[role]
+	return ok
+}
+
+// RoleHas 判断角色是否拥有权限
+func RoleHas(role, perm string) bool {
+	for _, p := range rolePerms[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleIsGlobal 角色是否可以访问所有账号的数据，其余角色只能访问自己（代理商还包括其管理的账号）的数据
+func RoleIsGlobal(role string) bool {
+	return role == RoleAdmin || role == RoleOperator || role == RoleReadOnly
+}
+
+// RoleCovers 角色是否拥有目标角色的全部权限，用于限制只能管理权限不高于自己的账号；非全局角色不能管理全局角色
+func RoleCovers(role, target string) bool {
+	if RoleIsGlobal(target) && !RoleIsGlobal(role) {
+		return false
+	}
+	for _, p := range rolePerms[target] {
+		if !RoleHas(role, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetAccountRole 修改账号的角色与所属代理商
+func (s *DbUtils) SetAccountRole(accountId int, role string, resellerId int) error {
+	if !ValidRole(role) {
+		return errors.New("invalid role " + role)
+	}
+	if resellerId == accountId {
+		return errors.New("an account can not be its own reseller")
+	}
+	_, err := s.SqlDB.Exec("UPDATE accounts SET role = ?, reseller_id = ? WHERE id = ?", role, resellerId, accountId)
+	return err
+}
@@ -0,0 +1,80 @@
+package file
+
+import "testing"
+
+func TestRolePerms(t *testing.T) {
+	if !RoleHas(RoleAdmin, PermSystem) || RoleHas(RoleOperator, PermSystem) || RoleHas(RoleReadOnly, PermWrite) {
+		t.Fatal("unexpected role permissions")
+	}
+	if !RoleHas(RoleReseller, PermAccount) || RoleHas(RoleOwner, PermAccount) || RoleHas("unknown", PermRead) {
+		t.Fatal("unexpected role permissions")
+	}
+	if RoleIsGlobal(RoleReseller) || !RoleIsGlobal(RoleReadOnly) {
+		t.Fatal("unexpected global roles")
+	}
+	if !RoleCovers(RoleAdmin, RoleAdmin) || RoleCovers(RoleOperator, RoleAdmin) || !RoleCovers(RoleOperator, RoleReseller) {
+		t.Fatal("unexpected role coverage")
+	}
+	if RoleCovers(RoleReseller, RoleOperator) || RoleCovers(RoleReseller, RoleReadOnly) || !RoleCovers(RoleReseller, RoleOwner) {
+		t.Fatal("unexpected role coverage")
+	}
+}
+
+func TestAccountRole(t *testing.T) {
+	db := newTestSqliteDb(t)
+	reseller := newTestAccount(t, db, "reseller")
+	id := newTestAccount(t, db, "user")
+	if a, err := db.GetAccountInfo(id); err != nil || a.Role != RoleOwner || a.ResellerId != 0 {
+		t.Fatalf("default role %+v %v", a, err)
+	}
+	if err := db.SetAccountRole(id, "root", 0); err == nil {
+		t.Fatal("invalid role accepted")
+	}
+	if err := db.SetAccountRole(id, RoleOwner, id); err == nil {
+		t.Fatal("account accepted as its own reseller")
+	}
+	if err := db.SetAccountRole(reseller, RoleReseller, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetAccountRole(id, RoleOwner, reseller); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := db.GetByUsername("user"); err != nil || a.Role != RoleOwner || a.ResellerId != reseller {
+		t.Fatalf("GetByUsername %+v %v", a, err)
+	}
+	if a, _ := db.GetAccountInfo(reseller); a.Role != RoleReseller {
+		t.Fatalf("reseller role %q", a.Role)
+	}
+	a := NewAccount()
+	a.WebUserName = "bad"
+	a.Role = "root"
+	if err := db.NewAccount(a); err == nil {
+		t.Fatal("NewAccount accepted invalid role")
+	}
+}
+
+func TestOidcIdentity(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "alice")
+	if got, err := db.GetOidcAccountId("https://idp", "u-1"); got != 0 || err != nil {
+		t.Fatalf("GetOidcAccountId before link %d %v", got, err)
+	}
+	if err := db.LinkOidcIdentity("https://idp", "u-1", id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LinkOidcIdentity("https://idp", "u-1", id+1); err == nil {
+		t.Fatal("identity linked twice")
+	}
+	if got, _ := db.GetOidcAccountId("https://idp", "u-1"); got != id {
+		t.Fatalf("GetOidcAccountId %d", got)
+	}
+	if got, _ := db.GetOidcAccountId("https://other", "u-1"); got != 0 {
+		t.Fatalf("GetOidcAccountId other issuer %d", got)
+	}
+	if ok, err := db.HasOidcIdentity(id); !ok || err != nil {
+		t.Fatalf("HasOidcIdentity %v %v", ok, err)
+	}
+	if ok, _ := db.HasOidcIdentity(id + 1); ok {
+		t.Fatal("HasOidcIdentity of unlinked account")
+	}
+}
@@ -0,0 +1,135 @@
+package file
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginSession 登录会话，access token 过期后使用 refresh token 刷新，刷新时轮换 RefreshHash
+type LoginSession struct {
+	Id          string
+	AccountId   int // 配置文件中的管理员为 0
+	Username    string
+	Role        string
+	Mfa         bool
+	RefreshHash string `json:"-"`
+	UserAgent   string
+	Ip          string
+	CreatedAt   int64
+	LastSeenAt  int64
+	ExpireAt    int64
+	RevokedAt   int64
+}
+
+// Active 会话在 now 时是否有效
+func (l *LoginSession) Active(now time.Time) bool {
+	return l.RevokedAt == 0 && now.Unix() < l.ExpireAt
+}
+
+// LoginSessionStore 登录会话相关的存储操作
+type LoginSessionStore interface {
+	CreateLoginSession(l *LoginSession) error
+	GetLoginSession(id string) (*LoginSession, error)
+	RotateRefreshToken(id, oldHash, newHash string, expireAt int64, now time.Time) (bool, error)
+	ListLoginSessions(accountId int, now time.Time) ([]*LoginSession, error)
+	RevokeLoginSession(accountId int, id string) error
+	RevokeAccountSessions(accountId int) (int64, error)
+	DeleteExpiredLoginSessions(before time.Time) (int64, error)
+}
+
+const loginSessionColumns = "id, account_id, username, role, mfa, refresh_hash, user_agent, ip, created_at, last_seen_at, expire_at, revoked_at"
+
+func scanLoginSession(row interface{ Scan(...interface{}) error }) (*LoginSession, error) {
+	l := &LoginSession{}
+	err := row.Scan(&l.Id, &l.AccountId, &l.Username, &l.Role, &l.Mfa, &l.RefreshHash, &l.UserAgent, &l.Ip,
+		&l.CreatedAt, &l.LastSeenAt, &l.ExpireAt, &l.RevokedAt)
+	return l, err
+}
+
+// CreateLoginSession 保存新的登录会话
+func (s *DbUtils) CreateLoginSession(l *LoginSession) error {
+	if l.Id == "" || l.RefreshHash == "" {
+		return errors.New("session id and refresh token hash are required")
+	}
+	if l.CreatedAt == 0 {
+		l.CreatedAt = time.Now().Unix()
+	}
+	l.LastSeenAt = l.CreatedAt
+	_, err := s.SqlDB.Exec("INSERT INTO login_sessions ("+loginSessionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0)",
+		l.Id, l.AccountId, l.Username, l.Role, l.Mfa, l.RefreshHash, l.UserAgent, l.Ip, l.CreatedAt, l.LastSeenAt, l.ExpireAt)
+	return err
+}
+
+// GetLoginSession 返回登录会话，不存在时返回 nil
+func (s *DbUtils) GetLoginSession(id string) (*LoginSession, error) {
+	l, err := scanLoginSession(s.SqlDB.QueryRow("SELECT "+loginSessionColumns+" FROM login_sessions WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return l, err
+}
+
+// RotateRefreshToken 校验并轮换 refresh token，oldHash 与当前值不一致、会话已退出或已过期时返回 false
+func (s *DbUtils) RotateRefreshToken(id, oldHash, newHash string, expireAt int64, now time.Time) (bool, error) {
+	res, err := s.SqlDB.Exec(`UPDATE login_sessions SET refresh_hash = ?, expire_at = ?, last_seen_at = ?
+		WHERE id = ? AND refresh_hash = ? AND revoked_at = 0 AND expire_at > ?`,
+		newHash, expireAt, now.Unix(), id, oldHash, now.Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ListLoginSessions 返回账号在 now 时仍有效的登录会话，最近使用的在前
+func (s *DbUtils) ListLoginSessions(accountId int, now time.Time) ([]*LoginSession, error) {
+	rows, err := s.SqlDB.Query("SELECT "+loginSessionColumns+" FROM login_sessions WHERE account_id = ? AND revoked_at = 0 AND expire_at > ? ORDER BY last_seen_at DESC",
+		accountId, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]*LoginSession, 0)
+	for rows.Next() {
+		l, err := scanLoginSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	return list, rows.Err()
+}
+
+// RevokeLoginSession 退出账号的一个登录会话，该会话的 access token 与 refresh token 立即失效
+func (s *DbUtils) RevokeLoginSession(accountId int, id string) error {
+	res, err := s.SqlDB.Exec("UPDATE login_sessions SET revoked_at = ? WHERE id = ? AND account_id = ? AND revoked_at = 0",
+		time.Now().Unix(), id, accountId)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("session not found or already revoked")
+	}
+	return nil
+}
+
+// RevokeAccountSessions 退出账号的所有登录会话，返回退出的会话数
+func (s *DbUtils) RevokeAccountSessions(accountId int) (int64, error) {
+	res, err := s.SqlDB.Exec("UPDATE login_sessions SET revoked_at = ? WHERE account_id = ? AND revoked_at = 0",
+		time.Now().Unix(), accountId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteExpiredLoginSessions 删除 before 之前已过期或已退出的会话
+func (s *DbUtils) DeleteExpiredLoginSessions(before time.Time) (int64, error) {
+	res, err := s.SqlDB.Exec("DELETE FROM login_sessions WHERE expire_at < ? OR (revoked_at > 0 AND revoked_at < ?)",
+		before.Unix(), before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,61 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginSessionStore(t *testing.T) {
+	db := newTestSqliteDb(t)
+	now := time.Now()
+	id := newTestAccount(t, db, "user")
+	l := &LoginSession{Id: "s1", AccountId: id, Username: "user", Role: RoleOwner, RefreshHash: "r1", ExpireAt: now.Add(time.Hour).Unix()}
+	if err := db.CreateLoginSession(l); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateLoginSession(&LoginSession{Id: "s2", AccountId: id, RefreshHash: "r2", ExpireAt: now.Add(time.Hour).Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateLoginSession(&LoginSession{Id: "old", AccountId: id, RefreshHash: "r3", ExpireAt: now.Add(-time.Hour).Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetLoginSession("missing"); got != nil || err != nil {
+		t.Fatalf("GetLoginSession missing %+v %v", got, err)
+	}
+	if got, err := db.GetLoginSession("s1"); err != nil || got.Username != "user" || got.Role != RoleOwner || !got.Active(now) {
+		t.Fatalf("GetLoginSession %+v %v", got, err)
+	}
+	if list, _ := db.ListLoginSessions(id, now); len(list) != 2 {
+		t.Fatalf("ListLoginSessions %d", len(list))
+	}
+
+	exp := now.Add(2 * time.Hour).Unix()
+	if ok, err := db.RotateRefreshToken("s1", "r1", "r1b", exp, now); err != nil || !ok {
+		t.Fatalf("RotateRefreshToken %v %v", ok, err)
+	}
+	if ok, _ := db.RotateRefreshToken("s1", "r1", "r1c", exp, now); ok {
+		t.Fatal("reused refresh token accepted")
+	}
+	if ok, _ := db.RotateRefreshToken("old", "r3", "r3b", exp, now); ok {
+		t.Fatal("expired session refreshed")
+	}
+
+	if err := db.RevokeLoginSession(id+1, "s1"); err == nil {
+		t.Fatal("revoked another account's session")
+	}
+	if err := db.RevokeLoginSession(id, "s1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := db.RotateRefreshToken("s1", "r1b", "r1d", exp, now); ok {
+		t.Fatal("revoked session refreshed")
+	}
+	if n, err := db.RevokeAccountSessions(id); err != nil || n != 2 {
+		t.Fatalf("RevokeAccountSessions %d %v", n, err)
+	}
+	if list, _ := db.ListLoginSessions(id, now); len(list) != 0 {
+		t.Fatalf("ListLoginSessions after revoke %d", len(list))
+	}
+	if n, err := db.DeleteExpiredLoginSessions(now.Add(time.Minute)); err != nil || n != 3 {
+		t.Fatalf("DeleteExpiredLoginSessions %d %v", n, err)
+	}
+}
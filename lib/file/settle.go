@@ -0,0 +1,389 @@
+package file
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 订单状态
+const (
+	OrderPending  = "pending"
+	OrderPaid     = "paid"
+	OrderFailed   = "failed"
+	OrderRefunded = "refunded"
+)
+
+// ErrOrderSettled 订单已结算，重复的支付回调返回该错误
+var ErrOrderSettled = errors.New("order already settled")
+
+// ErrOrderNotPaid 只有已支付的订单可以退款
+var ErrOrderNotPaid = errors.New("only paid orders can be refunded")
+
+// ErrCouponLimit 结算时优惠码已达到使用次数上限或账号已经使用过，订单不会入账
+var ErrCouponLimit = errors.New("coupon use limit reached")
+
+// OrderCredit 订单的入账记录，Flow 为充值到流量余额的部分，Allowance 为套餐设置的每月额度，单位均为 KB
+type OrderCredit struct {
+	OrderId    int64   `json:"order_id"`
+	AccountId  int     `json:"account_id"`
+	Flow       float64 `json:"flow"`
+	Allowance  float64 `json:"allowance"`
+	Months     int     `json:"months"`
+	PlanId     int     `json:"plan_id"`
+	CreatedAt  int64   `json:"created_at"`
+	RefundedAt int64   `json:"refunded_at"` // 退款撤销入账的时间，0 表示未退款
+}
+
+// OrderMismatch 订单状态与入账记录不一致，Credit 为 nil 表示未入账
+type OrderMismatch struct {
+	Order  *Order       `json:"order"`
+	Credit *OrderCredit `json:"credit"`
+	Reason string       `json:"reason"`
+}
+
+// SettleStore 订单结算相关的存储操作
+type SettleStore interface {
+	SettleOrder(externalId string) (*Order, error)
+	RefundOrder(externalId string, refund func(o *Order) error) (*Order, error)
+	GetOrderCredit(orderId int64) (*OrderCredit, error)
+	GetOrdersByStatus(status string, before int64) ([]*Order, error)
+	GetOrderList(start, length int, f OrderFilter, sortField, order string) ([]*Order, int, error)
+	ReconcileOrders() ([]*OrderMismatch, error)
+}
+
+// OrderFilter 订单列表的筛选条件，零值表示不限制
+type OrderFilter struct {
+	AccountId   int
+	Status      string
+	PaymentType string
+	Search      string // 按外部交易号模糊匹配
+	From, To    int64  // 创建时间范围 [From, To)，unix 时间戳
+}
+
+// expectedCredit 订单支付后应充值到流量余额的流量(KB)、套餐的每月额度(KB)与月数，
+// 套餐的流量为每月额度，流量包与优惠码赠送的流量充值到流量余额
+func (o *Order) expectedCredit() (float64, float64, int) {
+	bonus := o.BonusFlow * 1024 * 1024
+	switch {
+	case o.PlanId > 0:
+		return bonus, o.Flow * 1024 * 1024, o.Months
+	case o.PaymentType == "traffic":
+		return o.Flow*1024*1024 + bonus, 0, 0
+	}
+	return bonus, 0, o.Months
+}
+
+const orderColumns = `order_id, app_id, order_amount, flow, months, order_status,
+	payment_type, external_transaction_id, created_at, account_id, plan_id, coupon_code, discount, bonus_flow`
+
+func scanOrder(row interface{ Scan(...interface{}) error }) (*Order, error) {
+	o := new(Order)
+	err := row.Scan(&o.OrderId, &o.AppId, &o.OrderAmount, &o.Flow, &o.Months, &o.OrderStatus,
+		&o.PaymentType, &o.ExternalTransactionId, &o.CreatedAt, &o.AccountId, &o.PlanId, &o.CouponCode, &o.Discount, &o.BonusFlow)
+	return o, err
+}
+
+// SettleOrder 在同一事务中把订单标记为已支付、给账号入账并写入入账记录，
+// 外部交易号为幂等键，订单已支付或已退款时返回 ErrOrderSettled 且不会重复入账
+func (s *DbUtils) SettleOrder(externalId string) (*Order, error) {
+	tx, err := s.SqlDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	o, err := scanOrder(tx.QueryRow("SELECT "+orderColumns+" FROM orders WHERE external_transaction_id = ?", externalId))
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec("UPDATE orders SET order_status = ? WHERE order_id = ? AND order_status NOT IN (?, ?)",
+		OrderPaid, o.OrderId, OrderPaid, OrderRefunded)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return o, ErrOrderSettled
+	}
+	accountId, err := strconv.Atoi(o.AccountId)
+	if err != nil {
+		return nil, fmt.Errorf("order %s has invalid account %q", externalId, o.AccountId)
+	}
+	if o.CouponCode != "" {
+		if err := s.useCouponTx(tx, o); err != nil {
+			return o, err
+		}
+	}
+	flow, allowance, months := o.expectedCredit()
+	var prevRate, prevClients, prevTunnels, prevPlan, prevResetDay int
+	var prevAllowance float64
+	if o.PlanId > 0 {
+		// 记录账号原有的限制与额度，退款时恢复
+		if err := tx.QueryRow("SELECT rate_limit, max_clients, max_tunnels, plan_id, allowance, reset_day FROM accounts WHERE id = ?", accountId).
+			Scan(&prevRate, &prevClients, &prevTunnels, &prevPlan, &prevAllowance, &prevResetDay); err != nil {
+			return nil, fmt.Errorf("credit order %s: account %d: %v", externalId, accountId, err)
+		}
+		// 套餐按下单时的流量与周期入账，带宽与数量上限取套餐当前配置
+		p, err := scanPlan(tx.QueryRow("SELECT "+planColumns+" FROM plans WHERE id = ?", o.PlanId))
+		if err == sql.ErrNoRows {
+			return nil, ErrPlanNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		p.Flow, p.Months = o.Flow, o.Months
+		if err := s.applyPlanTx(tx, accountId, p); err != nil {
+			return nil, err
+		}
+	} else if months > 0 {
+		if _, err := tx.Exec(s.addMonthsSql(), months, accountId); err != nil {
+			return nil, err
+		}
+	}
+	if flow > 0 {
+		if _, err := tx.Exec("UPDATE accounts SET flow = (CASE WHEN flow IS NULL OR flow < 0 THEN 0 ELSE flow END) + ? WHERE id = ?", flow, accountId); err != nil {
+			return nil, err
+		}
+	}
+	// 入账记录以 order_id 为主键，并发的重复结算会在此处失败并回滚
+	if _, err := tx.Exec(`INSERT INTO order_credits (order_id, account_id, flow, allowance, months, plan_id, created_at,
+		prev_rate_limit, prev_max_clients, prev_max_tunnels, prev_plan_id, prev_allowance, prev_reset_day) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		o.OrderId, accountId, flow, allowance, months, o.PlanId, time.Now().Unix(),
+		prevRate, prevClients, prevTunnels, prevPlan, prevAllowance, prevResetDay); err != nil {
+		return nil, fmt.Errorf("credit order %s: %v", externalId, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	o.OrderStatus = OrderPaid
+	return o, nil
+}
+
+// useCouponTx 在结算事务中记录优惠码的使用，下单时的校验不包括未支付的订单，
+// 同一账号的多个未支付订单或多个账号同时使用同一优惠码时，以结算为准，超出限制时返回 ErrCouponLimit，
+// 优惠码已被删除时保留订单的优惠内容
+func (s *DbUtils) useCouponTx(tx *sql.Tx, o *Order) error {
+	res, err := tx.Exec(s.insertIgnoreSql()+"coupon_uses (code, account_id, order_id, created_at) VALUES (?, ?, ?, ?)",
+		o.CouponCode, o.AccountId, o.OrderId, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrCouponLimit
+	}
+	if res, err = tx.Exec("UPDATE coupons SET used = used + 1 WHERE code = ? AND (max_uses = 0 OR used < max_uses)", o.CouponCode); err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM coupons WHERE code = ?", o.CouponCode).Scan(&exists); err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrCouponLimit
+		}
+	}
+	return nil
+}
+
+// RefundOrder 在同一事务中把已支付的订单标记为已退款并撤销入账：扣回流量（不足时扣到 0）与月数，
+// 账号仍为该套餐时恢复购买前的带宽与数量上限；refund 不为 nil 时在提交前调用，返回错误则整个退款回滚
+func (s *DbUtils) RefundOrder(externalId string, refund func(o *Order) error) (*Order, error) {
+	tx, err := s.SqlDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	o, err := scanOrder(tx.QueryRow("SELECT "+orderColumns+" FROM orders WHERE external_transaction_id = ?", externalId))
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec("UPDATE orders SET order_status = ? WHERE order_id = ? AND order_status = ?", OrderRefunded, o.OrderId, OrderPaid)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return o, ErrOrderNotPaid
+	}
+	c := &OrderCredit{OrderId: o.OrderId}
+	var prevRate, prevClients, prevTunnels, prevPlan, prevResetDay int
+	var prevAllowance float64
+	err = tx.QueryRow(`SELECT account_id, flow, allowance, months, plan_id, created_at, refunded_at,
+		prev_rate_limit, prev_max_clients, prev_max_tunnels, prev_plan_id, prev_allowance, prev_reset_day FROM order_credits WHERE order_id = ?`, o.OrderId).
+		Scan(&c.AccountId, &c.Flow, &c.Allowance, &c.Months, &c.PlanId, &c.CreatedAt, &c.RefundedAt,
+			&prevRate, &prevClients, &prevTunnels, &prevPlan, &prevAllowance, &prevResetDay)
+	if err == sql.ErrNoRows || c.RefundedAt != 0 {
+		return nil, fmt.Errorf("refund order %s: no credit to reverse, run nps reconcile", externalId)
+	} else if err != nil {
+		return nil, err
+	}
+	if c.Flow > 0 {
+		if _, err := tx.Exec("UPDATE accounts SET flow = CASE WHEN flow IS NULL OR flow < ? THEN 0 ELSE flow - ? END WHERE id = ?",
+			c.Flow, c.Flow, c.AccountId); err != nil {
+			return nil, err
+		}
+	}
+	if c.Months > 0 {
+		if _, err := tx.Exec(s.subMonthsSql(), c.Months, c.AccountId); err != nil {
+			return nil, err
+		}
+	}
+	if c.PlanId > 0 {
+		if _, err := tx.Exec("UPDATE accounts SET rate_limit = ?, max_clients = ?, max_tunnels = ?, plan_id = ? WHERE id = ? AND plan_id = ?",
+			prevRate, prevClients, prevTunnels, prevPlan, c.AccountId, c.PlanId); err != nil {
+			return nil, err
+		}
+	}
+	if c.Allowance > 0 {
+		// 恢复购买前的每月额度，本周期剩余额度不超过原额度
+		if _, err := tx.Exec(`UPDATE accounts SET allowance = ?, reset_day = ?,
+			allowance_left = CASE WHEN allowance_left > ? THEN ? ELSE allowance_left END WHERE id = ? AND allowance = ?`,
+			prevAllowance, prevResetDay, prevAllowance, prevAllowance, c.AccountId, c.Allowance); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec("UPDATE order_credits SET refunded_at = ? WHERE order_id = ?", time.Now().Unix(), o.OrderId); err != nil {
+		return nil, err
+	}
+	o.OrderStatus = OrderRefunded
+	if refund != nil {
+		if err := refund(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, tx.Commit()
+}
+
+// GetOrderCredit 返回订单的入账记录，未入账时返回 sql.ErrNoRows
+func (s *DbUtils) GetOrderCredit(orderId int64) (*OrderCredit, error) {
+	c := &OrderCredit{OrderId: orderId}
+	err := s.SqlDB.QueryRow("SELECT account_id, flow, allowance, months, plan_id, created_at, refunded_at FROM order_credits WHERE order_id = ?", orderId).
+		Scan(&c.AccountId, &c.Flow, &c.Allowance, &c.Months, &c.PlanId, &c.CreatedAt, &c.RefundedAt)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 订单列表允许排序的字段
+var orderSortFields = map[string]string{
+	"order_id":     "order_id",
+	"created_at":   "created_at",
+	"order_amount": "order_amount",
+}
+
+// GetOrderList 分页查询订单，默认按下单时间倒序
+func (s *DbUtils) GetOrderList(start, length int, f OrderFilter, sortField, order string) ([]*Order, int, error) {
+	q := NewQuery("orders")
+	if f.AccountId > 0 {
+		q.Where("account_id = ?", strconv.Itoa(f.AccountId))
+	}
+	if f.Status != "" {
+		q.Where("order_status = ?", f.Status)
+	}
+	if f.PaymentType != "" {
+		q.Where("payment_type = ?", f.PaymentType)
+	}
+	if f.From > 0 {
+		q.Where("created_at >= ?", f.From)
+	}
+	if f.To > 0 {
+		q.Where("created_at < ?", f.To)
+	}
+	q.Search(f.Search, nil, []string{"external_transaction_id"})
+	if sortField == "" {
+		sortField, order = "order_id", "desc"
+	}
+	q.OrderBy(sortField, order, orderSortFields, "order_id").Page(start, length)
+	countQuery, countArgs := q.CountSql()
+	var cnt int
+	if err := s.SqlDB.QueryRow(countQuery, countArgs...).Scan(&cnt); err != nil {
+		return nil, 0, err
+	}
+	query, args := q.SelectSql(orderColumns)
+	rows, err := s.SqlDB.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	list := make([]*Order, 0)
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, o)
+	}
+	return list, cnt, rows.Err()
+}
+
+// GetOrdersByStatus 返回指定状态且创建时间早于 before 的订单，before 为 0 时不限制
+func (s *DbUtils) GetOrdersByStatus(status string, before int64) ([]*Order, error) {
+	query := "SELECT " + orderColumns + " FROM orders WHERE order_status = ?"
+	args := []interface{}{status}
+	if before > 0 {
+		query += " AND created_at < ?"
+		args = append(args, before)
+	}
+	rows, err := s.SqlDB.Query(query+" ORDER BY order_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+	}
+	return list, rows.Err()
+}
+
+// ReconcileOrders 对比订单状态与入账记录，返回已支付未入账、未支付已入账以及入账内容与订单不符的订单
+func (s *DbUtils) ReconcileOrders() ([]*OrderMismatch, error) {
+	rows, err := s.SqlDB.Query(`SELECT o.order_id, o.app_id, o.order_amount, o.flow, o.months, o.order_status,
+		o.payment_type, o.external_transaction_id, o.created_at, o.account_id, o.plan_id, o.coupon_code, o.discount, o.bonus_flow,
+		c.order_id, IFNULL(c.account_id, 0), IFNULL(c.flow, 0), IFNULL(c.allowance, 0), IFNULL(c.months, 0), IFNULL(c.plan_id, 0), IFNULL(c.created_at, 0), IFNULL(c.refunded_at, 0)
+		FROM orders o LEFT JOIN order_credits c ON c.order_id = o.order_id ORDER BY o.order_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*OrderMismatch
+	for rows.Next() {
+		o := new(Order)
+		c := new(OrderCredit)
+		var creditId sql.NullInt64
+		if err := rows.Scan(&o.OrderId, &o.AppId, &o.OrderAmount, &o.Flow, &o.Months, &o.OrderStatus,
+			&o.PaymentType, &o.ExternalTransactionId, &o.CreatedAt, &o.AccountId, &o.PlanId, &o.CouponCode, &o.Discount, &o.BonusFlow,
+			&creditId, &c.AccountId, &c.Flow, &c.Allowance, &c.Months, &c.PlanId, &c.CreatedAt, &c.RefundedAt); err != nil {
+			return nil, err
+		}
+		credited := o.OrderStatus == OrderPaid || o.OrderStatus == OrderRefunded
+		if !creditId.Valid {
+			if credited {
+				list = append(list, &OrderMismatch{Order: o, Reason: "order is " + o.OrderStatus + " but not credited"})
+			}
+			continue
+		}
+		c.OrderId = creditId.Int64
+		flow, allowance, months := o.expectedCredit()
+		if o.PlanId > 0 && c.Allowance == 0 {
+			// 升级前的套餐订单把套餐流量充值到流量余额
+			flow, allowance = flow+allowance, 0
+		}
+		switch {
+		case !credited:
+			list = append(list, &OrderMismatch{Order: o, Credit: c, Reason: "credited but order is " + o.OrderStatus})
+		case o.OrderStatus == OrderRefunded && c.RefundedAt == 0:
+			list = append(list, &OrderMismatch{Order: o, Credit: c, Reason: "order is refunded but credit not reversed"})
+		case o.OrderStatus == OrderPaid && c.RefundedAt != 0:
+			list = append(list, &OrderMismatch{Order: o, Credit: c, Reason: "credit reversed but order is paid"})
+		case strconv.Itoa(c.AccountId) != o.AccountId || c.Months != months || c.PlanId != o.PlanId || int64(c.Flow) != int64(flow) || int64(c.Allowance) != int64(allowance):
+			list = append(list, &OrderMismatch{Order: o, Credit: c, Reason: "credit does not match order"})
+		}
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,214 @@
+package file
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func newTestAccount(t *testing.T, db *SqliteDb, name string) int {
+	a := NewAccount()
+	a.WebUserName = name
+	if err := db.NewAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	u, err := db.GetByUsername(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Id
+}
+
+func TestSettleOrder(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "user")
+	order := &Order{AccountId: strconv.Itoa(id), Flow: 2, OrderAmount: 1.6, OrderStatus: OrderPending,
+		PaymentType: "traffic", ExternalTransactionId: "PAY1"}
+	if err := db.CreateOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateOrder(order); err == nil {
+		t.Fatal("duplicate external transaction id accepted")
+	}
+
+	// 并发的重复回调只入账一次
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	settled := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.SettleOrder("PAY1")
+			if err == nil {
+				mu.Lock()
+				settled++
+				mu.Unlock()
+			} else if err != ErrOrderSettled {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if settled != 1 {
+		t.Fatalf("order settled %d times", settled)
+	}
+	info, _ := db.GetAccountInfo(id)
+	if info.Flow.FlowLimit != 2*1024*1024 {
+		t.Fatalf("account flow %d", info.Flow.FlowLimit)
+	}
+	if o, _ := db.GetOrderByExternalId("PAY1"); o.OrderStatus != OrderPaid {
+		t.Fatalf("order status %s", o.OrderStatus)
+	}
+	if _, err := db.SettleOrder("PAY2"); err == nil {
+		t.Fatal("settled a missing order")
+	}
+
+	// 套餐不存在时整个结算回滚
+	bad := &Order{AccountId: strconv.Itoa(id), Flow: 5, Months: 1, OrderStatus: OrderPending,
+		PaymentType: "plan", PlanId: 100, ExternalTransactionId: "PAY3"}
+	if err := db.CreateOrder(bad); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SettleOrder("PAY3"); err != ErrPlanNotFound {
+		t.Fatalf("expected ErrPlanNotFound, got %v", err)
+	}
+	if o, _ := db.GetOrderByExternalId("PAY3"); o.OrderStatus != OrderPending {
+		t.Fatalf("order marked %s without credit", o.OrderStatus)
+	}
+	if list, err := db.GetOrdersByStatus(OrderPending, 0); err != nil || len(list) != 1 || list[0].ExternalTransactionId != "PAY3" {
+		t.Fatalf("pending orders %v %v", list, err)
+	}
+}
+
+func TestReconcileOrders(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "user")
+	for i, typ := range []string{"traffic", "monthly", "monthly", "traffic"} {
+		o := &Order{AccountId: strconv.Itoa(id), Flow: 1, Months: 1, OrderStatus: OrderPending,
+			PaymentType: typ, ExternalTransactionId: "PAY" + strconv.Itoa(i)}
+		if err := db.CreateOrder(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.SettleOrder("PAY0"); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := db.ReconcileOrders(); err != nil || len(list) != 0 {
+		t.Fatalf("unexpected mismatches %v %v", list, err)
+	}
+	// 模拟旧逻辑中标记已支付但未入账、入账后订单状态被改回以及入账内容与订单不符
+	db.SqlDB.Exec("UPDATE orders SET order_status = ? WHERE external_transaction_id = 'PAY1'", OrderPaid)
+	if _, err := db.SettleOrder("PAY2"); err != nil {
+		t.Fatal(err)
+	}
+	db.SqlDB.Exec("UPDATE orders SET order_status = ? WHERE external_transaction_id = 'PAY2'", OrderPending)
+	db.SqlDB.Exec("UPDATE order_credits SET flow = 1 WHERE order_id = (SELECT order_id FROM orders WHERE external_transaction_id = 'PAY0')")
+	list, err := db.ReconcileOrders()
+	if err != nil || len(list) != 3 {
+		t.Fatalf("mismatches %v %v", list, err)
+	}
+	want := map[string]bool{"PAY0": true, "PAY1": false, "PAY2": true}
+	for _, m := range list {
+		credited, ok := want[m.Order.ExternalTransactionId]
+		if !ok || credited != (m.Credit != nil) {
+			t.Fatalf("unexpected mismatch %s: %s", m.Order.ExternalTransactionId, m.Reason)
+		}
+	}
+}
+
+// 升级前的套餐订单把套餐流量充值到流量余额，对账时不视为不一致
+func TestReconcileLegacyPlanCredit(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "user")
+	o := &Order{AccountId: strconv.Itoa(id), Flow: 10, Months: 1, OrderStatus: OrderPaid, PaymentType: "plan", PlanId: 1, ExternalTransactionId: "PAY1"}
+	if err := db.CreateOrder(o); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SqlDB.Exec("INSERT INTO order_credits (order_id, account_id, flow, months, plan_id) VALUES (?, ?, ?, 1, 1)",
+		o.OrderId, id, 10*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := db.ReconcileOrders(); err != nil || len(list) != 0 {
+		t.Fatalf("unexpected mismatches %v %v", list, err)
+	}
+}
+
+func TestRefundOrder(t *testing.T) {
+	db := newTestSqliteDb(t)
+	id := newTestAccount(t, db, "user")
+	acc := strconv.Itoa(id)
+	basic := &Plan{Name: "basic", Flow: 10, RateLimit: 512, MaxClients: 2, MaxTunnels: 5, Months: 1, Price: 9.9, Status: true}
+	if err := db.SavePlan(basic); err != nil {
+		t.Fatal(err)
+	}
+	orders := []*Order{
+		{AccountId: acc, Flow: 2, PaymentType: "traffic", ExternalTransactionId: "PAY1", CreatedAt: 100},
+		{AccountId: acc, Flow: 10, Months: 1, PaymentType: "plan", PlanId: basic.Id, ExternalTransactionId: "PAY2", CreatedAt: 200},
+		{AccountId: acc, Months: 1, PaymentType: "monthly", ExternalTransactionId: "PAY3", CreatedAt: 300},
+	}
+	for _, o := range orders {
+		o.OrderStatus = OrderPending
+		if err := db.CreateOrder(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.RefundOrder("PAY1", nil); err != ErrOrderNotPaid {
+		t.Fatalf("refunded unpaid order: %v", err)
+	}
+	for _, o := range orders[:2] {
+		if _, err := db.SettleOrder(o.ExternalTransactionId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, _ := db.GetAccountInfo(id)
+	// 套餐的流量为每月额度，流量包充值到流量余额
+	if before.Allowance != 10*1024*1024 || before.AllowanceLeft != 10*1024*1024 || before.ResetDay == 0 || before.Flow.FlowLimit != 2*1024*1024 {
+		t.Fatalf("plan allowance not applied: %+v", before)
+	}
+
+	// 支付渠道退款失败时不撤销入账
+	if _, err := db.RefundOrder("PAY2", func(o *Order) error { return ErrOrderNotPaid }); err == nil {
+		t.Fatal("refund error ignored")
+	}
+	if info, _ := db.GetAccountInfo(id); info.PlanId != basic.Id || info.Flow.FlowLimit != before.Flow.FlowLimit {
+		t.Fatalf("credit reversed although refund failed: %+v", info)
+	}
+
+	if o, err := db.RefundOrder("PAY2", nil); err != nil || o.OrderStatus != OrderRefunded {
+		t.Fatalf("RefundOrder %v %v", o, err)
+	}
+	info, _ := db.GetAccountInfo(id)
+	if info.PlanId != 0 || info.RateLimit != 0 || info.MaxClientNum != 0 || info.Flow.FlowLimit != 2*1024*1024 || info.ExpireTime >= before.ExpireTime {
+		t.Fatalf("plan credit not reversed: %+v, before %+v", info, before)
+	}
+	if info.Allowance != 0 || info.AllowanceLeft != 0 || info.ResetDay != 0 {
+		t.Fatalf("plan allowance not reversed: %+v", info)
+	}
+	if _, err := db.RefundOrder("PAY2", nil); err != ErrOrderNotPaid {
+		t.Fatalf("refunded twice: %v", err)
+	}
+	if c, err := db.GetOrderCredit(orders[1].OrderId); err != nil || c.RefundedAt == 0 {
+		t.Fatalf("credit %+v %v", c, err)
+	}
+	if list, err := db.ReconcileOrders(); err != nil || len(list) != 0 {
+		t.Fatalf("unexpected mismatches %v %v", list, err)
+	}
+
+	list, cnt, err := db.GetOrderList(0, 10, OrderFilter{AccountId: id}, "", "")
+	if err != nil || cnt != 3 || list[0].ExternalTransactionId != "PAY3" {
+		t.Fatalf("GetOrderList %v %d %v", list, cnt, err)
+	}
+	if list, cnt, _ = db.GetOrderList(0, 10, OrderFilter{Status: OrderRefunded}, "", ""); cnt != 1 || list[0].ExternalTransactionId != "PAY2" {
+		t.Fatalf("status filter %v", list)
+	}
+	if _, cnt, _ = db.GetOrderList(0, 10, OrderFilter{From: 150, To: 300}, "", ""); cnt != 1 {
+		t.Fatalf("time filter returned %d orders", cnt)
+	}
+	if _, cnt, _ = db.GetOrderList(0, 10, OrderFilter{AccountId: id + 1}, "", ""); cnt != 0 {
+		t.Fatalf("other account sees %d orders", cnt)
+	}
+	if list, _, _ = db.GetOrderList(1, 1, OrderFilter{Search: "PAY"}, "created_at", "asc"); len(list) != 1 || list[0].ExternalTransactionId != "PAY2" {
+		t.Fatalf("paged list %v", list)
+	}
+}
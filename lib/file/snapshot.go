@@ -0,0 +1,628 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ehang.io/nps/lib/crypt"
+	"gopkg.in/yaml.v3"
+)
+
+// SnapshotVersion 配置文档的格式版本
+const SnapshotVersion = 1
+
+// Snapshot 描述 nps 实例全部配置的声明式文档，可以导出后应用到其他实例
+// 文档中的对象不包含 id，而是通过自然键匹配与引用：账号为用户名，客户端为验证密钥，
+// 隧道为端口（secret 与 p2p 模式为密钥），域名解析为 host、location 与 scheme
+type Snapshot struct {
+	Version     int                `json:"version" yaml:"version"`
+	Accounts    []*SnapshotAccount `json:"accounts" yaml:"accounts"`
+	Clients     []*SnapshotClient  `json:"clients" yaml:"clients"`
+	Tunnels     []*SnapshotTunnel  `json:"tunnels" yaml:"tunnels"`
+	Hosts       []*SnapshotHost    `json:"hosts" yaml:"hosts"`
+	BlackIpList []string           `json:"black_ip_list" yaml:"black_ip_list"` // 未设置时应用不修改全局黑名单
+}
+
+type SnapshotAccount struct {
+	UserName   string  `json:"user_name" yaml:"user_name"`
+	Password   string  `json:"password" yaml:"password"`
+	NickName   string  `json:"nick_name" yaml:"nick_name"`
+	HeadImgUrl string  `json:"head_img_url" yaml:"head_img_url"`
+	RateLimit  int     `json:"rate_limit" yaml:"rate_limit"`
+	Remark     string  `json:"remark" yaml:"remark"`
+	Status     bool    `json:"status" yaml:"status"`
+	Flow       float64 `json:"flow" yaml:"flow"`
+	ExpireTime string  `json:"expire_time" yaml:"expire_time"`
+	Allowance  float64 `json:"allowance" yaml:"allowance"` // 每月流量额度(KB)
+	ResetDay   int     `json:"reset_day" yaml:"reset_day"` // 每月重置额度的日期，0 表示不重置
+}
+
+type SnapshotClient struct {
+	VerifyKey   string `json:"verify_key" yaml:"verify_key"`
+	Account     string `json:"account" yaml:"account"` // 所属账号的用户名
+	Remark      string `json:"remark" yaml:"remark"`
+	RateLimit   int    `json:"rate_limit" yaml:"rate_limit"`
+	WebUserName string `json:"web_user_name" yaml:"web_user_name"`
+}
+
+type SnapshotTunnel struct {
+	Client     string `json:"client" yaml:"client"` // 所属客户端的验证密钥
+	Mode       string `json:"mode" yaml:"mode"`
+	Port       int    `json:"port" yaml:"port"`
+	ServerIp   string `json:"server_ip" yaml:"server_ip"`
+	Password   string `json:"password" yaml:"password"`
+	Remark     string `json:"remark" yaml:"remark"`
+	Target     string `json:"target" yaml:"target"`
+	TargetAddr string `json:"target_addr" yaml:"target_addr"`
+	LocalPath  string `json:"local_path" yaml:"local_path"`
+	StripPre   string `json:"strip_pre" yaml:"strip_pre"`
+}
+
+type SnapshotHost struct {
+	Client    string `json:"client" yaml:"client"` // 所属客户端的验证密钥
+	Host      string `json:"host" yaml:"host"`
+	Location  string `json:"location" yaml:"location"`
+	Scheme    string `json:"scheme" yaml:"scheme"`
+	Remark    string `json:"remark" yaml:"remark"`
+	Target    string `json:"target" yaml:"target"`
+	IsClose   bool   `json:"is_close" yaml:"is_close"`
+	AutoHttps bool   `json:"auto_https" yaml:"auto_https"`
+}
+
+func (t *SnapshotTunnel) key() string {
+	if t.Mode == "secret" || t.Mode == "p2p" {
+		return t.Mode + " " + t.Password
+	}
+	return "port " + strconv.Itoa(t.Port)
+}
+
+func (h *SnapshotHost) key() string {
+	return h.Host + h.Location + " (" + h.Scheme + ")"
+}
+
+// 配置变更的类型
+const (
+	SnapshotCreate   = "create"
+	SnapshotUpdate   = "update"
+	SnapshotConflict = "conflict"
+)
+
+// SnapshotChange 应用配置文档时对单个对象的变更
+type SnapshotChange struct {
+	Kind   string   `json:"kind"` // account client tunnel host global
+	Key    string   `json:"key"`
+	Action string   `json:"action"`
+	Fields []string `json:"fields,omitempty"` // 变更的字段，格式为 name: old -> new
+	Reason string   `json:"reason,omitempty"`
+	Id     int      `json:"id,omitempty"` // 隧道对应的记录 id，用于应用后重启隧道
+}
+
+// SnapshotPlan 应用配置文档的变更计划，DryRun 为 true 时只是预览
+type SnapshotPlan struct {
+	DryRun    bool              `json:"dry_run"`
+	Changes   []*SnapshotChange `json:"changes"`
+	Unchanged int               `json:"unchanged"`
+}
+
+func (p *SnapshotPlan) add(kind, key, action string, fields []string, reason string) *SnapshotChange {
+	c := &SnapshotChange{Kind: kind, Key: key, Action: action, Fields: fields, Reason: reason}
+	p.Changes = append(p.Changes, c)
+	return c
+}
+
+// Conflicts 返回冲突的数量
+func (p *SnapshotPlan) Conflicts() int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == SnapshotConflict {
+			n++
+		}
+	}
+	return n
+}
+
+// String 以 diff 的形式输出变更计划，+ 为新增，~ 为修改，! 为冲突
+func (p *SnapshotPlan) String() string {
+	var b strings.Builder
+	for _, c := range p.Changes {
+		switch c.Action {
+		case SnapshotCreate:
+			fmt.Fprintf(&b, "+ %s %s\n", c.Kind, c.Key)
+		case SnapshotUpdate:
+			fmt.Fprintf(&b, "~ %s %s\n", c.Kind, c.Key)
+			for _, f := range c.Fields {
+				fmt.Fprintf(&b, "    %s\n", f)
+			}
+		default:
+			fmt.Fprintf(&b, "! %s %s: %s\n", c.Kind, c.Key, c.Reason)
+		}
+	}
+	fmt.Fprintf(&b, "%d changes, %d conflicts, %d unchanged\n", len(p.Changes)-p.Conflicts(), p.Conflicts(), p.Unchanged)
+	return b.String()
+}
+
+// ParseSnapshot 解析 JSON 或 YAML 格式的配置文档
+func ParseSnapshot(data []byte) (*Snapshot, error) {
+	snap := new(Snapshot)
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		err = json.Unmarshal(data, snap)
+	} else {
+		err = yaml.Unmarshal(data, snap)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if snap.Version > SnapshotVersion {
+		return nil, fmt.Errorf("unsupported config version %d", snap.Version)
+	}
+	return snap, nil
+}
+
+// Marshal 将配置文档编码为 format 指定的格式，支持 json 与 yaml
+func (s *Snapshot) Marshal(format string) ([]byte, error) {
+	switch format {
+	case "yaml", "yml":
+		return yaml.Marshal(s)
+	case "json":
+		return json.MarshalIndent(s, "", "  ")
+	}
+	return nil, fmt.Errorf("unknown config format %s", format)
+}
+
+func accountToSnapshot(a *Account) *SnapshotAccount {
+	return &SnapshotAccount{
+		UserName:   a.WebUserName,
+		Password:   a.WebPassword,
+		NickName:   a.NickName,
+		HeadImgUrl: a.HeadImgUrl,
+		RateLimit:  a.RateLimit,
+		Remark:     a.Remark,
+		Status:     a.Status,
+		Flow:       float64(a.Flow.FlowLimit),
+		ExpireTime: a.ExpireTime,
+		Allowance:  float64(a.Allowance),
+		ResetDay:   a.ResetDay,
+	}
+}
+
+func clientToSnapshot(c *Client, accountNames map[int]string) *SnapshotClient {
+	return &SnapshotClient{
+		VerifyKey:   c.VerifyKey,
+		Account:     accountNames[c.AccountId],
+		Remark:      c.Remark,
+		RateLimit:   c.RateLimit,
+		WebUserName: c.WebUserName,
+	}
+}
+
+func tunnelToSnapshot(t *Tunnel, vkey string) *SnapshotTunnel {
+	return &SnapshotTunnel{
+		Client:     vkey,
+		Mode:       t.Mode,
+		Port:       t.Port,
+		ServerIp:   t.ServerIp,
+		Password:   t.Password,
+		Remark:     t.Remark,
+		Target:     t.Target.TargetStr,
+		TargetAddr: t.TargetAddr,
+		LocalPath:  t.LocalPath,
+		StripPre:   t.StripPre,
+	}
+}
+
+func hostToSnapshot(h *Host, vkey string) *SnapshotHost {
+	return &SnapshotHost{
+		Client:    vkey,
+		Host:      h.Host,
+		Location:  h.Location,
+		Scheme:    h.Scheme,
+		Remark:    h.Remark,
+		Target:    h.Target.TargetStr,
+		IsClose:   h.IsClose,
+		AutoHttps: h.AutoHttps,
+	}
+}
+
+// snapshotState 存储中现有对象按自然键建立的索引
+type snapshotState struct {
+	accounts     map[string]*Account
+	accountNames map[int]string
+	clients      map[string]*Client
+	clientKeys   map[int]string
+	tunnels      map[string]*Tunnel
+	hosts        map[string]*Host
+}
+
+func loadSnapshotState(store Store) (*snapshotState, error) {
+	st := &snapshotState{
+		accounts:     make(map[string]*Account),
+		accountNames: make(map[int]string),
+		clients:      make(map[string]*Client),
+		clientKeys:   make(map[int]string),
+		tunnels:      make(map[string]*Tunnel),
+		hosts:        make(map[string]*Host),
+	}
+	accounts, err := store.GetAllAccounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		st.accounts[a.WebUserName] = a
+		st.accountNames[a.Id] = a.WebUserName
+	}
+	clients, err := store.GetAllClients()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range clients {
+		st.clients[c.VerifyKey] = c
+		st.clientKeys[c.Id] = c.VerifyKey
+	}
+	// 隧道与域名解析保存在同一张表中，mode 为空的记录是域名解析
+	tasks, err := store.GetAllTasks()
+	if err != nil {
+		return nil, err
+	}
+	tunnelIds := make(map[int]bool)
+	for _, t := range tasks {
+		if t.Mode == "" {
+			continue
+		}
+		tunnelIds[t.Id] = true
+		st.tunnels[tunnelToSnapshot(t, "").key()] = t
+	}
+	hosts, err := store.GetAllHosts()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range hosts {
+		if tunnelIds[v.Id] {
+			continue
+		}
+		h, err := store.GetHostById(v.Id)
+		if err != nil {
+			return nil, err
+		}
+		st.hosts[hostToSnapshot(h, "").key()] = h
+	}
+	return st, nil
+}
+
+// ExportSnapshot 导出 store 中的账号、客户端、隧道、域名解析与全局黑名单
+// 所属客户端已被删除或禁用的隧道与域名解析无法通过验证密钥引用，不会被导出
+func ExportSnapshot(store Store) (*Snapshot, error) {
+	st, err := loadSnapshotState(store)
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{Version: SnapshotVersion, BlackIpList: []string{}}
+	for _, a := range st.accounts {
+		snap.Accounts = append(snap.Accounts, accountToSnapshot(a))
+	}
+	for _, c := range st.clients {
+		snap.Clients = append(snap.Clients, clientToSnapshot(c, st.accountNames))
+	}
+	for _, t := range st.tunnels {
+		if vkey, ok := st.clientKeys[t.ClientId]; ok {
+			snap.Tunnels = append(snap.Tunnels, tunnelToSnapshot(t, vkey))
+		}
+	}
+	for _, h := range st.hosts {
+		if vkey, ok := st.clientKeys[h.Client.Id]; ok {
+			snap.Hosts = append(snap.Hosts, hostToSnapshot(h, vkey))
+		}
+	}
+	if global := store.GetGlobal(); global != nil && global.BlackIpList != nil {
+		snap.BlackIpList = global.BlackIpList
+	}
+	sort.Slice(snap.Accounts, func(i, j int) bool { return snap.Accounts[i].UserName < snap.Accounts[j].UserName })
+	sort.Slice(snap.Clients, func(i, j int) bool { return snap.Clients[i].VerifyKey < snap.Clients[j].VerifyKey })
+	sort.Slice(snap.Tunnels, func(i, j int) bool { return snap.Tunnels[i].key() < snap.Tunnels[j].key() })
+	sort.Slice(snap.Hosts, func(i, j int) bool { return snap.Hosts[i].key() < snap.Hosts[j].key() })
+	return snap, nil
+}
+
+// ApplySnapshot 将配置文档应用到 store，按自然键新增或修改对象，文档中没有的对象保持不变，
+// 因此重复应用同一文档不会产生变更。与其他客户端或账号的对象冲突时记录冲突并跳过。
+// dryRun 为 true 时只计算变更计划，不写入任何数据
+func ApplySnapshot(store Store, snap *Snapshot, dryRun bool) (*SnapshotPlan, error) {
+	st, err := loadSnapshotState(store)
+	if err != nil {
+		return nil, err
+	}
+	plan := &SnapshotPlan{DryRun: dryRun}
+
+	if snap.BlackIpList != nil {
+		var old []string
+		if global := store.GetGlobal(); global != nil {
+			old = global.BlackIpList
+		}
+		if strings.Join(old, ",") == strings.Join(snap.BlackIpList, ",") {
+			plan.Unchanged++
+		} else {
+			plan.add("global", "black_ip_list", SnapshotUpdate, []string{
+				fmt.Sprintf("black_ip_list: [%s] -> [%s]", strings.Join(old, ","), strings.Join(snap.BlackIpList, ",")),
+			}, "")
+			if !dryRun {
+				global := store.GetGlobal()
+				global.BlackIpList = snap.BlackIpList
+				if err := store.SaveGlobal(global); err != nil {
+					return plan, err
+				}
+			}
+		}
+	}
+
+	accountIds := make(map[string]int) // 文档中的账号，dryRun 时新增账号的 id 为 0
+	for _, sa := range snap.Accounts {
+		if sa.UserName == "" {
+			plan.add("account", "", SnapshotConflict, nil, "user_name is empty")
+			continue
+		}
+		if _, ok := accountIds[sa.UserName]; ok {
+			plan.add("account", sa.UserName, SnapshotConflict, nil, "duplicate account in document")
+			continue
+		}
+		a, ok := st.accounts[sa.UserName]
+		if !ok {
+			a = NewAccount()
+		}
+		cur := accountToSnapshot(a)
+		// 数据库中保存的是密码哈希，文档中的明文密码与之匹配时视为未修改，保留原有哈希
+		samePassword := cur.Password != sa.Password && !crypt.IsPasswordHash(sa.Password)
+		if samePassword {
+			samePassword, _ = crypt.CheckPassword(cur.Password, sa.Password)
+		}
+		if samePassword {
+			cur.Password = sa.Password
+		}
+		fields := diffSnapshot(cur, sa)
+		if ok && len(fields) == 0 {
+			accountIds[sa.UserName] = a.Id
+			plan.Unchanged++
+			continue
+		}
+		a.WebUserName, a.NickName, a.HeadImgUrl = sa.UserName, sa.NickName, sa.HeadImgUrl
+		if !samePassword {
+			a.WebPassword = sa.Password
+		}
+		a.RateLimit, a.Remark, a.Status, a.ExpireTime = sa.RateLimit, sa.Remark, sa.Status, sa.ExpireTime
+		a.Flow.FlowLimit = int64(sa.Flow)
+		a.Allowance, a.ResetDay = int64(sa.Allowance), sa.ResetDay
+		if ok {
+			plan.add("account", sa.UserName, SnapshotUpdate, fields, "")
+		} else {
+			plan.add("account", sa.UserName, SnapshotCreate, nil, "")
+		}
+		if !dryRun {
+			if !ok {
+				if err := store.NewAccount(a); err != nil {
+					return plan, fmt.Errorf("account %s: %v", sa.UserName, err)
+				}
+				created, err := store.GetByUsername(sa.UserName)
+				if err != nil {
+					return plan, fmt.Errorf("account %s: %v", sa.UserName, err)
+				}
+				a.Id = created.Id
+			}
+			if err := store.UpdateAccount(a); err != nil {
+				return plan, fmt.Errorf("account %s: %v", sa.UserName, err)
+			}
+		}
+		accountIds[sa.UserName] = a.Id
+	}
+	resolveAccount := func(name string) (int, bool) {
+		if name == "" {
+			return 0, true
+		}
+		if id, ok := accountIds[name]; ok {
+			return id, true
+		}
+		if a, ok := st.accounts[name]; ok {
+			return a.Id, true
+		}
+		return 0, false
+	}
+
+	clients := make(map[string]*Client) // 文档中的客户端
+	for _, sc := range snap.Clients {
+		if sc.VerifyKey == "" {
+			plan.add("client", "", SnapshotConflict, nil, "verify_key is empty")
+			continue
+		}
+		if _, ok := clients[sc.VerifyKey]; ok {
+			plan.add("client", sc.VerifyKey, SnapshotConflict, nil, "duplicate client in document")
+			continue
+		}
+		accountId, ok := resolveAccount(sc.Account)
+		if !ok {
+			plan.add("client", sc.VerifyKey, SnapshotConflict, nil, "account "+sc.Account+" does not exist")
+			continue
+		}
+		c, ok := st.clients[sc.VerifyKey]
+		if ok {
+			if c.AccountId != accountId {
+				plan.add("client", sc.VerifyKey, SnapshotConflict, nil, "the client belongs to account "+st.accountNames[c.AccountId])
+				continue
+			}
+			fields := diffSnapshot(clientToSnapshot(c, st.accountNames), sc)
+			clients[sc.VerifyKey] = c
+			if len(fields) == 0 {
+				plan.Unchanged++
+				continue
+			}
+			plan.add("client", sc.VerifyKey, SnapshotUpdate, fields, "")
+		} else {
+			c = NewClient(sc.VerifyKey, false, false)
+			c.AccountId = accountId
+			clients[sc.VerifyKey] = c
+			plan.add("client", sc.VerifyKey, SnapshotCreate, nil, "")
+		}
+		c.Remark, c.RateLimit, c.WebUserName = sc.Remark, sc.RateLimit, sc.WebUserName
+		if dryRun {
+			continue
+		}
+		if !ok {
+			c.Id = store.GetNewClientId()
+			if err := store.NewClient(c); err != nil {
+				return plan, fmt.Errorf("client %s: %v", sc.VerifyKey, err)
+			}
+		}
+		if err := store.UpdateClient(c); err != nil {
+			return plan, fmt.Errorf("client %s: %v", sc.VerifyKey, err)
+		}
+	}
+	resolveClient := func(vkey string) (*Client, bool) {
+		if c, ok := clients[vkey]; ok {
+			return c, true
+		}
+		c, ok := st.clients[vkey]
+		return c, ok
+	}
+
+	tunnels := make(map[string]bool)
+	for _, stn := range snap.Tunnels {
+		key := stn.key()
+		if stn.Mode == "" {
+			plan.add("tunnel", key, SnapshotConflict, nil, "mode is empty")
+			continue
+		}
+		if key == "port 0" {
+			plan.add("tunnel", key, SnapshotConflict, nil, "the tunnel has neither port nor key")
+			continue
+		}
+		if tunnels[key] {
+			plan.add("tunnel", key, SnapshotConflict, nil, "duplicate tunnel in document")
+			continue
+		}
+		tunnels[key] = true
+		c, ok := resolveClient(stn.Client)
+		if !ok {
+			plan.add("tunnel", key, SnapshotConflict, nil, "client "+stn.Client+" does not exist")
+			continue
+		}
+		t, ok := st.tunnels[key]
+		var change *SnapshotChange
+		if ok {
+			if t.ClientId != c.Id {
+				plan.add("tunnel", key, SnapshotConflict, nil, "the tunnel belongs to client "+st.clientKeys[t.ClientId])
+				continue
+			}
+			if t, err = store.GetTask(t.Id); err != nil {
+				return plan, fmt.Errorf("tunnel %s: %v", key, err)
+			}
+			fields := diffSnapshot(tunnelToSnapshot(t, stn.Client), stn)
+			if len(fields) == 0 {
+				plan.Unchanged++
+				continue
+			}
+			change = plan.add("tunnel", key, SnapshotUpdate, fields, "")
+		} else {
+			t = &Tunnel{ClientId: c.Id, AccountId: c.AccountId, Status: true, Flow: new(Flow), Target: new(Target)}
+			change = plan.add("tunnel", key, SnapshotCreate, nil, "")
+		}
+		t.Mode, t.Port, t.ServerIp, t.Password, t.Remark = stn.Mode, stn.Port, stn.ServerIp, stn.Password, stn.Remark
+		t.Target.TargetStr, t.TargetAddr, t.LocalPath, t.StripPre = stn.Target, stn.TargetAddr, stn.LocalPath, stn.StripPre
+		if dryRun {
+			continue
+		}
+		if ok {
+			err = store.UpdateTask(t)
+		} else {
+			t.Id = store.GetNewTaskId()
+			err = store.NewTask(t)
+		}
+		if err != nil {
+			return plan, fmt.Errorf("tunnel %s: %v", key, err)
+		}
+		change.Id = t.Id
+	}
+
+	hosts := make(map[string][]string) // host+location -> scheme
+	for _, sh := range snap.Hosts {
+		if sh.Location == "" {
+			sh.Location = "/"
+		}
+		if sh.Scheme == "" {
+			sh.Scheme = "all"
+		}
+		key := sh.key()
+		if sh.Host == "" {
+			plan.add("host", key, SnapshotConflict, nil, "host is empty")
+			continue
+		}
+		if schemeConflict(hosts[sh.Host+sh.Location], sh.Scheme) {
+			plan.add("host", key, SnapshotConflict, nil, "duplicate host in document")
+			continue
+		}
+		hosts[sh.Host+sh.Location] = append(hosts[sh.Host+sh.Location], sh.Scheme)
+		c, ok := resolveClient(sh.Client)
+		if !ok {
+			plan.add("host", key, SnapshotConflict, nil, "client "+sh.Client+" does not exist")
+			continue
+		}
+		h, ok := st.hosts[key]
+		if ok {
+			if h.Client.Id != c.Id {
+				plan.add("host", key, SnapshotConflict, nil, "the host belongs to client "+st.clientKeys[h.Client.Id])
+				continue
+			}
+			fields := diffSnapshot(hostToSnapshot(h, sh.Client), sh)
+			if len(fields) == 0 {
+				plan.Unchanged++
+				continue
+			}
+			plan.add("host", key, SnapshotUpdate, fields, "")
+		} else {
+			h = &Host{Host: sh.Host, Location: sh.Location, Scheme: sh.Scheme, Client: c, Flow: new(Flow), Target: new(Target)}
+			if store.IsHostExist(h) {
+				plan.add("host", key, SnapshotConflict, nil, "the host overlaps with an existing host")
+				continue
+			}
+			plan.add("host", key, SnapshotCreate, nil, "")
+		}
+		h.Remark, h.Target.TargetStr, h.IsClose, h.AutoHttps = sh.Remark, sh.Target, sh.IsClose, sh.AutoHttps
+		if dryRun {
+			continue
+		}
+		if ok {
+			err = store.UpdateHost(h)
+		} else {
+			h.Id = store.GetNewHostId()
+			err = store.NewHost(h)
+		}
+		if err != nil {
+			return plan, fmt.Errorf("host %s: %v", key, err)
+		}
+	}
+	return plan, nil
+}
+
+// diffSnapshot 逐字段比较同类型的两个文档对象，返回 name: old -> new 形式的差异，密码字段不输出明文
+func diffSnapshot(cur, want interface{}) []string {
+	ov, nv := reflect.ValueOf(cur).Elem(), reflect.ValueOf(want).Elem()
+	if ov.Type() != nv.Type() {
+		panic(errors.New("diffSnapshot: type mismatch"))
+	}
+	var fields []string
+	for i := 0; i < ov.NumField(); i++ {
+		o, n := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(o, n) {
+			continue
+		}
+		name := strings.Split(ov.Type().Field(i).Tag.Get("json"), ",")[0]
+		if strings.Contains(name, "password") {
+			fields = append(fields, name+": changed")
+		} else {
+			fields = append(fields, fmt.Sprintf("%s: %v -> %v", name, o, n))
+		}
+	}
+	return fields
+}
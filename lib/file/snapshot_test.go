@@ -0,0 +1,94 @@
+package file
+
+import (
+	"testing"
+)
+
+const testSnapshot = `
+version: 1
+accounts:
+  - user_name: alice
+    password: pw
+    status: true
+    flow: 1024
+clients:
+  - verify_key: k1
+    account: alice
+    remark: first
+  - verify_key: k2
+tunnels:
+  - client: k1
+    mode: tcp
+    port: 9001
+    target: 127.0.0.1:22
+  - client: k2
+    mode: secret
+    password: s1
+hosts:
+  - client: k1
+    host: a.example.com
+    target: 127.0.0.1:80
+black_ip_list: [1.2.3.4]
+`
+
+func TestApplySnapshot(t *testing.T) {
+	db := newTestSqliteDb(t)
+	snap, err := ParseSnapshot([]byte(testSnapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := ApplySnapshot(db, snap, true)
+	if err != nil || len(plan.Changes) != 7 || plan.Conflicts() != 0 {
+		t.Fatalf("unexpected dry run plan %v, err %v", plan, err)
+	}
+	if clients, _ := db.GetAllClients(); len(clients) != 0 {
+		t.Fatalf("dry run created %d clients", len(clients))
+	}
+	if plan, err = ApplySnapshot(db, snap, false); err != nil || len(plan.Changes) != 7 {
+		t.Fatalf("unexpected plan %v, err %v", plan, err)
+	}
+	if plan, err = ApplySnapshot(db, snap, false); err != nil || len(plan.Changes) != 0 || plan.Unchanged != 7 {
+		t.Fatalf("second apply is not a no-op: %v, err %v", plan, err)
+	}
+	if global := db.GetGlobal(); len(global.BlackIpList) != 1 || global.BlackIpList[0] != "1.2.3.4" {
+		t.Fatalf("black ip list is %v", global.BlackIpList)
+	}
+
+	exported, err := ExportSnapshot(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := exported.Marshal("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestSqliteDb(t)
+	if snap, err = ParseSnapshot(b); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err = ApplySnapshot(other, snap, false); err != nil || len(plan.Changes) != 7 || plan.Conflicts() != 0 {
+		t.Fatalf("apply exported snapshot returned %v, err %v", plan, err)
+	}
+	if again, err := ExportSnapshot(other); err != nil || diffSnapshotDocs(exported, again) {
+		t.Fatalf("exported snapshots differ, err %v", err)
+	}
+
+	snap = &Snapshot{
+		Clients: []*SnapshotClient{{VerifyKey: "k1", Account: "alice", Remark: "changed"}},
+		Tunnels: []*SnapshotTunnel{{Client: "k2", Mode: "udp", Port: 9001}},
+		Hosts:   []*SnapshotHost{{Client: "k2", Host: "a.example.com", Scheme: "http"}},
+	}
+	plan, err = ApplySnapshot(other, snap, true)
+	if err != nil || len(plan.Changes) != 3 || plan.Conflicts() != 2 {
+		t.Fatalf("unexpected plan %v, err %v", plan, err)
+	}
+	if c := plan.Changes[0]; c.Action != SnapshotUpdate || c.Key != "k1" || len(c.Fields) != 1 {
+		t.Fatalf("unexpected change %+v", c)
+	}
+}
+
+func diffSnapshotDocs(a, b *Snapshot) bool {
+	x, _ := a.Marshal("yaml")
+	y, _ := b.Marshal("yaml")
+	return string(x) != string(y)
+}
@@ -0,0 +1,40 @@
+package file
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// SqliteDb 提供内嵌 SQLite 存储操作，适用于单机部署与测试
+// 与 MySQL 通用的语句直接复用 DbUtils，仅重写方言不兼容的部分
+type SqliteDb struct {
+	*DbUtils
+}
+
+// NewSqliteDb 打开（不存在时创建）path 指向的 SQLite 数据库文件
+func NewSqliteDb(path string) (*SqliteDb, error) {
+	if path == "" {
+		return nil, errors.New("sqlite_path is empty")
+	}
+	if path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite 同一时间只允许一个写连接，避免出现 database is locked
+	db.SetMaxOpenConns(1)
+	return &SqliteDb{
+		DbUtils: &DbUtils{
+			SqlDB:  db,
+			driver: DriverSqlite,
+		},
+	}, nil
+}
@@ -0,0 +1,175 @@
+package file
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ehang.io/nps/lib/common"
+	"github.com/astaxie/beego/logs"
+)
+
+// 存储驱动名称，通过 nps.conf 中的 db_driver 选择
+const (
+	DriverMysql  = "mysql"
+	DriverSqlite = "sqlite"
+)
+
+// ClientStore 客户端相关的存储操作
+type ClientStore interface {
+	GetClientList(start, length int, search, sortField, order string, clientId int) ([]*Client, int)
+	GetIdByVerifyKey(vKey string, addr string) (int, error)
+	DelClient(id int) error
+	NewClient(c *Client) error
+	VerifyVkey(vkey string, id int) bool
+	UpdateClient(t *Client) error
+	IsPubClient(id int) bool
+	GetClient(id int) (*Client, error)
+	GetNewClientId() int
+	GetAllClients() ([]*Client, error)
+	GetClientIdByVkey(vkey string) (int, error)
+	GetClientByVkeyAndAccountId(vkey string, accountId int) int
+}
+
+// TaskStore 隧道相关的存储操作
+type TaskStore interface {
+	NewTask(t *Tunnel) error
+	UpdateTask(t *Tunnel) error
+	DelTask(id int) error
+	GetTaskByMd5Password(p string) *Tunnel
+	GetTask(id int) (*Tunnel, error)
+	GetNewTaskId() int
+	GetAllTasks() ([]*Tunnel, error)
+	GetUserTasks(accountId int, clientId int) ([]*Tunnel, error)
+	GetTunnelList(start, length int, mode string, clientId int, search, sortField, order string) ([]*Tunnel, int, error)
+	GetTasksByClientId(clientId int) ([]*Tunnel, error)
+}
+
+// HostStore 域名解析相关的存储操作
+type HostStore interface {
+	DelHost(id int) error
+	IsHostExist(h *Host) bool
+	NewHost(t *Host) error
+	GetHost(start, length int, id int, search string) ([]*Host, int, error)
+	GetNewHostId() int
+	GetAllHosts() ([]*Host, error)
+	GetHostsByClientId(clientId int) ([]*Host, error)
+	GetHostById(id int) (*Host, error)
+	GetInfoByHost(host string, r *http.Request) (*Host, error)
+	GetHostsByDomain(host, scheme string) ([]*Host, error)
+	UpdateHost(h *Host) error
+}
+
+// AccountStore 账号相关的存储操作
+type AccountStore interface {
+	VerifyUserName(username string, id int) bool
+	GetByUsername(username string) (*Account, error)
+	GetByUsernameNoErr(username string) *Account
+	NewAccount(c *Account) error
+	AddTraffic(accountId int, flow float64) error
+	ConsumeTraffic(accountId int, kb float64) error
+	ResetAllowances(now time.Time) ([]int, error)
+	AddMonths(accountId int, months int) error
+	GetAccountInfo(accountId int) (*Account, error)
+	GetAccountFlowLimit(accountID int) (int64, error)
+	GetAllAccounts() ([]*Account, error)
+	UpdateAccount(a *Account) error
+	UpdatePassword(accountId int, password string) error
+	SetAccountRole(accountId int, role string, resellerId int) error
+}
+
+// OrderStore 订单相关的存储操作
+type OrderStore interface {
+	CreateOrder(order *Order) error
+	GetOrderById(orderId int64) (*Order, error)
+	GetNewOrderId() int64
+	GetOrderByExternalId(externalId string) (*Order, error)
+	UpdateOrder(order *Order) error
+}
+
+// GlobalStore 全局配置相关的存储操作
+type GlobalStore interface {
+	SaveGlobal(t *Glob) error
+	GetGlobal() *Glob
+}
+
+// Store nps 的存储后端，MySQL 与 SQLite 均实现该接口
+type Store interface {
+	ClientStore
+	TaskStore
+	HostStore
+	AccountStore
+	OrderStore
+	SettleStore
+	PlanStore
+	CouponStore
+	NotifyStore
+	TotpStore
+	ApiKeyStore
+	LoginSessionStore
+	OidcStore
+	EmailStore
+	LoginLockStore
+	GlobalStore
+	MigrationStore
+	TrafficStore
+}
+
+var (
+	_ Store = (*DbUtils)(nil)
+	_ Store = (*SqliteDb)(nil)
+)
+
+var (
+	Db   Store
+	once sync.Once
+)
+
+// GetDb 根据 nps.conf 中的 db_driver 建立存储连接，执行未完成的数据库迁移，并返回 Store 实例
+func GetDb() Store {
+	once.Do(func() {
+		store, err := OpenStore(GetDriverName())
+		if err != nil {
+			panic(err)
+		}
+		if _, err := store.MigrateUp(); err != nil {
+			panic(err)
+		}
+		Db = store
+	})
+	return Db
+}
+
+// SetDb 使用指定的存储代替配置的存储，用于测试
+func SetDb(store Store) {
+	once.Do(func() {})
+	Db = store
+}
+
+// GetDriverName 返回配置的存储驱动，未配置时默认为 mysql
+func GetDriverName() string {
+	driver := strings.ToLower(common.GetConfig("db_driver"))
+	if driver == "" {
+		driver = DriverMysql
+	}
+	return driver
+}
+
+// OpenStore 按驱动名称打开对应的存储后端
+func OpenStore(driver string) (Store, error) {
+	logs.Info("the storage driver is %s", driver)
+	switch driver {
+	case DriverMysql:
+		return NewMysqlDb(common.GetConfig("mysql_dsn"))
+	case DriverSqlite:
+		path := common.GetConfig("sqlite_path")
+		if path == "" {
+			path = filepath.Join(common.GetRunPath(), "conf", "nps.db")
+		}
+		return NewSqliteDb(path)
+	}
+	return nil, fmt.Errorf("unknown db_driver %s in nps.conf", driver)
+}
@@ -0,0 +1,125 @@
+package file
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AdminTotpId 配置文件中的管理员没有账号记录，使用 0 保存其两步验证设置
+const AdminTotpId = 0
+
+// Totp 账号的两步验证设置，Enabled 为 false 时表示尚未完成绑定
+type Totp struct {
+	AccountId int
+	Secret    string
+	Enabled   bool
+	LastStep  int64
+}
+
+// TotpStore 两步验证相关的存储操作
+type TotpStore interface {
+	GetTotp(accountId int) (*Totp, error)
+	SaveTotpSecret(accountId int, secret string) error
+	EnableTotp(accountId int, step int64, recoveryHashes []string) error
+	DisableTotp(accountId int) error
+	UseTotpStep(accountId int, step int64) (bool, error)
+	UseRecoveryCode(accountId int, hash string) (bool, error)
+	CountRecoveryCodes(accountId int) (int, error)
+}
+
+// GetTotp 返回账号的两步验证设置，未绑定时返回 nil
+func (s *DbUtils) GetTotp(accountId int) (*Totp, error) {
+	t := &Totp{AccountId: accountId}
+	err := s.SqlDB.QueryRow("SELECT secret, enabled, last_step FROM totp WHERE account_id = ?", accountId).
+		Scan(&t.Secret, &t.Enabled, &t.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// SaveTotpSecret 开始绑定两步验证，保存新的密钥，已有的设置与恢复码会被清除
+func (s *DbUtils) SaveTotpSecret(accountId int, secret string) error {
+	tx, err := s.SqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM totp_recovery WHERE account_id = ?", accountId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM totp WHERE account_id = ?", accountId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO totp (account_id, secret, enabled, last_step, created_at) VALUES (?, ?, 0, 0, ?)",
+		accountId, secret, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EnableTotp 完成绑定，step 为绑定时验证通过的时间步，同时保存恢复码的哈希
+func (s *DbUtils) EnableTotp(accountId int, step int64, recoveryHashes []string) error {
+	tx, err := s.SqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE totp SET enabled = 1, last_step = ? WHERE account_id = ? AND enabled = 0", step, accountId)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	for _, h := range recoveryHashes {
+		if _, err := tx.Exec("INSERT INTO totp_recovery (account_id, code_hash, used_at) VALUES (?, ?, 0)", accountId, h); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DisableTotp 关闭账号的两步验证并删除恢复码
+func (s *DbUtils) DisableTotp(accountId int) error {
+	tx, err := s.SqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM totp_recovery WHERE account_id = ?", accountId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM totp WHERE account_id = ?", accountId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTotpStep 记录验证通过的时间步，时间步不大于上次使用的时间步时返回 false，防止同一验证码被重复使用
+func (s *DbUtils) UseTotpStep(accountId int, step int64) (bool, error) {
+	res, err := s.SqlDB.Exec("UPDATE totp SET last_step = ? WHERE account_id = ? AND enabled = 1 AND last_step < ?", step, accountId, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode 使用一个恢复码，恢复码不存在或已使用时返回 false
+func (s *DbUtils) UseRecoveryCode(accountId int, hash string) (bool, error) {
+	res, err := s.SqlDB.Exec("UPDATE totp_recovery SET used_at = ? WHERE account_id = ? AND code_hash = ? AND used_at = 0",
+		time.Now().Unix(), accountId, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CountRecoveryCodes 返回账号未使用的恢复码数量
+func (s *DbUtils) CountRecoveryCodes(accountId int) (int, error) {
+	var n int
+	err := s.SqlDB.QueryRow("SELECT COUNT(*) FROM totp_recovery WHERE account_id = ? AND used_at = 0", accountId).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,49 @@
+package file
+
+import "testing"
+
+func TestTotpStore(t *testing.T) {
+	db := newTestSqliteDb(t)
+	if got, err := db.GetTotp(AdminTotpId); got != nil || err != nil {
+		t.Fatalf("GetTotp without enrollment %+v %v", got, err)
+	}
+	if err := db.SaveTotpSecret(AdminTotpId, "SECRET"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := db.UseTotpStep(AdminTotpId, 10); ok {
+		t.Fatal("pending enrollment accepted a code")
+	}
+	if err := db.EnableTotp(AdminTotpId, 10, []string{"h1", "h2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnableTotp(AdminTotpId, 11, nil); err == nil {
+		t.Fatal("enabled twice")
+	}
+	if got, _ := db.GetTotp(AdminTotpId); got == nil || !got.Enabled || got.Secret != "SECRET" || got.LastStep != 10 {
+		t.Fatalf("GetTotp %+v", got)
+	}
+	if ok, _ := db.UseTotpStep(AdminTotpId, 10); ok {
+		t.Fatal("replayed step accepted")
+	}
+	if ok, _ := db.UseTotpStep(AdminTotpId, 11); !ok {
+		t.Fatal("next step rejected")
+	}
+	if ok, _ := db.UseRecoveryCode(AdminTotpId, "h1"); !ok {
+		t.Fatal("recovery code rejected")
+	}
+	if ok, _ := db.UseRecoveryCode(AdminTotpId, "h1"); ok {
+		t.Fatal("recovery code used twice")
+	}
+	if n, _ := db.CountRecoveryCodes(AdminTotpId); n != 1 {
+		t.Fatalf("CountRecoveryCodes %d", n)
+	}
+	if err := db.DisableTotp(AdminTotpId); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetTotp(AdminTotpId); got != nil {
+		t.Fatal("totp not removed")
+	}
+	if n, _ := db.CountRecoveryCodes(AdminTotpId); n != 0 {
+		t.Fatal("recovery codes not removed")
+	}
+}
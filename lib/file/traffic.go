@@ -0,0 +1,130 @@
+package file
+
+import (
+	"fmt"
+	"time"
+)
+
+// 流量历史的统计对象
+const (
+	TrafficKindTunnel  = "tunnel"
+	TrafficKindHost    = "host"
+	TrafficKindClient  = "client"
+	TrafficKindAccount = "account"
+)
+
+// 流量历史的精度，raw 的时间粒度为 flow_store_interval
+const (
+	TrafficRaw  = "raw"
+	TrafficHour = "hour"
+	TrafficDay  = "day"
+)
+
+// TrafficSample 一个统计对象在一个时间段内的流量，Bucket 为时间段开始的 unix 时间戳
+type TrafficSample struct {
+	Kind       string `json:"kind"`
+	ObjectId   int    `json:"object_id"`
+	Resolution string `json:"resolution"`
+	Bucket     int64  `json:"bucket"`
+	InBytes    int64  `json:"in_bytes"`
+	OutBytes   int64  `json:"out_bytes"`
+	Conns      int64  `json:"conns"`
+}
+
+// TrafficStore 流量历史相关的存储操作
+type TrafficStore interface {
+	AddTrafficSamples(samples []*TrafficSample) error
+	GetTrafficSamples(kind string, objectId int, resolution string, start, end int64) ([]*TrafficSample, error)
+	PruneTrafficSamples(resolution string, before int64) (int64, error)
+}
+
+// TrafficBucket 返回 t 所在的 hour 或 day 时间段的开始时间，day 按服务器本地时区划分
+func TrafficBucket(resolution string, t time.Time) int64 {
+	switch resolution {
+	case TrafficHour:
+		return t.Truncate(time.Hour).Unix()
+	case TrafficDay:
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Unix()
+	}
+	return t.Unix()
+}
+
+// trafficUpsertSql 累加写入流量的语句，两种方言的 upsert 写法不同
+func (s *DbUtils) trafficUpsertSql() string {
+	if s.driver == DriverSqlite {
+		return `INSERT INTO traffic_samples (kind, object_id, resolution, bucket, in_bytes, out_bytes, conns)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (kind, object_id, resolution, bucket) DO UPDATE SET
+			in_bytes = in_bytes + excluded.in_bytes, out_bytes = out_bytes + excluded.out_bytes, conns = conns + excluded.conns`
+	}
+	return `INSERT INTO traffic_samples (kind, object_id, resolution, bucket, in_bytes, out_bytes, conns)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+		in_bytes = in_bytes + VALUES(in_bytes), out_bytes = out_bytes + VALUES(out_bytes), conns = conns + VALUES(conns)`
+}
+
+// AddTrafficSamples 写入 raw 精度的流量采样，并在同一事务中累加到对应的 hour 与 day 汇总中
+func (s *DbUtils) AddTrafficSamples(samples []*TrafficSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	tx, err := s.SqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(s.trafficUpsertSql())
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, v := range samples {
+		t := time.Unix(v.Bucket, 0)
+		for _, bucket := range []struct {
+			resolution string
+			bucket     int64
+		}{
+			{TrafficRaw, v.Bucket},
+			{TrafficHour, TrafficBucket(TrafficHour, t)},
+			{TrafficDay, TrafficBucket(TrafficDay, t)},
+		} {
+			if _, err := stmt.Exec(v.Kind, v.ObjectId, bucket.resolution, bucket.bucket, v.InBytes, v.OutBytes, v.Conns); err != nil {
+				return fmt.Errorf("add traffic sample %s %d: %v", v.Kind, v.ObjectId, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// GetTrafficSamples 按时间顺序返回统计对象在 [start, end) 内指定精度的流量
+func (s *DbUtils) GetTrafficSamples(kind string, objectId int, resolution string, start, end int64) ([]*TrafficSample, error) {
+	q := NewQuery("traffic_samples").
+		Where("kind = ? AND object_id = ? AND resolution = ?", kind, objectId, resolution).
+		Where("bucket >= ? AND bucket < ?", start, end).
+		OrderBy("", "", nil, "bucket")
+	query, args := q.SelectSql("kind, object_id, resolution, bucket, in_bytes, out_bytes, conns")
+	rows, err := s.SqlDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []*TrafficSample
+	for rows.Next() {
+		v := new(TrafficSample)
+		if err := rows.Scan(&v.Kind, &v.ObjectId, &v.Resolution, &v.Bucket, &v.InBytes, &v.OutBytes, &v.Conns); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// PruneTrafficSamples 删除指定精度下 before 之前的流量，返回删除的行数
+func (s *DbUtils) PruneTrafficSamples(resolution string, before int64) (int64, error) {
+	res, err := s.SqlDB.Exec("DELETE FROM traffic_samples WHERE resolution = ? AND bucket < ?", resolution, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
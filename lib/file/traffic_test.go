@@ -0,0 +1,54 @@
+package file
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrafficSamples(t *testing.T) {
+	db := newTestSqliteDb(t)
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+	var samples []*TrafficSample
+	for i := 0; i < 90; i++ {
+		bucket := base.Add(time.Duration(i) * time.Minute).Unix()
+		samples = append(samples,
+			&TrafficSample{Kind: TrafficKindTunnel, ObjectId: 1, Bucket: bucket, InBytes: 10, OutBytes: 20, Conns: 1},
+			&TrafficSample{Kind: TrafficKindClient, ObjectId: 1, Bucket: bucket, InBytes: 1, OutBytes: 2},
+		)
+	}
+	if err := db.AddTrafficSamples(samples); err != nil {
+		t.Fatal(err)
+	}
+	// 同一时间段重复写入时累加
+	if err := db.AddTrafficSamples(samples[:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	end := base.Add(48 * time.Hour).Unix()
+	raw, err := db.GetTrafficSamples(TrafficKindTunnel, 1, TrafficRaw, base.Unix(), end)
+	if err != nil || len(raw) != 90 || raw[0].InBytes != 20 || raw[1].InBytes != 10 {
+		t.Fatalf("raw samples %d, err %v", len(raw), err)
+	}
+	hours, err := db.GetTrafficSamples(TrafficKindTunnel, 1, TrafficHour, base.Unix(), end)
+	if err != nil || len(hours) != 2 {
+		t.Fatalf("hour samples %d, err %v", len(hours), err)
+	}
+	if hours[0].Bucket != base.Unix() || hours[0].InBytes != 610 || hours[0].OutBytes != 1220 || hours[0].Conns != 61 || hours[1].InBytes != 300 {
+		t.Fatalf("unexpected hour samples %+v %+v", hours[0], hours[1])
+	}
+	days, err := db.GetTrafficSamples(TrafficKindTunnel, 1, TrafficDay, TrafficBucket(TrafficDay, base), end)
+	if err != nil || len(days) != 1 || days[0].InBytes != 910 {
+		t.Fatalf("day samples %v, err %v", days, err)
+	}
+	if clients, _ := db.GetTrafficSamples(TrafficKindClient, 1, TrafficDay, 0, end); len(clients) != 1 || clients[0].OutBytes != 182 {
+		t.Fatalf("client day samples %v", clients)
+	}
+
+	n, err := db.PruneTrafficSamples(TrafficRaw, base.Add(time.Hour).Unix())
+	if err != nil || n != 120 {
+		t.Fatalf("pruned %d raw samples, err %v", n, err)
+	}
+	if hours, _ = db.GetTrafficSamples(TrafficKindTunnel, 1, TrafficHour, 0, end); len(hours) != 2 {
+		t.Fatalf("pruning raw samples removed hour samples")
+	}
+}
@@ -0,0 +1,38 @@
+package goroutine
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrAccountExpired 账号已到期
+var ErrAccountExpired = errors.New("account subscription expired")
+
+// expiredAccounts 已到期的账号，由服务端的到期检查任务维护
+var expiredAccounts sync.Map
+
+// SetAccountExpired 标记账号是否已到期
+func SetAccountExpired(accountId int, expired bool) {
+	if expired {
+		expiredAccounts.Store(accountId, struct{}{})
+	} else {
+		expiredAccounts.Delete(accountId)
+	}
+}
+
+// IsAccountExpired 账号是否已到期
+func IsAccountExpired(accountId int) bool {
+	if accountId <= 0 {
+		return false
+	}
+	_, ok := expiredAccounts.Load(accountId)
+	return ok
+}
+
+// CheckAccount 账号到期或流量用尽时返回对应的错误
+func CheckAccount(accountId int) error {
+	if IsAccountExpired(accountId) {
+		return ErrAccountExpired
+	}
+	return TrafficManager.CheckQuota(accountId)
+}
@@ -0,0 +1,99 @@
+package goroutine
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+// hostCache 容量有限、带过期时间的域名解析缓存，按 scheme 与域名缓存该域名下所有的 host 记录，超出容量时淘汰最久未使用的记录
+// 查询失败或没有记录的结果同样缓存，由调用方指定较短的过期时间
+type hostCache struct {
+	sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List // 队首为最近使用的记录
+	gen     uint64     // 每次清空后加一，清空前开始的查询结果不再写入
+}
+
+type hostCacheEntry struct {
+	key     string
+	hosts   []*file.Host
+	err     error
+	expires time.Time
+}
+
+func newHostCache(size int) *hostCache {
+	return &hostCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Get 返回未过期的缓存记录，ok 为 false 表示没有缓存
+func (c *hostCache) Get(key string) (hosts []*file.Host, err error, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	entry := e.Value.(*hostCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(e)
+		return nil, nil, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.hosts, entry.err, true
+}
+
+// Generation 返回当前的缓存版本，查询数据库前获取，写入时传给 Set
+func (c *hostCache) Generation() uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.gen
+}
+
+// Set 写入缓存记录，记录在 ttl 后过期，gen 与当前版本不同时说明查询期间缓存被清空，丢弃查询结果
+func (c *hostCache) Set(key string, hosts []*file.Host, err error, ttl time.Duration, gen uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if gen != c.gen {
+		return
+	}
+	expires := time.Now().Add(ttl)
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*hostCacheEntry)
+		entry.hosts, entry.err, entry.expires = hosts, err, expires
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&hostCacheEntry{key: key, hosts: hosts, err: err, expires: expires})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Purge 清空缓存
+func (c *hostCache) Purge() {
+	c.Lock()
+	defer c.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.gen++
+}
+
+// Len 返回缓存的记录数，包括尚未清除的过期记录
+func (c *hostCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.lru.Len()
+}
+
+func (c *hostCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*hostCacheEntry).key)
+}
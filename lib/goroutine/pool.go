@@ -17,13 +17,14 @@ func init() {
 }
 
 type connGroup struct {
-	src    io.ReadWriteCloser
-	dst    io.ReadWriteCloser
-	wg     *sync.WaitGroup
-	n      *int64
-	flow   *file.Flow
-	task   *file.Tunnel
-	remote string
+	src     io.ReadWriteCloser
+	dst     io.ReadWriteCloser
+	wg      *sync.WaitGroup
+	n       *int64
+	flow    *file.Flow
+	task    *file.Tunnel
+	remote  string
+	inbound bool // 访问者发往客户端的方向
 }
 
 //func newConnGroup(dst, src io.ReadWriteCloser, wg *sync.WaitGroup, n *int64) connGroup {
@@ -35,15 +36,16 @@ type connGroup struct {
 //	}
 //}
 
-func newConnGroup(dst, src io.ReadWriteCloser, wg *sync.WaitGroup, n *int64, flow *file.Flow, task *file.Tunnel, remote string) connGroup {
+func newConnGroup(dst, src io.ReadWriteCloser, wg *sync.WaitGroup, n *int64, flow *file.Flow, task *file.Tunnel, remote string, inbound bool) connGroup {
 	return connGroup{
-		src:    src,
-		dst:    dst,
-		wg:     wg,
-		n:      n,
-		flow:   flow,
-		task:   task,
-		remote: remote,
+		src:     src,
+		dst:     dst,
+		wg:      wg,
+		n:       n,
+		flow:    flow,
+		task:    task,
+		remote:  remote,
+		inbound: inbound,
 	}
 }
 
@@ -80,23 +82,6 @@ func CopyBuffer(dst io.Writer, src io.Reader, flow *file.Flow, task *file.Tunnel
 
 		if nr > 0 {
 			nw, ew := dst.Write(buf[0:nr])
-			if nw > 0 {
-				//written += int64(nw)
-				if flow != nil && task != nil && task.AccountId > 0 {
-					TrafficManager.AccumulateTrafficData(task.AccountId, int64(nw))
-					// <<20 = 1024 * 1024
-					flowLimit := TrafficManager.GetFlowLimitFromCache(task.AccountId)
-					if err != nil {
-						logs.Error("Failed to get flow limit for account %d: %v", task.AccountId, err)
-						flowLimit = 0
-					}
-					if flowLimit <= 0 {
-						logs.Info("流量已经超出限制 (已用: %d, 限制: %d)", flow.ExportFlow+flow.InletFlow, flowLimit)
-						break
-					}
-				}
-
-			}
 			if ew != nil {
 				err = ew
 				break
@@ -122,7 +107,17 @@ func copyConnGroup(group interface{}) {
 		return
 	}
 	var err error
-	err = CopyBuffer(cg.dst, cg.src, cg.flow, cg.task, cg.remote)
+	var dst io.Writer = cg.dst
+	if task := cg.task; task != nil {
+		dst = &trafficWriter{Writer: cg.dst, f: func(n int64) {
+			if cg.inbound {
+				TrafficHistory.AddTunnel(task, n, 0, 0)
+			} else {
+				TrafficHistory.AddTunnel(task, 0, n, 0)
+			}
+		}}
+	}
+	err = CopyBuffer(dst, cg.src, cg.flow, cg.task, cg.remote)
 	if err != nil {
 		cg.src.Close()
 		cg.dst.Close()
@@ -160,9 +155,12 @@ func copyConns(group interface{}) {
 	wg.Add(2)
 	var in, out int64
 	remoteAddr := conns.conn2.RemoteAddr().String()
-	_ = connCopyPool.Invoke(newConnGroup(conns.conn1, conns.conn2, wg, &in, conns.flow, conns.task, remoteAddr))
+	if conns.task != nil {
+		TrafficHistory.AddTunnel(conns.task, 0, 0, 1)
+	}
+	_ = connCopyPool.Invoke(newConnGroup(conns.conn1, conns.conn2, wg, &in, conns.flow, conns.task, remoteAddr, true))
 	// outside to mux : incoming
-	_ = connCopyPool.Invoke(newConnGroup(conns.conn2, conns.conn1, wg, &out, conns.flow, conns.task, remoteAddr))
+	_ = connCopyPool.Invoke(newConnGroup(conns.conn2, conns.conn1, wg, &out, conns.flow, conns.task, remoteAddr, false))
 	// mux to outside : outgoing
 	wg.Wait()
 	//if conns.flow != nil {
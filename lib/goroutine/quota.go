@@ -0,0 +1,80 @@
+package goroutine
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+// ErrQuotaExceeded 账号流量已用尽
+var ErrQuotaExceeded = errors.New("account traffic quota exceeded")
+
+// Remaining 返回账号剩余的流量字节数，已扣除尚未写入数据库的流量
+func (tcm *TrafficCacheManager) Remaining(accountID int) int64 {
+	limit := tcm.GetFlowLimitFromCache(accountID)
+	s := tcm.shard(accountID)
+	s.RLock()
+	defer s.RUnlock()
+	if record, ok := s.records[accountID]; ok {
+		return limit - atomic.LoadInt64(&record.accumulatedBytes)
+	}
+	return limit
+}
+
+// CheckQuota 账号流量用尽时返回 ErrQuotaExceeded，accountID 小于等于 0 表示不属于任何账号，不做限制
+func (tcm *TrafficCacheManager) CheckQuota(accountID int) error {
+	if accountID <= 0 {
+		return nil
+	}
+	if tcm.Remaining(accountID) <= 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Debit 记入账号的流量并检查剩余额度
+func (tcm *TrafficCacheManager) Debit(accountID int, bytes int64) error {
+	if accountID <= 0 {
+		return nil
+	}
+	if bytes > 0 {
+		tcm.AccumulateTrafficData(accountID, bytes)
+	}
+	return tcm.CheckQuota(accountID)
+}
+
+// quotaConn 双向流量都记入账号，账号到期或额度用尽后读写返回错误以断开连接
+type quotaConn struct {
+	net.Conn
+	accountId int
+}
+
+// NewQuotaConn 包装访问者连接，accountId 小于等于 0 时原样返回
+func NewQuotaConn(c net.Conn, accountId int) net.Conn {
+	if accountId <= 0 {
+		return c
+	}
+	return &quotaConn{Conn: c, accountId: accountId}
+}
+
+func (c *quotaConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		TrafficManager.AccumulateTrafficData(c.accountId, int64(n))
+		if qerr := CheckAccount(c.accountId); qerr != nil && err == nil {
+			err = qerr
+		}
+	}
+	return n, err
+}
+
+func (c *quotaConn) Write(p []byte) (int, error) {
+	if err := CheckAccount(c.accountId); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		TrafficManager.AccumulateTrafficData(c.accountId, int64(n))
+	}
+	return n, err
+}
@@ -1,10 +1,13 @@
 package goroutine
 
 import (
+	"errors"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"ehang.io/nps/lib/common"
 	"ehang.io/nps/lib/file"
 	"github.com/astaxie/beego/logs"
 )
@@ -12,107 +15,207 @@ import (
 var (
 	TrafficManager = NewTrafficCacheManager()
 	initOnce       sync.Once
+
+	errHostNotFound = errors.New("The host could not be parsed")
+)
+
+const (
+	trafficShardCount = 32
+	hostCacheSize     = 4096
+	hostCacheTTL      = 30 * time.Second
+	hostNegativeTTL   = 5 * time.Second  // 查询失败的结果缓存时间较短，新添加的域名解析很快生效
+	flowLimitTTL      = 30 * time.Second // 流量额度的缓存时间，后台充值的流量在此时间内生效
 )
 
+// trafficStore TrafficCacheManager 用到的存储操作
+type trafficStore interface {
+	GetAccountFlowLimit(accountID int) (int64, error)
+	GetAccountInfo(accountId int) (*file.Account, error)
+	ConsumeTraffic(accountId int, kb float64) error
+	GetHostsByDomain(host, scheme string) ([]*file.Host, error)
+}
+
 type TrafficRecord struct {
-	accumulatedBytes int64
+	accumulatedBytes int64 // 原子操作
 	lastUpdatedTime  time.Time
 }
 
+// flowLimit 缓存的账号剩余流量，单位为字节
+type flowLimit struct {
+	bytes  int64
+	loaded time.Time
+}
+
+// trafficShard 按账号 id 分片的流量记录，map 的读写都需要持有锁，
+// 累加计数在读锁内通过原子操作完成，删除记录需要写锁，因此刷新时不会丢失正在累加的流量
+type trafficShard struct {
+	sync.RWMutex
+	records    map[int]*TrafficRecord
+	flowLimits map[int]flowLimit // 流量限制缓存
+}
+
 type TrafficCacheManager struct {
-	sync.Mutex
-	records     map[int]*TrafficRecord
-	accounts    map[int]*file.Account
-	hosts       map[string]*file.Host
-	flowLimits  map[int]int64 // 流量限制缓存
+	shards      [trafficShardCount]trafficShard
+	hosts       *hostCache
+	store       func() trafficStore
 	flushTicker *time.Ticker
 }
 
 func NewTrafficCacheManager() *TrafficCacheManager {
-	return &TrafficCacheManager{
-		records:     make(map[int]*TrafficRecord),
-		flowLimits:  make(map[int]int64),
-		accounts:    make(map[int]*file.Account),
-		hosts:       make(map[string]*file.Host),
+	tcm := &TrafficCacheManager{
+		hosts:       newHostCache(hostCacheSize),
+		store:       func() trafficStore { return file.GetDb() },
 		flushTicker: time.NewTicker(5 * time.Second),
 	}
+	for i := range tcm.shards {
+		tcm.shards[i].records = make(map[int]*TrafficRecord)
+		tcm.shards[i].flowLimits = make(map[int]flowLimit)
+	}
+	return tcm
+}
+
+func (tcm *TrafficCacheManager) shard(accountID int) *trafficShard {
+	return &tcm.shards[uint(accountID)%trafficShardCount]
 }
 
 func (tcm *TrafficCacheManager) AccumulateTrafficData(accountID int, bytes int64) {
-	if record, exists := tcm.records[accountID]; exists {
-		record.accumulatedBytes += bytes
+	s := tcm.shard(accountID)
+	s.RLock()
+	if record, exists := s.records[accountID]; exists {
+		atomic.AddInt64(&record.accumulatedBytes, bytes)
+		s.RUnlock()
+		return
+	}
+	s.RUnlock()
+	s.Lock()
+	if record, exists := s.records[accountID]; exists {
+		atomic.AddInt64(&record.accumulatedBytes, bytes)
 	} else {
-		tcm.records[accountID] = &TrafficRecord{
+		s.records[accountID] = &TrafficRecord{
 			accumulatedBytes: bytes,
 			lastUpdatedTime:  time.Now(),
 		}
 	}
+	s.Unlock()
 }
 
+// GetFlowLimitFromCache 返回数据库中账号剩余的流量字节数，不包括尚未写入数据库的流量
 func (tcm *TrafficCacheManager) GetFlowLimitFromCache(accountID int) int64 {
-	if limit, exists := tcm.flowLimits[accountID]; exists {
-		return limit
+	s := tcm.shard(accountID)
+	s.RLock()
+	limit, exists := s.flowLimits[accountID]
+	s.RUnlock()
+	if exists && time.Since(limit.loaded) < flowLimitTTL {
+		return limit.bytes
 	}
-	logs.Debug("account %d GetFlowLimitFromCache start 2!", accountID)
-	// 缓存中没有则从数据库获取
-	db := file.GetDb()
-	limit, err := db.GetAccountFlowLimit(accountID)
-	logs.Debug("account %d GetFlowLimitFromCache start 3!", limit)
+	// 缓存中没有或已过期则从数据库获取
+	bytes, err := tcm.store().GetAccountFlowLimit(accountID)
 	if err != nil {
 		logs.Error("Failed to get flow limit for account %d: %v", accountID, err)
 		return 0
 	}
-	logs.Debug("account %d GetFlowLimitFromCache start 4!", limit)
-	tcm.flowLimits[accountID] = limit
-	return limit
+	s.Lock()
+	s.flowLimits[accountID] = flowLimit{bytes: bytes, loaded: time.Now()}
+	s.Unlock()
+	return bytes
+}
+
+// ResetFlowLimit 清除账号的流量额度缓存，在账号流量被修改后调用
+func (tcm *TrafficCacheManager) ResetFlowLimit(accountID int) {
+	s := tcm.shard(accountID)
+	s.Lock()
+	delete(s.flowLimits, accountID)
+	s.Unlock()
 }
 
 // GetInfoByHost 根据请求中的 host 与 URL 信息返回匹配的 host 记录
+// 按 scheme 与域名缓存该域名下所有的记录，location 在缓存的记录中匹配，请求路径不同不会导致缓存未命中
 func (tcm *TrafficCacheManager) GetInfoByHost(host string, r *http.Request) (*file.Host, error) {
-
-	if h, exists := tcm.hosts[host]; exists {
+	scheme, uri := "", "/"
+	if r != nil && r.URL != nil {
+		scheme, uri = r.URL.Scheme, r.RequestURI
+	}
+	host = common.GetIpByAddr(host)
+	key := scheme + "://" + host
+	hosts, err, ok := tcm.hosts.Get(key)
+	if !ok {
+		gen := tcm.hosts.Generation()
+		hosts, err = tcm.store().GetHostsByDomain(host, scheme)
+		if err != nil || len(hosts) == 0 {
+			tcm.hosts.Set(key, nil, err, hostNegativeTTL, gen)
+		} else {
+			tcm.hosts.Set(key, hosts, nil, hostCacheTTL, gen)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if h := file.MatchHostLocation(hosts, uri); h != nil {
 		return h, nil
 	}
-	logs.Info("GetInfoByHost %s", host)
-	db := file.GetDb()
-	h, err := db.GetInfoByHost(host, r)
-	tcm.hosts[host] = h
-	return h, err
+	return nil, errHostNotFound
+}
+
+// InvalidateHosts 清空域名解析缓存，在域名解析被添加、修改或删除后调用
+// 通配符域名可能匹配任意缓存的域名，因此直接清空全部缓存，清空前已经开始的查询结果不会写入缓存
+func (tcm *TrafficCacheManager) InvalidateHosts() {
+	tcm.hosts.Purge()
 }
 
 func (tcm *TrafficCacheManager) updateFlowLimit(accountID int) {
-	db := file.GetDb()
-	account, err := db.GetAccountInfo(accountID)
+	account, err := tcm.store().GetAccountInfo(accountID)
 	if err != nil {
 		return
 	}
-	tcm.accounts[accountID] = account
-	limit := account.Flow.FlowLimit * (1 << 20)
-
-	tcm.flowLimits[accountID] = limit
+	// 账号流量以 KB 为单位
+	s := tcm.shard(accountID)
+	s.Lock()
+	s.flowLimits[accountID] = flowLimit{bytes: account.Remaining() << 10, loaded: time.Now()}
+	s.Unlock()
 }
 
 func (tcm *TrafficCacheManager) ConditionalFlush() {
-	// 检查并处理缓存数据
-	for accountID, record := range tcm.records {
-		// 更新当前accountID的流量限制
-		tcm.updateFlowLimit(accountID)
-
-		limit := tcm.flowLimits[accountID]
-		// 如果缓存数据达到1MB或者账户流量已超限，则写入数据库
-		if record.accumulatedBytes >= 1<<20 ||
-			(limit > 0 && record.accumulatedBytes >= limit) {
-			tcm.flushTrafficDataToDB(accountID, record)
-			delete(tcm.records, accountID)
+	for i := range tcm.shards {
+		s := &tcm.shards[i]
+		s.RLock()
+		ids := make([]int, 0, len(s.records))
+		for accountID := range s.records {
+			ids = append(ids, accountID)
+		}
+		s.RUnlock()
+
+		for _, accountID := range ids {
+			// 更新当前accountID的流量限制，数据库操作不持有锁
+			tcm.updateFlowLimit(accountID)
+
+			s.Lock()
+			record, ok := s.records[accountID]
+			var bytes int64
+			if ok {
+				bytes = atomic.LoadInt64(&record.accumulatedBytes)
+				limit := s.flowLimits[accountID]
+				// 如果缓存数据达到1MB或者账户流量已超限，则写入数据库
+				if bytes >= 1<<20 || bytes >= limit.bytes {
+					delete(s.records, accountID)
+					// 写入数据库的流量从缓存的额度中扣除，避免下次刷新前额度被重复使用
+					limit.bytes -= bytes
+					s.flowLimits[accountID] = limit
+				} else {
+					ok = false
+				}
+			}
+			s.Unlock()
+			if ok {
+				tcm.flushTrafficDataToDB(accountID, bytes)
+			}
 		}
 	}
 }
 
-func (tcm *TrafficCacheManager) flushTrafficDataToDB(accountID int, record *TrafficRecord) {
-	db := file.GetDb()
+func (tcm *TrafficCacheManager) flushTrafficDataToDB(accountID int, bytes int64) {
 	// 将字节转换为KB (1<<10 = 1024)
-	kb := float64(record.accumulatedBytes) / (1 << 10)
-	if err := db.AddTraffic(accountID, -kb); err != nil {
+	kb := float64(bytes) / (1 << 10)
+	if err := tcm.store().ConsumeTraffic(accountID, kb); err != nil {
 		logs.Error("Failed to flush traffic data for account %d: %v", accountID, err)
 	}
 }
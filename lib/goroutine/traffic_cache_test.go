@@ -0,0 +1,211 @@
+package goroutine
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+type fakeTrafficStore struct {
+	sync.Mutex
+	flushed map[int]float64
+	lookups int64
+	limit   int64
+}
+
+func (s *fakeTrafficStore) GetAccountFlowLimit(accountID int) (int64, error) {
+	return s.limit, nil
+}
+
+func (s *fakeTrafficStore) GetAccountInfo(accountId int) (*file.Account, error) {
+	return &file.Account{Id: accountId, Flow: new(file.Flow)}, nil
+}
+
+func (s *fakeTrafficStore) ConsumeTraffic(accountId int, kb float64) error {
+	s.Lock()
+	s.flushed[accountId] += kb
+	s.Unlock()
+	return nil
+}
+
+func (s *fakeTrafficStore) GetHostsByDomain(host, scheme string) ([]*file.Host, error) {
+	atomic.AddInt64(&s.lookups, 1)
+	switch host {
+	case "missing.com":
+		return nil, errors.New("not found")
+	case "empty.com":
+		return nil, nil
+	}
+	return []*file.Host{{Id: 1, Host: host, Location: "/"}, {Id: 2, Host: host, Location: "/api"}}, nil
+}
+
+func newTestTrafficCacheManager() (*TrafficCacheManager, *fakeTrafficStore) {
+	store := &fakeTrafficStore{flushed: make(map[int]float64)}
+	tcm := NewTrafficCacheManager()
+	tcm.flushTicker.Stop()
+	tcm.store = func() trafficStore { return store }
+	return tcm, store
+}
+
+func TestTrafficCacheManagerConcurrent(t *testing.T) {
+	tcm, store := newTestTrafficCacheManager()
+	const (
+		accounts   = 100
+		goroutines = 2000
+		calls      = 50
+		chunk      = 4096
+	)
+	done := make(chan struct{})
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				tcm.ConditionalFlush()
+				tcm.GetFlowLimitFromCache(1)
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				tcm.AccumulateTrafficData(i%accounts+1, chunk)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(done)
+	<-flushed
+
+	// 刷新到存储与仍在缓存中的流量之和应等于写入的总量
+	for id := 1; id <= accounts; id++ {
+		total := float64(goroutines/accounts*calls*chunk) / (1 << 10)
+		s := tcm.shard(id)
+		var remain int64
+		if record, ok := s.records[id]; ok {
+			remain = record.accumulatedBytes
+		}
+		if got := store.flushed[id] + float64(remain)/(1<<10); got != total {
+			t.Fatalf("account %d traffic %v, want %v", id, got, total)
+		}
+	}
+}
+
+func TestTrafficCacheManagerHosts(t *testing.T) {
+	tcm, store := newTestTrafficCacheManager()
+	r, _ := http.NewRequest("GET", "http://a.com/api", nil)
+	r.RequestURI = "/api/v1"
+	for i := 0; i < 3; i++ {
+		if h, err := tcm.GetInfoByHost("a.com", r); err != nil || h.Id != 2 {
+			t.Fatalf("GetInfoByHost %v %v", h, err)
+		}
+	}
+	// 同一域名的不同路径使用同一条缓存，在缓存的记录中匹配 location
+	for i := 0; i < 100; i++ {
+		r.RequestURI = "/static/" + strconv.Itoa(i)
+		if h, err := tcm.GetInfoByHost("a.com:80", r); err != nil || h.Id != 1 {
+			t.Fatalf("GetInfoByHost %v %v", h, err)
+		}
+	}
+	if store.lookups != 1 || tcm.hosts.Len() != 1 {
+		t.Fatalf("lookups %d, cached %d, want 1", store.lookups, tcm.hosts.Len())
+	}
+	// 查询失败的结果同样缓存
+	for i := 0; i < 2; i++ {
+		if _, err := tcm.GetInfoByHost("missing.com", r); err == nil {
+			t.Fatal("expected error for missing host")
+		}
+	}
+	if store.lookups != 2 {
+		t.Fatalf("lookups %d, want 2", store.lookups)
+	}
+	if _, err := tcm.GetInfoByHost("empty.com", r); err == nil {
+		t.Fatal("expected error for host without records")
+	}
+	// 修改域名解析后缓存失效
+	tcm.InvalidateHosts()
+	tcm.GetInfoByHost("a.com", r)
+	tcm.GetInfoByHost("missing.com", r)
+	if store.lookups != 5 {
+		t.Fatalf("lookups %d after invalidation, want 5", store.lookups)
+	}
+}
+
+func TestHostCache(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	c := newHostCache(2)
+	c.Set("a", []*file.Host{{Host: "a"}}, nil, ttl, 0)
+	c.Set("b", []*file.Host{{Host: "b"}}, nil, ttl, 0)
+	c.Get("a")
+	c.Set("c", []*file.Host{{Host: "c"}}, nil, ttl, 0)
+	if _, _, ok := c.Get("b"); ok {
+		t.Fatal("least recently used entry was not evicted")
+	}
+	if _, _, ok := c.Get("a"); !ok || c.Len() != 2 {
+		t.Fatalf("unexpected cache state, len %d", c.Len())
+	}
+	c.Set("d", nil, errors.New("not found"), time.Hour, 0)
+	if h, err, ok := c.Get("d"); !ok || h != nil || err == nil {
+		t.Fatal("negative entry not cached")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, _, ok := c.Get("a"); ok {
+		t.Fatal("expired entry returned")
+	}
+	// 清空前开始的查询结果不再写入
+	gen := c.Generation()
+	c.Purge()
+	if c.Len() != 0 {
+		t.Fatalf("len %d after purge", c.Len())
+	}
+	c.Set("e", []*file.Host{{Host: "e"}}, nil, time.Hour, gen)
+	if _, _, ok := c.Get("e"); ok {
+		t.Fatal("stale lookup cached after purge")
+	}
+	c.Set("e", []*file.Host{{Host: "e"}}, nil, time.Hour, c.Generation())
+	if _, _, ok := c.Get("e"); !ok {
+		t.Fatal("lookup after purge not cached")
+	}
+}
+
+func TestTrafficCacheManagerQuota(t *testing.T) {
+	tcm, store := newTestTrafficCacheManager()
+	store.limit = 10000
+	if err := tcm.CheckQuota(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tcm.Debit(1, 6000); err != nil {
+		t.Fatal(err)
+	}
+	if got := tcm.Remaining(1); got != 4000 {
+		t.Fatalf("remaining %d, want 4000", got)
+	}
+	if err := tcm.Debit(1, 5000); err != ErrQuotaExceeded {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+	if err := tcm.CheckQuota(2); err != nil {
+		t.Fatalf("other account affected: %v", err)
+	}
+	// 不属于任何账号的流量不做限制
+	if err := tcm.Debit(0, 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	// 充值后清除缓存即可恢复
+	store.limit = 1 << 20
+	tcm.ResetFlowLimit(1)
+	if err := tcm.CheckQuota(1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,157 @@
+package goroutine
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+// TrafficHistory 在内存中累计隧道、域名解析、客户端与账号的流量，由服务端按 flow_store_interval 定期写入流量历史
+var TrafficHistory = NewTrafficRecorder()
+
+type trafficKey struct {
+	kind string
+	id   int
+}
+
+type trafficCounter struct {
+	in    int64
+	out   int64
+	conns int64
+}
+
+// TrafficRecorder 流量计数器，in 为访问者发往客户端的流量，out 为客户端返回给访问者的流量
+type TrafficRecorder struct {
+	sync.RWMutex
+	counters map[trafficKey]*trafficCounter
+}
+
+func NewTrafficRecorder() *TrafficRecorder {
+	return &TrafficRecorder{counters: make(map[trafficKey]*trafficCounter)}
+}
+
+func (r *TrafficRecorder) add(kind string, id int, in, out, conns int64) {
+	if id <= 0 {
+		return
+	}
+	k := trafficKey{kind, id}
+	// 持有读锁期间完成累加，保证 Flush 交换计数器时不会丢失正在写入的数据
+	r.RLock()
+	c, ok := r.counters[k]
+	if ok {
+		atomic.AddInt64(&c.in, in)
+		atomic.AddInt64(&c.out, out)
+		atomic.AddInt64(&c.conns, conns)
+		r.RUnlock()
+		return
+	}
+	r.RUnlock()
+	r.Lock()
+	if c, ok = r.counters[k]; !ok {
+		c = new(trafficCounter)
+		r.counters[k] = c
+	}
+	atomic.AddInt64(&c.in, in)
+	atomic.AddInt64(&c.out, out)
+	atomic.AddInt64(&c.conns, conns)
+	r.Unlock()
+}
+
+// Add 记录统计对象的流量，同时累加到所属的客户端与账号，id 小于等于 0 的对象被忽略
+func (r *TrafficRecorder) Add(kind string, id, clientId, accountId int, in, out, conns int64) {
+	r.add(kind, id, in, out, conns)
+	r.add(file.TrafficKindClient, clientId, in, out, conns)
+	r.add(file.TrafficKindAccount, accountId, in, out, conns)
+}
+
+// AddTunnel 记录隧道的流量与连接数
+func (r *TrafficRecorder) AddTunnel(t *file.Tunnel, in, out, conns int64) {
+	clientId := t.ClientId
+	if clientId == 0 && t.Client != nil {
+		clientId = t.Client.Id
+	}
+	r.Add(file.TrafficKindTunnel, t.Id, clientId, t.AccountId, in, out, conns)
+}
+
+// AddHost 记录域名解析的流量与连接数
+func (r *TrafficRecorder) AddHost(h *file.Host, in, out, conns int64) {
+	clientId := 0
+	if h.Client != nil {
+		clientId = h.Client.Id
+	}
+	r.Add(file.TrafficKindHost, h.Id, clientId, h.AccountId, in, out, conns)
+}
+
+// Flush 取出并清零当前的计数，返回以 bucket 为时间段的 raw 采样
+func (r *TrafficRecorder) Flush(bucket time.Time) []*file.TrafficSample {
+	r.Lock()
+	counters := r.counters
+	r.counters = make(map[trafficKey]*trafficCounter, len(counters))
+	r.Unlock()
+	samples := make([]*file.TrafficSample, 0, len(counters))
+	for k, c := range counters {
+		samples = append(samples, &file.TrafficSample{
+			Kind:       k.kind,
+			ObjectId:   k.id,
+			Resolution: file.TrafficRaw,
+			Bucket:     bucket.Unix(),
+			InBytes:    c.in,
+			OutBytes:   c.out,
+			Conns:      c.conns,
+		})
+	}
+	return samples
+}
+
+// trafficWriter 统计写入 Writer 的流量
+type trafficWriter struct {
+	io.Writer
+	f func(n int64)
+}
+
+func (w *trafficWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if n > 0 {
+		w.f(int64(n))
+	}
+	return n, err
+}
+
+// NewHostWriter 包装发往访问者的 Writer，记录域名解析的 out 流量
+func NewHostWriter(w io.Writer, h *file.Host) io.Writer {
+	return &trafficWriter{Writer: w, f: func(n int64) {
+		TrafficHistory.AddHost(h, 0, n, 0)
+	}}
+}
+
+// hostConn 统计访问者连接上域名解析的流量，读取为 in，写入为 out
+type hostConn struct {
+	net.Conn
+	host *file.Host
+}
+
+// NewHostConn 包装访问者连接，记录经过该连接的域名解析流量与连接数
+func NewHostConn(c net.Conn, h *file.Host) net.Conn {
+	TrafficHistory.AddHost(h, 0, 0, 1)
+	return &hostConn{Conn: c, host: h}
+}
+
+func (c *hostConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		TrafficHistory.AddHost(c.host, int64(n), 0, 0)
+	}
+	return n, err
+}
+
+func (c *hostConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		TrafficHistory.AddHost(c.host, 0, int64(n), 0)
+	}
+	return n, err
+}
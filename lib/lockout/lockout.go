@@ -0,0 +1,146 @@
+package lockout
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"ehang.io/nps/lib/file"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+)
+
+// 锁定状态在本地缓存的时间，客户端每次连接都会检查，缓存后不必每次查询数据库
+// 本实例记录的失败立即更新缓存，其他服务端实例的锁定最多延迟 cacheTTL 生效
+const (
+	cacheTTL  = 5 * time.Second
+	cacheSize = 10000
+)
+
+type cachedLock struct {
+	until   int64     // 锁定到期时间，0 表示未锁定
+	checked time.Time // 读取数据库的时间
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = make(map[string]cachedLock)
+)
+
+func cacheKey(scope, subject string) string {
+	return scope + "\x00" + subject
+}
+
+// cached 返回缓存的锁定到期时间，没有缓存或已过期时返回 false
+func cached(key string, now time.Time) (int64, bool) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	c, ok := cache[key]
+	if !ok || now.Sub(c.checked) >= cacheTTL {
+		return 0, false
+	}
+	return c.until, true
+}
+
+// remember 缓存锁定到期时间，缓存已满时先删除过期的记录，仍然已满时清空
+func remember(key string, until int64, now time.Time) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if _, ok := cache[key]; !ok && len(cache) >= cacheSize {
+		for k, c := range cache {
+			if now.Sub(c.checked) >= cacheTTL {
+				delete(cache, k)
+			}
+		}
+		if len(cache) >= cacheSize {
+			cache = make(map[string]cachedLock)
+		}
+	}
+	cache[key] = cachedLock{until: until, checked: now}
+}
+
+// PolicyFromConfig 根据 nps.conf 读取锁定策略，默认 10 分钟内失败 10 次锁定 1 分钟，之后每次翻倍，最长 1 天
+func PolicyFromConfig() file.LockPolicy {
+	return file.LockPolicy{
+		MaxFailures: beego.AppConfig.DefaultInt("login_fail_max", 10),
+		Window:      time.Duration(beego.AppConfig.DefaultInt("login_fail_window", 600)) * time.Second,
+		LockTime:    time.Duration(beego.AppConfig.DefaultInt("login_lock_time", 60)) * time.Second,
+		MaxLockTime: time.Duration(beego.AppConfig.DefaultInt("login_lock_max", 86400)) * time.Second,
+		ResetAfter:  time.Duration(beego.AppConfig.DefaultInt("login_lock_reset", 86400)) * time.Second,
+	}
+}
+
+// Enabled login_fail_max 为 0 时不限制
+func Enabled() bool {
+	return PolicyFromConfig().MaxFailures > 0
+}
+
+// LockedError 锁定中的错误，包含剩余时间
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed attempts, try again in %d seconds", int(time.Until(e.Until).Seconds())+1)
+}
+
+// Check 检查地址或用户名是否在锁定中，subject 为空时不检查
+// 读取失败时不阻止登录，避免数据库故障导致所有人无法登录
+func Check(scope, subject string) error {
+	if subject == "" || !Enabled() {
+		return nil
+	}
+	now, key := time.Now(), cacheKey(scope, subject)
+	until, ok := cached(key, now)
+	if !ok {
+		var err error
+		if until, err = file.GetDb().GetLoginLockedUntil(scope, subject, now); err != nil {
+			logs.Warn("get login lock of %s %s error %v", scope, subject, err)
+			return nil
+		}
+		remember(key, until, now)
+	}
+	if until > now.Unix() {
+		return &LockedError{Until: time.Unix(until, 0)}
+	}
+	return nil
+}
+
+// Fail 记录一次失败，subject 为空时不记录
+func Fail(scope, subject string) {
+	p := PolicyFromConfig()
+	if subject == "" || p.MaxFailures <= 0 {
+		return
+	}
+	now := time.Now()
+	until, err := file.GetDb().RecordLoginFailure(scope, subject, p, now)
+	if err != nil {
+		logs.Warn("record login failure of %s %s error %v", scope, subject, err)
+		return
+	}
+	remember(cacheKey(scope, subject), until, now)
+	if until > 0 {
+		logs.Warn("%s %s locked until %s after too many failed attempts", scope, subject, time.Unix(until, 0).Format("2006-01-02 15:04:05"))
+	}
+}
+
+// Succeed 验证成功后清零失败次数
+func Succeed(scope, subject string) {
+	if subject == "" || !Enabled() {
+		return
+	}
+	if err := file.GetDb().ResetLoginFailures(scope, subject); err != nil {
+		logs.Warn("reset login failures of %s %s error %v", scope, subject, err)
+	}
+}
+
+// Unban 解除锁定并清除失败记录，返回 false 表示没有记录
+func Unban(scope, subject string) (bool, error) {
+	ok, err := file.GetDb().DeleteLoginLock(scope, subject)
+	if err == nil {
+		cacheMu.Lock()
+		delete(cache, cacheKey(scope, subject))
+		cacheMu.Unlock()
+	}
+	return ok, err
+}
@@ -0,0 +1,66 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+func newTestDb(t *testing.T) *file.SqliteDb {
+	db, err := file.NewSqliteDb(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.SqlDB.Close() })
+	file.SetDb(db)
+	cache = make(map[string]cachedLock)
+	return db
+}
+
+func TestFailLocks(t *testing.T) {
+	newTestDb(t)
+	n := PolicyFromConfig().MaxFailures
+	for i := 0; i < n; i++ {
+		if err := Check(file.LockScopeUser, "alice"); err != nil {
+			t.Fatalf("locked after %d failures: %v", i, err)
+		}
+		Fail(file.LockScopeUser, "alice")
+	}
+	if _, ok := Check(file.LockScopeUser, "alice").(*LockedError); !ok {
+		t.Fatal("not locked after max failures")
+	}
+	if err := Check(file.LockScopeIp, "alice"); err != nil {
+		t.Fatalf("other scope locked: %v", err)
+	}
+	if ok, err := Unban(file.LockScopeUser, "alice"); !ok || err != nil {
+		t.Fatalf("Unban %v %v", ok, err)
+	}
+	if err := Check(file.LockScopeUser, "alice"); err != nil {
+		t.Fatalf("locked after unban: %v", err)
+	}
+}
+
+func TestCheckCached(t *testing.T) {
+	db := newTestDb(t)
+	if err := Check(file.LockScopeBridge, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	// 其他实例写入的锁定在缓存过期前不会被读取
+	p := PolicyFromConfig()
+	p.MaxFailures = 1
+	if _, err := db.RecordLoginFailure(file.LockScopeBridge, "10.0.0.1", p, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(file.LockScopeBridge, "10.0.0.1"); err != nil {
+		t.Fatalf("cached state not used: %v", err)
+	}
+	key := cacheKey(file.LockScopeBridge, "10.0.0.1")
+	cache[key] = cachedLock{checked: time.Now().Add(-cacheTTL)}
+	if _, ok := Check(file.LockScopeBridge, "10.0.0.1").(*LockedError); !ok {
+		t.Fatal("lock not read after cache expired")
+	}
+}
@@ -0,0 +1,18 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+)
+
+// SendVerifyEmail 发送邮箱验证链接
+func SendVerifyEmail(m Mailer, to, username, link string, ttl time.Duration) error {
+	return m.SendMail(to, "验证邮箱", fmt.Sprintf("账号 %s 绑定了此邮箱，请在 %d 分钟内打开以下链接完成验证：\n\n%s\n\n如果不是您本人操作，请忽略此邮件。",
+		username, int(ttl.Minutes()), link))
+}
+
+// SendResetPassword 发送找回密码链接
+func SendResetPassword(m Mailer, to, username, link string, ttl time.Duration) error {
+	return m.SendMail(to, "重置密码", fmt.Sprintf("账号 %s 申请了重置密码，请在 %d 分钟内打开以下链接设置新密码，链接只能使用一次：\n\n%s\n\n如果不是您本人操作，请忽略此邮件，密码不会被修改。",
+		username, int(ttl.Minutes()), link))
+}
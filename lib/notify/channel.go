@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+// Webhook 以 JSON 格式 POST 通知，Url 为空时发送到账号设置的地址
+type Webhook struct {
+	Url    string
+	client *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{Url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Send(s *file.NotifySetting, e *Event) error {
+	url := w.Url
+	if url == "" {
+		url = s.WebhookUrl
+	}
+	if url == "" {
+		return nil
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// Smtp 通过 SMTP 服务器发送邮件到账号设置的邮箱，Username 为空时不认证
+type Smtp struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *Smtp) Name() string {
+	return "smtp"
+}
+
+func (m *Smtp) Send(s *file.NotifySetting, e *Event) error {
+	if s.Email == "" {
+		return nil
+	}
+	return m.sendMail(s.Email, e.Subject, e.Message, time.Unix(e.Time, 0))
+}
+
+// SendMail 发送纯文本邮件，实现 Mailer
+func (m *Smtp) SendMail(to, subject, body string) error {
+	return m.sendMail(to, subject, body, time.Now())
+}
+
+func (m *Smtp) sendMail(to, subject, body string, date time.Time) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, strings.Split(m.Addr, ":")[0])
+	}
+	from := m.From
+	if from == "" {
+		from = m.Username
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	return smtp.SendMail(m.Addr, auth, from, []string{to}, msg.Bytes())
+}
@@ -0,0 +1,135 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"ehang.io/nps/lib/file"
+	"github.com/astaxie/beego"
+)
+
+// 通知类型
+const (
+	KindQuota    = "quota"    // 流量额度使用达到阈值
+	KindExpiring = "expiring" // 账号即将到期
+	KindExpired  = "expired"  // 账号已到期
+	KindTest     = "test"     // 测试通知
+)
+
+// Event 发送给账号的通知
+type Event struct {
+	Kind      string `json:"kind"`
+	AccountId int    `json:"account_id"`
+	UserName  string `json:"user_name"`
+	Threshold int    `json:"threshold,omitempty"` // 流量额度使用百分比
+	Subject   string `json:"subject"`
+	Message   string `json:"message"`
+	Time      int64  `json:"time"`
+}
+
+// Channel 通知渠道，账号未配置该渠道的接收地址时直接返回 nil
+type Channel interface {
+	Name() string
+	Send(s *file.NotifySetting, e *Event) error
+}
+
+// Notifier 把通知发送到全部渠道
+type Notifier struct {
+	Channels []Channel
+}
+
+// FromConfig 根据 nps.conf 创建通知器，账号的 webhook 始终可用，
+// 配置 notify_webhook_url 后所有通知同时发送到该地址，配置 smtp_host 后发送邮件
+func FromConfig() *Notifier {
+	n := &Notifier{Channels: []Channel{NewWebhook("")}}
+	if u := beego.AppConfig.String("notify_webhook_url"); u != "" {
+		n.Channels = append(n.Channels, NewWebhook(u))
+	}
+	if m := smtpFromConfig(); m != nil {
+		n.Channels = append(n.Channels, m)
+	}
+	return n
+}
+
+// Mailer 发送邮件，用于邮箱验证与找回密码等直接发送给指定地址的邮件
+type Mailer interface {
+	SendMail(to, subject, body string) error
+}
+
+// MailerFromConfig 配置了 smtp_host 时返回通过该服务器发送的 Mailer，否则返回 nil
+func MailerFromConfig() Mailer {
+	if m := smtpFromConfig(); m != nil {
+		return m
+	}
+	return nil
+}
+
+func smtpFromConfig() *Smtp {
+	host := beego.AppConfig.String("smtp_host")
+	if host == "" {
+		return nil
+	}
+	return &Smtp{
+		Addr:     fmt.Sprintf("%s:%d", host, beego.AppConfig.DefaultInt("smtp_port", 25)),
+		Username: beego.AppConfig.String("smtp_username"),
+		Password: beego.AppConfig.String("smtp_password"),
+		From:     beego.AppConfig.String("smtp_from"),
+	}
+}
+
+// Notify 把通知发送到全部渠道，返回各渠道的错误
+func (n *Notifier) Notify(s *file.NotifySetting, e *Event) error {
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	var errs []error
+	for _, c := range n.Channels {
+		if err := c.Send(s, e); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", c.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// QuotaUsage 返回账号本周期流量额度的使用百分比，未设置每月额度的账号在流量用尽时返回 100，否则 ok 为 false
+func QuotaUsage(a *file.Account) (pct int, ok bool) {
+	if a.Allowance > 0 {
+		used := a.Allowance - a.AllowanceLeft
+		if used < 0 {
+			used = 0
+		}
+		return int(used * 100 / a.Allowance), true
+	}
+	if a.Remaining() <= 0 {
+		return 100, true
+	}
+	return 0, false
+}
+
+// QuotaEvent 流量额度使用达到阈值的通知
+func QuotaEvent(a *file.Account, threshold int) *Event {
+	e := &Event{Kind: KindQuota, AccountId: a.Id, UserName: a.WebUserName, Threshold: threshold}
+	switch {
+	case a.Allowance <= 0:
+		e.Subject = "流量已用尽"
+		e.Message = fmt.Sprintf("账号 %s 的流量已用尽，隧道将无法继续使用，请及时充值。", a.WebUserName)
+	case threshold >= 100:
+		e.Subject = "本月流量额度已用完"
+		e.Message = fmt.Sprintf("账号 %s 本周期的流量额度已用完，之后将消耗购买的流量，流量用尽后隧道将无法继续使用。", a.WebUserName)
+	default:
+		e.Subject = fmt.Sprintf("本月流量额度已使用 %d%%", threshold)
+		e.Message = fmt.Sprintf("账号 %s 本周期的流量额度已使用 %d%%。", a.WebUserName, threshold)
+	}
+	return e
+}
+
+// ExpiryEvent 账号即将到期或已到期的通知
+func ExpiryEvent(a *file.Account, expired bool) *Event {
+	if expired {
+		return &Event{Kind: KindExpired, AccountId: a.Id, UserName: a.WebUserName, Subject: "账号已到期",
+			Message: fmt.Sprintf("账号 %s 已于 %s 到期，隧道已停止，续费后自动恢复。", a.WebUserName, a.ExpireTime)}
+	}
+	return &Event{Kind: KindExpiring, AccountId: a.Id, UserName: a.WebUserName, Subject: "账号即将到期",
+		Message: fmt.Sprintf("账号 %s 将于 %s 到期，到期后隧道将停止，请及时续费。", a.WebUserName, a.ExpireTime)}
+}
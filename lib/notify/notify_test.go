@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+// fakeSmtp 只实现发送邮件所需命令的本地 SMTP 服务器，返回监听地址与收到的邮件
+func fakeSmtp(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	mails := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		reply := func(s string) { c.Write([]byte(s + "\r\n")) }
+		reply("220 localhost")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					mails <- data.String()
+					reply("250 OK")
+				} else {
+					data.WriteString(line)
+				}
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+				reply("250 OK")
+			case "DATA":
+				inData = true
+				reply("354 go ahead")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), mails
+}
+
+func TestSmtp(t *testing.T) {
+	addr, mails := fakeSmtp(t)
+	m := &Smtp{Addr: addr, From: "nps@example.com"}
+	s := &file.NotifySetting{AccountId: 1, Email: "user@example.com"}
+	a := &file.Account{Id: 1, WebUserName: "user", Allowance: 100, AllowanceLeft: 5}
+	if err := (&Notifier{Channels: []Channel{m}}).Notify(s, QuotaEvent(a, 95)); err != nil {
+		t.Fatal(err)
+	}
+	mail := <-mails
+	if !strings.Contains(mail, "To: user@example.com") || !strings.Contains(mail, "=?UTF-8?b?") ||
+		!strings.Contains(mail, "已使用 95%") {
+		t.Fatalf("unexpected mail:\n%s", mail)
+	}
+	if err := m.Send(&file.NotifySetting{}, QuotaEvent(a, 95)); err != nil {
+		t.Fatalf("account without email should be skipped, got %v", err)
+	}
+}
+
+func TestSendResetPassword(t *testing.T) {
+	addr, mails := fakeSmtp(t)
+	var m Mailer = &Smtp{Addr: addr, From: "nps@example.com"}
+	link := "https://nps.example.com/login/resetpassword?token=abc"
+	if err := SendResetPassword(m, "user@example.com", "user", link, 30*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	mail := <-mails
+	if !strings.Contains(mail, "To: user@example.com") || !strings.Contains(mail, link) || !strings.Contains(mail, "30 分钟") {
+		t.Fatalf("unexpected mail:\n%s", mail)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	events := make(chan *Event, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := new(Event)
+		json.NewDecoder(r.Body).Decode(e)
+		events <- e
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	a := &file.Account{Id: 2, WebUserName: "user", ExpireTime: "2026-01-01"}
+	n := &Notifier{Channels: []Channel{NewWebhook(""), NewWebhook(srv.URL + "/fail")}}
+	err := n.Notify(&file.NotifySetting{AccountId: 2, WebhookUrl: srv.URL + "/ok"}, ExpiryEvent(a, true))
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("expected webhook error, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if e := <-events; e.Kind != KindExpired || e.AccountId != 2 || e.Time == 0 {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	}
+}
+
+func TestQuotaUsage(t *testing.T) {
+	for _, c := range []struct {
+		a   *file.Account
+		pct int
+		ok  bool
+	}{
+		{&file.Account{Allowance: 1000, AllowanceLeft: 150}, 85, true},
+		{&file.Account{Allowance: 1000, AllowanceLeft: 0}, 100, true},
+		{&file.Account{Flow: &file.Flow{FlowLimit: 10}}, 0, false},
+		{&file.Account{Flow: &file.Flow{}}, 100, true},
+	} {
+		if pct, ok := QuotaUsage(c.a); pct != c.pct || ok != c.ok {
+			t.Fatalf("QuotaUsage(%+v) = %d %v", c.a, pct, ok)
+		}
+	}
+}
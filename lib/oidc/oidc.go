@@ -0,0 +1,253 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// RoleMapping 用户组到角色的映射
+type RoleMapping struct {
+	Group string
+	Role  string
+}
+
+// Config OpenID Connect 登录配置
+type Config struct {
+	Issuer        string
+	ClientId      string
+	ClientSecret  string
+	RedirectUrl   string
+	Scopes        []string
+	UsernameClaim string        // 作为用户名的声明，默认为 preferred_username
+	GroupsClaim   string        // 用户组声明，默认为 groups
+	RoleMap       []RoleMapping // 按顺序匹配，第一个匹配的用户组决定角色
+	DefaultRole   string        // 没有匹配的用户组时的角色，为空时保持账号原有角色
+}
+
+// FromConfig 根据 nps.conf 创建配置，未配置 oidc_issuer 时返回 nil
+func FromConfig() (*Config, error) {
+	issuer := beego.AppConfig.String("oidc_issuer")
+	if issuer == "" {
+		return nil, nil
+	}
+	c := &Config{
+		Issuer:        issuer,
+		ClientId:      beego.AppConfig.String("oidc_client_id"),
+		ClientSecret:  beego.AppConfig.String("oidc_client_secret"),
+		RedirectUrl:   beego.AppConfig.String("oidc_redirect_url"),
+		Scopes:        strings.Fields(strings.ReplaceAll(beego.AppConfig.DefaultString("oidc_scopes", "openid profile email"), ",", " ")),
+		UsernameClaim: beego.AppConfig.DefaultString("oidc_username_claim", "preferred_username"),
+		GroupsClaim:   beego.AppConfig.DefaultString("oidc_groups_claim", "groups"),
+		DefaultRole:   beego.AppConfig.String("oidc_default_role"),
+	}
+	if c.ClientId == "" || c.RedirectUrl == "" {
+		return nil, errors.New("oidc_client_id and oidc_redirect_url are required")
+	}
+	var err error
+	if c.RoleMap, err = ParseRoleMap(beego.AppConfig.String("oidc_role_map")); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ParseRoleMap 解析 组:角色,组:角色 格式的映射
+func ParseRoleMap(s string) ([]RoleMapping, error) {
+	var m []RoleMapping
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, ":")
+		if i <= 0 || i == len(item)-1 {
+			return nil, fmt.Errorf("invalid oidc role mapping %q", item)
+		}
+		m = append(m, RoleMapping{Group: strings.TrimSpace(item[:i]), Role: strings.TrimSpace(item[i+1:])})
+	}
+	return m, nil
+}
+
+// Identity 通过 ID token 确认的用户身份
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Name     string
+	Email    string
+	Groups   []string
+	Role     string // 按用户组映射的角色，没有匹配且未配置默认角色时为空
+}
+
+// Provider OpenID Connect 身份提供方，通过 issuer 的 discovery 文档获取各端点
+type Provider struct {
+	cfg      *Config
+	client   *http.Client
+	authUrl  string
+	tokenUrl string
+	keys     *keySet
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// NewProvider 读取 issuer 的 discovery 文档，client 为 nil 时使用 10 秒超时的默认客户端
+func NewProvider(cfg *Config, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	var d discovery
+	if err := getJson(client, strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("oidc issuer mismatch, configured %q, provider returned %q", cfg.Issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksUri == "" {
+		return nil, errors.New("oidc discovery document is incomplete")
+	}
+	return &Provider{
+		cfg:      cfg,
+		client:   client,
+		authUrl:  d.AuthorizationEndpoint,
+		tokenUrl: d.TokenEndpoint,
+		keys:     &keySet{url: d.JwksUri, client: client},
+	}, nil
+}
+
+// AuthCodeUrl 返回跳转到身份提供方登录的地址
+func (p *Provider) AuthCodeUrl(state, nonce string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientId)
+	v.Set("redirect_uri", p.cfg.RedirectUrl)
+	v.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	sep := "?"
+	if strings.Contains(p.authUrl, "?") {
+		sep = "&"
+	}
+	return p.authUrl + sep + v.Encode()
+}
+
+// Exchange 使用授权码换取 ID token 并校验，nonce 为跳转登录时生成的随机数
+func (p *Provider) Exchange(code, nonce string) (*Identity, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectUrl)
+	req, err := http.NewRequest(http.MethodPost, p.tokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientId), url.QueryEscape(p.cfg.ClientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("oidc token endpoint returned %s: %v", resp.Status, err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("oidc token endpoint error %s: %s", token.Error, token.ErrorDescription)
+	}
+	if token.IdToken == "" {
+		return nil, errors.New("oidc token response has no id_token")
+	}
+	return p.Verify(token.IdToken, nonce)
+}
+
+// Verify 校验 ID token 的签名、签发方、受众、有效期与 nonce，返回映射后的身份
+func (p *Provider) Verify(rawIdToken, nonce string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}}
+	if _, err := parser.ParseWithClaims(rawIdToken, claims, p.keys.keyFunc); err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+	if !claims.VerifyIssuer(p.cfg.Issuer, true) {
+		return nil, errors.New("id token issuer mismatch")
+	}
+	if !claims.VerifyAudience(p.cfg.ClientId, true) {
+		return nil, errors.New("id token audience mismatch")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id token has no expiry")
+	}
+	if n, _ := claims["nonce"].(string); nonce == "" || n != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	return p.cfg.identity(claims)
+}
+
+// identity 从 ID token 的声明中取出用户名与用户组并映射角色
+func (c *Config) identity(claims jwt.MapClaims) (*Identity, error) {
+	id := &Identity{Issuer: c.Issuer}
+	id.Subject, _ = claims["sub"].(string)
+	id.Username, _ = claims[c.UsernameClaim].(string)
+	id.Name, _ = claims["name"].(string)
+	id.Email, _ = claims["email"].(string)
+	if id.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	if id.Username = strings.TrimSpace(id.Username); id.Username == "" {
+		return nil, fmt.Errorf("id token has no %s claim", c.UsernameClaim)
+	}
+	switch g := claims[c.GroupsClaim].(type) {
+	case []interface{}:
+		for _, v := range g {
+			if s, ok := v.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	case string:
+		id.Groups = strings.Fields(strings.ReplaceAll(g, ",", " "))
+	}
+	id.Role = c.DefaultRole
+	for _, m := range c.RoleMap {
+		if hasGroup(id.Groups, m.Group) {
+			id.Role = m.Role
+			break
+		}
+	}
+	return id, nil
+}
+
+func hasGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func getJson(client *http.Client, u string, v interface{}) error {
+	resp, err := client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s returned %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,189 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// mockServer 本地模拟的身份提供方，token 接口按授权码返回预先设置的声明
+type mockServer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]jwt.MapClaims
+}
+
+func newMockServer(t *testing.T) *mockServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockServer{key: key, claims: map[string]jwt.MapClaims{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/auth",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		claims, ok := m.claims[r.FormValue("code")]
+		if id != "nps" || secret != "secret" || !ok || r.FormValue("grant_type") != "authorization_code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": m.sign(t, claims, "k1")})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockServer) sign(t *testing.T, claims jwt.MapClaims, kid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (m *mockServer) idClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                m.URL,
+		"aud":                "nps",
+		"sub":                "u-1",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"groups":             []string{"staff", "nps-ops"},
+	}
+}
+
+func newTestProvider(t *testing.T, m *mockServer) *Provider {
+	roles, err := ParseRoleMap("nps-admins:admin, nps-ops:operator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProvider(&Config{
+		Issuer:        m.URL,
+		ClientId:      "nps",
+		ClientSecret:  "secret",
+		RedirectUrl:   "http://nps.local/login/oidccallback",
+		Scopes:        []string{"openid", "profile"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		RoleMap:       roles,
+		DefaultRole:   "owner",
+	}, m.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockServer(t)
+	p := newTestProvider(t, m)
+
+	u, err := url.Parse(p.AuthCodeUrl("st", "nc"))
+	if err != nil || u.Path != "/auth" || u.Query().Get("state") != "st" || u.Query().Get("nonce") != "nc" ||
+		u.Query().Get("client_id") != "nps" || u.Query().Get("scope") != "openid profile" {
+		t.Fatalf("AuthCodeUrl %v %v", u, err)
+	}
+
+	m.claims["good"] = m.idClaims("nc")
+	id, err := p.Exchange("good", "nc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "u-1" || id.Username != "alice" || id.Email != "alice@example.com" || id.Role != "operator" || id.Issuer != m.URL {
+		t.Fatalf("identity %+v", id)
+	}
+	if _, err := p.Exchange("good", "other"); err == nil {
+		t.Fatal("nonce mismatch accepted")
+	}
+	if _, err := p.Exchange("missing", "nc"); err == nil {
+		t.Fatal("invalid code accepted")
+	}
+
+	bad := m.idClaims("nc")
+	bad["aud"] = "other"
+	m.claims["aud"] = bad
+	if _, err := p.Exchange("aud", "nc"); err == nil {
+		t.Fatal("audience mismatch accepted")
+	}
+	expired := m.idClaims("nc")
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	m.claims["expired"] = expired
+	if _, err := p.Exchange("expired", "nc"); err == nil {
+		t.Fatal("expired id token accepted")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	m := newMockServer(t)
+	p := newTestProvider(t, m)
+	if _, err := p.Verify(m.sign(t, m.idClaims("nc"), "k2"), "nc"); err == nil {
+		t.Fatal("unknown kid accepted")
+	}
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.idClaims("nc"))
+	token.Header["kid"] = "k1"
+	forged, _ := token.SignedString(other)
+	if _, err := p.Verify(forged, "nc"); err == nil {
+		t.Fatal("forged id token accepted")
+	}
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, m.idClaims("nc"))
+	hs.Header["kid"] = "k1"
+	hmacToken, _ := hs.SignedString([]byte("secret"))
+	if _, err := p.Verify(hmacToken, "nc"); err == nil {
+		t.Fatal("hmac id token accepted")
+	}
+}
+
+func TestRoleMapping(t *testing.T) {
+	roles, err := ParseRoleMap(" admins:admin, urn:ops:operator ,")
+	if err != nil || len(roles) != 2 || roles[0] != (RoleMapping{"admins", "admin"}) || roles[1] != (RoleMapping{"urn:ops", "operator"}) {
+		t.Fatalf("ParseRoleMap %+v %v", roles, err)
+	}
+	if _, err := ParseRoleMap("admins"); err == nil {
+		t.Fatal("mapping without role accepted")
+	}
+	c := &Config{Issuer: "i", UsernameClaim: "email", GroupsClaim: "roles", RoleMap: []RoleMapping{{"a", "admin"}, {"b", "readonly"}}}
+	id, err := c.identity(jwt.MapClaims{"sub": "1", "email": "bob@example.com", "roles": "b a"})
+	if err != nil || id.Username != "bob@example.com" || id.Role != "admin" {
+		t.Fatalf("identity %+v %v", id, err)
+	}
+	if id, _ := c.identity(jwt.MapClaims{"sub": "1", "email": "bob@example.com"}); id.Role != "" {
+		t.Fatalf("role without groups %q", id.Role)
+	}
+	if _, err := c.identity(jwt.MapClaims{"sub": "1"}); err == nil {
+		t.Fatal("identity without username accepted")
+	}
+}
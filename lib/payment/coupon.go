@@ -0,0 +1,55 @@
+package payment
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+// 优惠码校验失败的原因
+var (
+	ErrCouponInvalid = errors.New("coupon is invalid or expired")
+	ErrCouponUsedUp  = errors.New("coupon has been used up")
+	ErrCouponUsed    = errors.New("coupon has already been used by this account")
+)
+
+// minOrderAmount 折扣后订单的最低金额，支付渠道不接受 0 元订单
+const minOrderAmount = 0.01
+
+// ApplyCoupon 校验优惠码并把折扣或赠送流量记录到订单，order.OrderAmount 须为折扣前的金额，
+// 每个账号只能使用同一优惠码一次，使用次数在订单结算时累加
+func ApplyCoupon(store file.CouponStore, order *file.Order, accountId int, code string, now time.Time) error {
+	c, err := store.GetCouponByCode(code)
+	if err == file.ErrCouponNotFound {
+		return ErrCouponInvalid
+	} else if err != nil {
+		return err
+	}
+	if !c.Status || (c.StartAt > 0 && now.Unix() < c.StartAt) || (c.EndAt > 0 && now.Unix() >= c.EndAt) {
+		return ErrCouponInvalid
+	}
+	if c.MaxUses > 0 && c.Used >= c.MaxUses {
+		return ErrCouponUsedUp
+	}
+	if n, err := store.CountCouponUses(c.Code, accountId); err != nil {
+		return err
+	} else if n > 0 {
+		return ErrCouponUsed
+	}
+	order.CouponCode = c.Code
+	switch c.Kind {
+	case file.CouponPercent:
+		order.Discount = roundCent(order.OrderAmount * c.Value / 100)
+	case file.CouponFixed:
+		order.Discount = c.Value
+	case file.CouponBonusFlow:
+		order.BonusFlow = c.Value
+	}
+	if order.Discount > order.OrderAmount-minOrderAmount {
+		order.Discount = math.Max(0, roundCent(order.OrderAmount-minOrderAmount))
+	}
+	order.OrderAmount = roundCent(order.OrderAmount - order.Discount)
+	return nil
+}
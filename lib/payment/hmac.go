@@ -0,0 +1,193 @@
+package payment
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+// 签名相关的请求头，签名为 hex(HMAC-SHA256(secret, timestamp + "." + body))
+const (
+	HeaderTimestamp = "X-Nps-Timestamp"
+	HeaderSignature = "X-Nps-Signature"
+)
+
+// callbackMaxSkew 回调时间戳允许的最大偏差，防止重放
+const callbackMaxSkew = 5 * time.Minute
+
+func init() {
+	Register("hmac", NewHmacProvider)
+}
+
+// HmacProvider 通用的 HMAC 签名支付渠道，与支付网关之间的请求和回调都使用同一密钥签名
+//
+//	POST {gateway}/orders      创建订单，返回 {"payUrl": "...", "params": {...}}
+//	GET  {gateway}/orders/{id} 查询订单，返回 {"status": "paid"}
+//	POST {gateway}/refunds     退款，返回 {"status": "refunded"}
+//
+// 未配置网关地址时，创建订单返回签名后的参数，由前端提交给支付页面，查询与退款不可用
+type HmacProvider struct {
+	cnf    Config
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewHmacProvider 创建 HMAC 签名支付渠道，必须配置 payment_secret
+func NewHmacProvider(cnf Config) (Provider, error) {
+	if cnf.Secret == "" {
+		return nil, errors.New("payment_secret is required by the hmac payment provider")
+	}
+	cnf.GatewayUrl = strings.TrimRight(cnf.GatewayUrl, "/")
+	return &HmacProvider{cnf: cnf, client: &http.Client{Timeout: 10 * time.Second}, now: time.Now}, nil
+}
+
+func (p *HmacProvider) Name() string {
+	return "hmac"
+}
+
+func (p *HmacProvider) AppId() string {
+	return p.cnf.AppId
+}
+
+// Sign 计算时间戳与内容的签名
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *HmacProvider) CreateOrder(order *file.Order) (*Checkout, error) {
+	req := map[string]interface{}{
+		"appId":                 p.cnf.AppId,
+		"externalTransactionId": order.ExternalTransactionId,
+		"amount":                order.OrderAmount,
+		"paymentType":           order.PaymentType,
+		"notifyUrl":             p.cnf.NotifyUrl,
+	}
+	if p.cnf.GatewayUrl == "" {
+		ts := strconv.FormatInt(p.now().Unix(), 10)
+		params := map[string]string{
+			"appId":                 p.cnf.AppId,
+			"externalTransactionId": order.ExternalTransactionId,
+			"amount":                strconv.FormatFloat(order.OrderAmount, 'f', 2, 64),
+			"notifyUrl":             p.cnf.NotifyUrl,
+			"timestamp":             ts,
+		}
+		params["sign"] = Sign(p.cnf.Secret, ts, []byte(params["appId"]+"|"+params["externalTransactionId"]+"|"+params["amount"]))
+		return &Checkout{Params: params}, nil
+	}
+	var resp Checkout
+	if err := p.do(http.MethodPost, "/orders", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (p *HmacProvider) VerifyCallback(r *http.Request, body []byte) (*Callback, error) {
+	ts := r.Header.Get(HeaderTimestamp)
+	sign := r.Header.Get(HeaderSignature)
+	if ts == "" || sign == "" {
+		return nil, ErrInvalidSignature
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if d := p.now().Sub(time.Unix(unix, 0)); d > callbackMaxSkew || d < -callbackMaxSkew {
+		return nil, errors.New("payment callback timestamp expired")
+	}
+	if !hmac.Equal([]byte(strings.ToLower(sign)), []byte(Sign(p.cnf.Secret, ts, body))) {
+		return nil, ErrInvalidSignature
+	}
+	cb := new(Callback)
+	if err := json.Unmarshal(body, cb); err != nil {
+		return nil, fmt.Errorf("parse payment callback: %v", err)
+	}
+	if cb.ExternalTransactionId == "" {
+		return nil, errors.New("payment callback without externalTransactionId")
+	}
+	if cb.Status == "" {
+		cb.Status = StatusPaid
+	}
+	return cb, nil
+}
+
+func (p *HmacProvider) QueryStatus(externalTransactionId string) (string, error) {
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := p.do(http.MethodGet, "/orders/"+url.PathEscape(externalTransactionId), nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.Status, nil
+}
+
+func (p *HmacProvider) Refund(order *file.Order, amount float64) error {
+	req := map[string]interface{}{
+		"appId":                 p.cnf.AppId,
+		"externalTransactionId": order.ExternalTransactionId,
+		"amount":                amount,
+	}
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := p.do(http.MethodPost, "/refunds", req, &resp); err != nil {
+		return err
+	}
+	if resp.Status != StatusRefunded {
+		return fmt.Errorf("refund %s: unexpected status %q", order.ExternalTransactionId, resp.Status)
+	}
+	return nil
+}
+
+// do 向支付网关发送签名请求，并校验响应的签名
+func (p *HmacProvider) do(method, path string, req, resp interface{}) error {
+	if p.cnf.GatewayUrl == "" {
+		return errors.New("payment_gateway_url is not configured")
+	}
+	var body []byte
+	if req != nil {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return err
+		}
+	}
+	r, err := http.NewRequest(method, p.cnf.GatewayUrl+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(p.now().Unix(), 10)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(HeaderTimestamp, ts)
+	r.Header.Set(HeaderSignature, Sign(p.cnf.Secret, ts, body))
+	res, err := p.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("payment gateway %s %s: %s", method, path, res.Status)
+	}
+	if !hmac.Equal([]byte(res.Header.Get(HeaderSignature)), []byte(Sign(p.cnf.Secret, res.Header.Get(HeaderTimestamp), data))) {
+		return errors.New("invalid payment gateway response signature")
+	}
+	return json.Unmarshal(data, resp)
+}
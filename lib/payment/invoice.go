@@ -0,0 +1,111 @@
+package payment
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+
+	"ehang.io/nps/lib/file"
+	"github.com/astaxie/beego"
+)
+
+// invoiceTpl 可直接打印的发票页面，不依赖外部样式与脚本
+var invoiceTpl = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Number}}</title>
+<style>
+body{font-family:sans-serif;max-width:720px;margin:40px auto;color:#333}
+table{width:100%;border-collapse:collapse;margin:24px 0}
+th,td{border:1px solid #ccc;padding:8px;text-align:left}
+.right{text-align:right}
+.refunded{color:#c00;font-weight:bold}
+@media print{.noprint{display:none}}
+</style>
+</head>
+<body>
+<h2>{{.Seller}} 收据</h2>
+<p>收据编号：{{.Number}}<br>
+订单号：{{.Order.ExternalTransactionId}}<br>
+下单时间：{{.Created}}<br>
+支付时间：{{.Paid}}<br>
+账号：{{.Account}}</p>
+{{if .Refunded}}<p class="refunded">该订单已于 {{.Refunded}} 退款</p>{{end}}
+<table>
+<tr><th>项目</th><th class="right">金额(元)</th></tr>
+<tr><td>{{.Item}}</td><td class="right">{{printf "%.2f" .Subtotal}}</td></tr>
+{{if .Order.Discount}}<tr><td>优惠码 {{.Order.CouponCode}}</td><td class="right">-{{printf "%.2f" .Order.Discount}}</td></tr>{{end}}
+{{if .Order.BonusFlow}}<tr><td>优惠码 {{.Order.CouponCode}} 赠送流量 {{.Order.BonusFlow}} GB</td><td class="right">0.00</td></tr>{{end}}
+<tr><th>合计</th><th class="right">{{printf "%.2f" .Order.OrderAmount}}</th></tr>
+</table>
+<button class="noprint" onclick="window.print()">打印</button>
+</body>
+</html>
+`))
+
+// Invoice 收据内容
+type Invoice struct {
+	Number   string
+	Seller   string
+	Account  string
+	Item     string
+	Created  string
+	Paid     string
+	Refunded string
+	Subtotal float64 // 优惠前金额
+	Order    *file.Order
+}
+
+// NewInvoice 根据已支付或已退款的订单及其入账记录生成收据，plan 为订单购买的套餐，可以为 nil
+func NewInvoice(o *file.Order, c *file.OrderCredit, account *file.Account, plan *file.Plan) (*Invoice, error) {
+	if c == nil || (o.OrderStatus != StatusPaid && o.OrderStatus != StatusRefunded) {
+		return nil, errors.New("invoices are only available for paid orders")
+	}
+	inv := &Invoice{
+		Number:   "INV-" + o.ExternalTransactionId,
+		Seller:   beego.AppConfig.DefaultString("invoice_seller", beego.AppConfig.DefaultString("appname", "nps")),
+		Account:  o.AccountId,
+		Created:  formatUnix(o.CreatedAt),
+		Paid:     formatUnix(c.CreatedAt),
+		Subtotal: roundCent(o.OrderAmount + o.Discount),
+		Order:    o,
+	}
+	if account != nil {
+		inv.Account = account.WebUserName
+	}
+	if c.RefundedAt > 0 {
+		inv.Refunded = formatUnix(c.RefundedAt)
+	}
+	switch {
+	case o.PlanId > 0:
+		name := "套餐 #" + strconv.Itoa(o.PlanId)
+		if plan != nil {
+			name = "套餐 " + plan.Name
+		}
+		inv.Item = name + "（" + strconv.FormatFloat(o.Flow, 'f', -1, 64) + " GB"
+		if o.Months > 0 {
+			inv.Item += "，" + strconv.Itoa(o.Months) + " 个月"
+		}
+		inv.Item += "）"
+	case o.PaymentType == "traffic":
+		inv.Item = "流量 " + strconv.FormatFloat(o.Flow, 'f', -1, 64) + " GB"
+	default:
+		inv.Item = "续费 " + strconv.Itoa(o.Months) + " 个月"
+	}
+	return inv, nil
+}
+
+// Render 输出可打印的 HTML 收据
+func (inv *Invoice) Render(w io.Writer) error {
+	return invoiceTpl.Execute(w, inv)
+}
+
+func formatUnix(t int64) string {
+	if t <= 0 {
+		return "-"
+	}
+	return time.Unix(t, 0).Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,79 @@
+package payment
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"ehang.io/nps/lib/file"
+)
+
+// MockProvider 本地模拟的支付渠道，订单状态保存在内存中，回调不校验签名，只在测试中使用，不注册为可配置的支付渠道
+type MockProvider struct {
+	sync.Mutex
+	appId  string
+	orders map[string]string
+	// Refunds 记录每个订单的退款金额
+	Refunds map[string]float64
+}
+
+func NewMockProvider(appId string) *MockProvider {
+	return &MockProvider{appId: appId, orders: make(map[string]string), Refunds: make(map[string]float64)}
+}
+
+func (p *MockProvider) Name() string {
+	return "mock"
+}
+
+func (p *MockProvider) AppId() string {
+	return p.appId
+}
+
+func (p *MockProvider) CreateOrder(order *file.Order) (*Checkout, error) {
+	p.Lock()
+	defer p.Unlock()
+	p.orders[order.ExternalTransactionId] = StatusPending
+	return &Checkout{PayUrl: "mock://pay/" + order.ExternalTransactionId}, nil
+}
+
+// Pay 模拟用户完成支付，返回可用作回调请求体的内容
+func (p *MockProvider) Pay(externalTransactionId string, amount float64) []byte {
+	p.Lock()
+	p.orders[externalTransactionId] = StatusPaid
+	p.Unlock()
+	body, _ := json.Marshal(&Callback{ExternalTransactionId: externalTransactionId, Status: StatusPaid, Amount: amount})
+	return body
+}
+
+func (p *MockProvider) VerifyCallback(r *http.Request, body []byte) (*Callback, error) {
+	cb := new(Callback)
+	if err := json.Unmarshal(body, cb); err != nil {
+		return nil, fmt.Errorf("parse payment callback: %v", err)
+	}
+	if cb.Status == "" {
+		cb.Status = StatusPaid
+	}
+	return cb, nil
+}
+
+func (p *MockProvider) QueryStatus(externalTransactionId string) (string, error) {
+	p.Lock()
+	defer p.Unlock()
+	status, ok := p.orders[externalTransactionId]
+	if !ok {
+		return "", fmt.Errorf("order %s not found", externalTransactionId)
+	}
+	return status, nil
+}
+
+func (p *MockProvider) Refund(order *file.Order, amount float64) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.orders[order.ExternalTransactionId] != StatusPaid {
+		return fmt.Errorf("order %s is not paid", order.ExternalTransactionId)
+	}
+	p.orders[order.ExternalTransactionId] = StatusRefunded
+	p.Refunds[order.ExternalTransactionId] += amount
+	return nil
+}
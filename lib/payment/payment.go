@@ -0,0 +1,153 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+
+	"ehang.io/nps/lib/file"
+	"github.com/astaxie/beego"
+)
+
+// 订单状态，与 file.Order.OrderStatus 一致
+const (
+	StatusPending  = file.OrderPending
+	StatusPaid     = file.OrderPaid
+	StatusFailed   = file.OrderFailed
+	StatusRefunded = file.OrderRefunded
+)
+
+// ErrNotConfigured 未配置支付渠道
+var ErrNotConfigured = errors.New("payment provider is not configured")
+
+// ErrInvalidSignature 回调签名校验失败
+var ErrInvalidSignature = errors.New("invalid payment callback signature")
+
+// Checkout 创建支付订单后返回给前端的支付信息
+type Checkout struct {
+	PayUrl string            `json:"payUrl,omitempty"` // 支付页面地址
+	Params map[string]string `json:"params,omitempty"` // 前端发起支付所需的参数
+}
+
+// Callback 支付渠道回调中经过校验的内容
+type Callback struct {
+	ExternalTransactionId string  `json:"externalTransactionId"` // 本系统的订单号
+	TransactionId         string  `json:"transactionId"`         // 支付渠道的交易号
+	Status                string  `json:"status"`
+	Amount                float64 `json:"amount"`
+}
+
+// Provider 支付渠道
+type Provider interface {
+	// Name 渠道名称
+	Name() string
+	// AppId 渠道分配的应用 id，记录在订单中
+	AppId() string
+	// CreateOrder 在支付渠道创建订单
+	CreateOrder(order *file.Order) (*Checkout, error)
+	// VerifyCallback 校验回调请求的签名并解析回调内容，body 为请求体
+	VerifyCallback(r *http.Request, body []byte) (*Callback, error)
+	// QueryStatus 查询订单在支付渠道的状态
+	QueryStatus(externalTransactionId string) (string, error)
+	// Refund 退款，amount 为退款金额
+	Refund(order *file.Order, amount float64) error
+}
+
+// Config 支付渠道配置
+type Config struct {
+	AppId      string
+	Secret     string
+	GatewayUrl string
+	NotifyUrl  string
+}
+
+var (
+	providers = make(map[string]func(cnf Config) (Provider, error))
+	current   Provider
+	currentMu sync.Mutex
+)
+
+// Register 注册支付渠道
+func Register(name string, f func(cnf Config) (Provider, error)) {
+	providers[name] = f
+}
+
+// New 按名称创建支付渠道
+func New(name string, cnf Config) (Provider, error) {
+	f, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment provider %s", name)
+	}
+	return f(cnf)
+}
+
+// Default 返回 nps.conf 中 payment_provider 配置的支付渠道
+func Default() (Provider, error) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	if current != nil {
+		return current, nil
+	}
+	name := beego.AppConfig.String("payment_provider")
+	if name == "" {
+		return nil, ErrNotConfigured
+	}
+	p, err := New(name, Config{
+		AppId:      beego.AppConfig.String("payment_app_id"),
+		Secret:     beego.AppConfig.String("payment_secret"),
+		GatewayUrl: beego.AppConfig.String("payment_gateway_url"),
+		NotifyUrl:  beego.AppConfig.String("payment_notify_url"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	current = p
+	return p, nil
+}
+
+// SetDefault 替换默认的支付渠道，传入 nil 时下次按配置重新创建
+func SetDefault(p Provider) {
+	currentMu.Lock()
+	current = p
+	currentMu.Unlock()
+}
+
+// Pricing 价格配置，单位为元
+type Pricing struct {
+	PerGB    float64 `json:"pricePerGB"`    // 每GB流量价格
+	PerMonth float64 `json:"pricePerMonth"` // 每月价格
+}
+
+// GetPricing 返回 nps.conf 中配置的价格
+func GetPricing() Pricing {
+	return Pricing{
+		PerGB:    beego.AppConfig.DefaultFloat("payment_price_per_gb", 0),
+		PerMonth: beego.AppConfig.DefaultFloat("payment_price_per_month", 0),
+	}
+}
+
+// Amount 计算订单金额，paymentType 为 traffic 时按流量计价，否则按月计价
+func (p Pricing) Amount(paymentType string, flow float64, months int) (float64, error) {
+	if paymentType == "traffic" {
+		if flow <= 0 || p.PerGB <= 0 {
+			return 0, errors.New("invalid traffic order")
+		}
+		return roundCent(flow * p.PerGB), nil
+	}
+	if months <= 0 || p.PerMonth <= 0 {
+		return 0, errors.New("invalid monthly order")
+	}
+	return roundCent(float64(months) * p.PerMonth), nil
+}
+
+// roundCent 金额保留到分
+func roundCent(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// AmountMatches 回调中的支付金额是否与订单金额一致，精确到分，缺少金额或金额为 0 时视为不一致
+func AmountMatches(paid, expected float64) bool {
+	return paid > 0 && roundCent(paid) == roundCent(expected)
+}
@@ -0,0 +1,279 @@
+package payment
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+func signedCallback(secret string, ts time.Time, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/index/paymentcallback", strings.NewReader(body))
+	t := strconv.FormatInt(ts.Unix(), 10)
+	r.Header.Set(HeaderTimestamp, t)
+	r.Header.Set(HeaderSignature, Sign(secret, t, []byte(body)))
+	return r
+}
+
+func TestHmacProviderCallback(t *testing.T) {
+	p, err := New("hmac", Config{AppId: "app", Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"externalTransactionId":"PAY1","status":"paid","amount":1.6}`
+	cb, err := p.VerifyCallback(signedCallback("secret", time.Now(), body), []byte(body))
+	if err != nil || cb.ExternalTransactionId != "PAY1" || cb.Status != StatusPaid || cb.Amount != 1.6 {
+		t.Fatalf("VerifyCallback %+v %v", cb, err)
+	}
+	if _, err := p.VerifyCallback(signedCallback("other", time.Now(), body), []byte(body)); err != ErrInvalidSignature {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+	if _, err := p.VerifyCallback(signedCallback("secret", time.Now().Add(-time.Hour), body), []byte(body)); err == nil {
+		t.Fatal("expired callback accepted")
+	}
+	tampered := strings.Replace(body, "1.6", "100", 1)
+	if _, err := p.VerifyCallback(signedCallback("secret", time.Now(), body), []byte(tampered)); err != ErrInvalidSignature {
+		t.Fatalf("tampered body accepted: %v", err)
+	}
+	if _, err := New("hmac", Config{}); err == nil {
+		t.Fatal("hmac provider without secret")
+	}
+}
+
+func TestHmacProviderGateway(t *testing.T) {
+	const secret = "secret"
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if Sign(secret, r.Header.Get(HeaderTimestamp), body) != r.Header.Get(HeaderSignature) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var resp interface{}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/orders":
+			resp = map[string]string{"payUrl": "https://pay.example.com/PAY1"}
+		case r.Method == http.MethodGet && r.URL.Path == "/orders/PAY1":
+			resp = map[string]string{"status": StatusPaid}
+		case r.Method == http.MethodPost && r.URL.Path == "/refunds":
+			resp = map[string]string{"status": StatusRefunded}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, _ := json.Marshal(resp)
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		w.Header().Set(HeaderTimestamp, ts)
+		w.Header().Set(HeaderSignature, Sign(secret, ts, data))
+		w.Write(data)
+	}))
+	defer gateway.Close()
+
+	p, err := New("hmac", Config{AppId: "app", Secret: secret, GatewayUrl: gateway.URL + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := &file.Order{ExternalTransactionId: "PAY1", OrderAmount: 1.6, PaymentType: "traffic"}
+	if c, err := p.CreateOrder(order); err != nil || c.PayUrl != "https://pay.example.com/PAY1" {
+		t.Fatalf("CreateOrder %+v %v", c, err)
+	}
+	if status, err := p.QueryStatus("PAY1"); err != nil || status != StatusPaid {
+		t.Fatalf("QueryStatus %s %v", status, err)
+	}
+	if err := p.Refund(order, 1.6); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.QueryStatus("PAY2"); err == nil {
+		t.Fatal("expected error for unknown order")
+	}
+
+	// 签名密钥不一致时网关拒绝请求
+	bad, _ := New("hmac", Config{Secret: "other", GatewayUrl: gateway.URL})
+	if _, err := bad.QueryStatus("PAY1"); err == nil {
+		t.Fatal("request with wrong secret accepted")
+	}
+}
+
+func TestMockProvider(t *testing.T) {
+	// 模拟渠道不校验回调签名，不能通过配置选择
+	if _, err := New("mock", Config{}); err == nil {
+		t.Fatal("mock provider must not be registered")
+	}
+	p := NewMockProvider("app")
+	order := &file.Order{ExternalTransactionId: "PAY1", OrderAmount: 8}
+	if _, err := p.CreateOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Refund(order, 8); err == nil {
+		t.Fatal("refund of unpaid order")
+	}
+	body := p.Pay("PAY1", 8)
+	cb, err := p.VerifyCallback(nil, body)
+	if err != nil || cb.ExternalTransactionId != "PAY1" || cb.Status != StatusPaid {
+		t.Fatalf("VerifyCallback %+v %v", cb, err)
+	}
+	if err := p.Refund(order, 8); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := p.QueryStatus("PAY1"); status != StatusRefunded || p.Refunds["PAY1"] != 8 {
+		t.Fatalf("status %s refunds %v", status, p.Refunds)
+	}
+}
+
+func TestPricingAmount(t *testing.T) {
+	p := Pricing{PerGB: 0.8, PerMonth: 10}
+	if v, err := p.Amount("traffic", 3, 0); err != nil || v != 2.4 {
+		t.Fatalf("traffic amount %v %v", v, err)
+	}
+	if v, err := p.Amount("monthly", 0, 6); err != nil || v != 60 {
+		t.Fatalf("monthly amount %v %v", v, err)
+	}
+	if _, err := p.Amount("traffic", 0, 0); err == nil {
+		t.Fatal("empty traffic order")
+	}
+	if _, err := (Pricing{}).Amount("monthly", 0, 1); err == nil {
+		t.Fatal("order without price")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	db, err := file.NewSqliteDb(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SqlDB.Close()
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	a := file.NewAccount()
+	a.WebUserName = "user"
+	if err := db.NewAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := db.GetByUsername("user")
+	p := NewMockProvider("app")
+	for _, id := range []string{"PAY1", "PAY2"} {
+		o := &file.Order{AccountId: strconv.Itoa(u.Id), Flow: 1, OrderStatus: StatusPending, PaymentType: "traffic", ExternalTransactionId: id}
+		if err := db.CreateOrder(o); err != nil {
+			t.Fatal(err)
+		}
+		p.CreateOrder(o)
+	}
+	// PAY1 已在支付渠道支付但回调丢失
+	p.Pay("PAY1", 0.8)
+	list, err := Reconcile(db, p, false)
+	if err != nil || len(list) != 1 || list[0].Order.ExternalTransactionId != "PAY1" {
+		t.Fatalf("Reconcile %v %v", list, err)
+	}
+	if list, err = Reconcile(db, p, true); err != nil || len(list) != 1 || !strings.HasSuffix(list[0].Reason, "settled") {
+		t.Fatalf("Reconcile settle %v %v", list, err)
+	}
+	if list, err = Reconcile(db, p, true); err != nil || len(list) != 0 {
+		t.Fatalf("orders still mismatched after settle: %v %v", list, err)
+	}
+	if info, _ := db.GetAccountInfo(u.Id); info.Flow.FlowLimit != 1024*1024 {
+		t.Fatalf("account flow %d", info.Flow.FlowLimit)
+	}
+}
+
+func TestInvoice(t *testing.T) {
+	o := &file.Order{ExternalTransactionId: "PAY1", OrderAmount: 9.9, Flow: 10, Months: 1, PlanId: 1, OrderStatus: StatusPending}
+	c := &file.OrderCredit{CreatedAt: time.Now().Unix()}
+	if _, err := NewInvoice(o, c, nil, nil); err == nil {
+		t.Fatal("invoice for unpaid order")
+	}
+	o.OrderStatus = StatusRefunded
+	c.RefundedAt = c.CreatedAt
+	inv, err := NewInvoice(o, c, &file.Account{WebUserName: "<user>"}, &file.Plan{Name: "basic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	if err := inv.Render(&buf); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	for _, want := range []string{"INV-PAY1", "&lt;user&gt;", "套餐 basic", "9.90", "已于"} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("invoice does not contain %q:\n%s", want, html)
+		}
+	}
+}
+
+func TestApplyCoupon(t *testing.T) {
+	db, err := file.NewSqliteDb(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.SqlDB.Close()
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, c := range []*file.Coupon{
+		{Code: "HALF", Kind: file.CouponPercent, Value: 50, Status: true},
+		{Code: "BIG", Kind: file.CouponFixed, Value: 100, Status: true},
+		{Code: "GIFT", Kind: file.CouponBonusFlow, Value: 5, Status: true},
+		{Code: "OLD", Kind: file.CouponFixed, Value: 1, Status: true, EndAt: now.Unix() - 1},
+		{Code: "OFF", Kind: file.CouponFixed, Value: 1},
+		{Code: "ONE", Kind: file.CouponFixed, Value: 1, Status: true, MaxUses: 1},
+	} {
+		if err := db.SaveCoupon(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.SqlDB.Exec("UPDATE coupons SET used = 1 WHERE code = 'ONE'")
+
+	o := &file.Order{OrderAmount: 9.99}
+	if err := ApplyCoupon(db, o, 1, "half", now); err != nil || o.OrderAmount != 4.99 || o.Discount != 5 || o.CouponCode != "HALF" {
+		t.Fatalf("percent coupon %+v %v", o, err)
+	}
+	o = &file.Order{OrderAmount: 8}
+	if err := ApplyCoupon(db, o, 1, "BIG", now); err != nil || o.OrderAmount != 0.01 || o.Discount != 7.99 {
+		t.Fatalf("fixed coupon %+v %v", o, err)
+	}
+	o = &file.Order{OrderAmount: 8}
+	if err := ApplyCoupon(db, o, 1, "GIFT", now); err != nil || o.OrderAmount != 8 || o.BonusFlow != 5 {
+		t.Fatalf("bonus coupon %+v %v", o, err)
+	}
+	for code, want := range map[string]error{"NONE": ErrCouponInvalid, "OLD": ErrCouponInvalid, "OFF": ErrCouponInvalid, "ONE": ErrCouponUsedUp} {
+		if err := ApplyCoupon(db, &file.Order{OrderAmount: 8}, 1, code, now); err != want {
+			t.Fatalf("coupon %s: expected %v, got %v", code, want, err)
+		}
+	}
+
+	// 同一账号只能使用一次
+	o.AccountId, o.ExternalTransactionId, o.OrderStatus, o.PaymentType, o.Flow = "1", "PAY1", StatusPaid, "traffic", 1
+	if err := db.CreateOrder(o); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyCoupon(db, &file.Order{OrderAmount: 8}, 1, "gift", now); err != ErrCouponUsed {
+		t.Fatalf("expected ErrCouponUsed, got %v", err)
+	}
+	if err := ApplyCoupon(db, &file.Order{OrderAmount: 8}, 2, "gift", now); err != nil {
+		t.Fatalf("other account: %v", err)
+	}
+}
+
+func TestAmountMatches(t *testing.T) {
+	for _, c := range []struct {
+		paid, expected float64
+		ok             bool
+	}{
+		{8, 8, true},
+		{8.1 + 0.2, 8.3, true},
+		{0, 8, false},
+		{7.99, 8, false},
+		{8.01, 8, false},
+		{-8, -8, false},
+	} {
+		if got := AmountMatches(c.paid, c.expected); got != c.ok {
+			t.Fatalf("AmountMatches(%v, %v) = %v, want %v", c.paid, c.expected, got, c.ok)
+		}
+	}
+}
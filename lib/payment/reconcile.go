@@ -0,0 +1,44 @@
+package payment
+
+import (
+	"ehang.io/nps/lib/file"
+	"github.com/astaxie/beego/logs"
+)
+
+// Reconcile 核对订单，返回本地订单状态与入账记录不一致的订单，以及在支付渠道已支付但本地未结算的订单，
+// p 为 nil 时不查询支付渠道，settle 为 true 时对支付渠道已支付的订单补做结算
+func Reconcile(store file.SettleStore, p Provider, settle bool) ([]*file.OrderMismatch, error) {
+	list, err := store.ReconcileOrders()
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return list, nil
+	}
+	for _, status := range []string{StatusPending, StatusFailed} {
+		orders, err := store.GetOrdersByStatus(status, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range orders {
+			remote, err := p.QueryStatus(o.ExternalTransactionId)
+			if err != nil {
+				logs.Warn("query payment status of order %s error: %s", o.ExternalTransactionId, err.Error())
+				continue
+			}
+			if remote != StatusPaid {
+				continue
+			}
+			m := &file.OrderMismatch{Order: o, Reason: "paid at " + p.Name() + " but order is " + o.OrderStatus}
+			if settle {
+				if _, err := store.SettleOrder(o.ExternalTransactionId); err != nil {
+					m.Reason += ", settle error: " + err.Error()
+				} else {
+					m.Reason += ", settled"
+				}
+			}
+			list = append(list, m)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"time"
+
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/goroutine"
+	"github.com/astaxie/beego/logs"
+)
+
+// allowanceSession 定期重置进入新周期的账号的每月流量额度
+func allowanceSession() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		resetAllowances(now)
+	}
+}
+
+func resetAllowances(now time.Time) {
+	ids, err := file.GetDb().ResetAllowances(now)
+	if err != nil {
+		logs.Error("reset traffic allowance error %s", err)
+	}
+	for _, id := range ids {
+		logs.Info("traffic allowance of account %d reset", id)
+		goroutine.TrafficManager.ResetFlowLimit(id)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/goroutine"
+	"ehang.io/nps/lib/notify"
+	"ehang.io/nps/server/proxy"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+)
+
+// expiryWarned 已发出到期提醒的账号及提醒时的到期时间，续费后到期时间变化会重新提醒
+var expiryWarned sync.Map
+
+// accountExpirySession 定期检查账号到期情况
+func accountExpirySession(n *notify.Notifier) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		checkAccountExpiry(n, now)
+	}
+}
+
+// checkAccountExpiry 停止到期账号的隧道并断开其客户端，账号续费后自动恢复，临近到期与到期时通知账号
+func checkAccountExpiry(n *notify.Notifier, now time.Time) {
+	accounts, err := file.GetDb().GetAllAccounts()
+	if err != nil {
+		logs.Error("check account expiry error", err)
+		return
+	}
+	warnDays := beego.AppConfig.DefaultInt("account_expire_warn_days", 3)
+	for _, a := range accounts {
+		expired := a.IsExpired(now)
+		switch {
+		case expired && !goroutine.IsAccountExpired(a.Id):
+			logs.Warn("account %d %s expired at %s, stop its tunnels and clients", a.Id, a.WebUserName, a.ExpireTime)
+			goroutine.SetAccountExpired(a.Id, true)
+			suspendAccount(a.Id)
+			notifyAccountExpiry(n, a, true)
+		case !expired && goroutine.IsAccountExpired(a.Id):
+			logs.Info("account %d %s renewed until %s, restart its tunnels", a.Id, a.WebUserName, a.ExpireTime)
+			goroutine.SetAccountExpired(a.Id, false)
+			resumeAccount(a.Id)
+		}
+		if t, ok := a.ExpireAt(); ok && !expired && warnDays > 0 && t.Sub(now) < time.Duration(warnDays)*24*time.Hour {
+			if v, ok := expiryWarned.Load(a.Id); !ok || v.(string) != a.ExpireTime {
+				expiryWarned.Store(a.Id, a.ExpireTime)
+				logs.Warn("account %d %s will expire at %s", a.Id, a.WebUserName, a.ExpireTime)
+				notifyAccountExpiry(n, a, false)
+			}
+		}
+	}
+}
+
+// notifyAccountExpiry 账号到期或进入提醒期时立即通知，与定期的通知检查共用发送记录，不会重复发送
+func notifyAccountExpiry(n *notify.Notifier, a *file.Account, expired bool) {
+	db := file.GetDb()
+	s, err := db.GetNotifySetting(a.Id)
+	if err != nil {
+		logs.Error("get notify setting of account %d error %s", a.Id, err)
+		return
+	}
+	sendExpiryNotice(db, n, s, a, expired)
+}
+
+// accountTasks 返回属于账号的隧道与域名解析，未设置账号的按所属客户端的账号计算
+func accountTasks(accountId int) (tasks []*file.Tunnel, clients []int) {
+	all, err := file.GetDb().GetAllClients()
+	if err != nil {
+		logs.Error("get clients of account %d error %s", accountId, err)
+		return
+	}
+	owner := make(map[int]int, len(all))
+	for _, c := range all {
+		owner[c.Id] = c.AccountId
+		if c.AccountId == accountId {
+			clients = append(clients, c.Id)
+		}
+	}
+	list, err := file.GetDb().GetAllTasks()
+	if err != nil {
+		logs.Error("get tasks of account %d error %s", accountId, err)
+		return
+	}
+	for _, t := range list {
+		if t.AccountId == accountId || (t.AccountId == 0 && owner[t.ClientId] == accountId) {
+			tasks = append(tasks, t)
+		}
+	}
+	return
+}
+
+// isTaskExpired 隧道所属账号是否已到期
+func isTaskExpired(t *file.Tunnel) bool {
+	accountId := t.AccountId
+	if accountId == 0 {
+		if c, err := file.GetDb().GetClient(t.ClientId); err == nil {
+			accountId = c.AccountId
+		}
+	}
+	return goroutine.IsAccountExpired(accountId)
+}
+
+// suspendAccount 关闭账号正在运行的隧道并断开其客户端，隧道的启用状态保持不变以便续费后恢复
+func suspendAccount(accountId int) {
+	tasks, clients := accountTasks(accountId)
+	for _, t := range tasks {
+		if v, ok := RunList.Load(t.Id); ok {
+			if svr, ok := v.(proxy.Service); ok {
+				if err := svr.Close(); err != nil {
+					logs.Warn("suspend task id %d error %s", t.Id, err)
+				}
+			}
+			RunList.Delete(t.Id)
+		}
+	}
+	for _, id := range clients {
+		if Bridge != nil {
+			Bridge.DelClient(id)
+		}
+	}
+}
+
+// resumeAccount 重新启动账号已启用但未运行的隧道，客户端会自行重连
+func resumeAccount(accountId int) {
+	tasks, _ := accountTasks(accountId)
+	for _, t := range tasks {
+		if _, ok := RunList.Load(t.Id); ok || t.Mode == "" {
+			continue
+		}
+		if err := AddTask(t); err != nil {
+			logs.Error("resume task id %d error %s", t.Id, err)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"time"
+
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/lockout"
+	"github.com/astaxie/beego/logs"
+)
+
+// loginSessionCleanup 定期删除过期一天以上或退出一天以上的登录会话，以及锁定次数已经清零的登录失败记录
+func loginSessionCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if n, err := file.GetDb().DeleteExpiredLoginSessions(now.Add(-24 * time.Hour)); err != nil {
+			logs.Error("delete expired login sessions error %s", err)
+		} else if n > 0 {
+			logs.Info("%d expired login sessions deleted", n)
+		}
+		if _, err := file.GetDb().DeleteStaleLoginLocks(now.Add(-lockout.PolicyFromConfig().ResetAfter)); err != nil {
+			logs.Error("delete stale login locks error %s", err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/notify"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+)
+
+// notifySession 定期检查账号的流量额度与到期情况并发送通知
+func notifySession() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	n := notify.FromConfig()
+	for now := range ticker.C {
+		checkNotifications(file.GetDb(), n, now)
+	}
+}
+
+// checkNotifications 向达到流量额度阈值或临近到期的账号发送通知，
+// 每个阈值在一个周期内只发送一次，额度重置后使用率回落到阈值以下时重新启用
+func checkNotifications(db file.Store, n *notify.Notifier, now time.Time) {
+	accounts, err := db.GetAllAccounts()
+	if err != nil {
+		logs.Error("check notifications error %s", err)
+		return
+	}
+	warnDays := beego.AppConfig.DefaultInt("account_expire_warn_days", 3)
+	for _, a := range accounts {
+		setting, err := db.GetNotifySetting(a.Id)
+		if err != nil {
+			logs.Error("get notify setting of account %d error %s", a.Id, err)
+			continue
+		}
+		if !setting.Enabled {
+			continue
+		}
+		pct, ok := notify.QuotaUsage(a)
+		for _, p := range setting.ThresholdList() {
+			key := "quota:" + strconv.Itoa(p)
+			if !ok || pct < p {
+				db.ClearNotified(a.Id, key)
+				continue
+			}
+			sendOnce(db, n, setting, key, notify.QuotaEvent(a, p))
+		}
+		if t, ok := a.ExpireAt(); ok {
+			if a.IsExpired(now) {
+				sendExpiryNotice(db, n, setting, a, true)
+			} else if warnDays > 0 && t.Sub(now) < time.Duration(warnDays)*24*time.Hour {
+				sendExpiryNotice(db, n, setting, a, false)
+			}
+		}
+	}
+}
+
+// sendExpiryNotice 向开启了到期提醒的账号发送即将到期或已到期的通知，同一到期时间只发送一次
+func sendExpiryNotice(db file.Store, n *notify.Notifier, s *file.NotifySetting, a *file.Account, expired bool) {
+	if !s.Enabled || !s.Expiry {
+		return
+	}
+	key := "expiring:" + a.ExpireTime
+	if expired {
+		key = "expired:" + a.ExpireTime
+	}
+	sendOnce(db, n, s, key, notify.ExpiryEvent(a, expired))
+}
+
+// sendOnce 发送未发送过的通知，发送失败时删除记录以便下次重试
+func sendOnce(db file.Store, n *notify.Notifier, s *file.NotifySetting, key string, e *notify.Event) {
+	first, err := db.MarkNotified(s.AccountId, key)
+	if err != nil {
+		logs.Error("mark notification %s of account %d error %s", key, s.AccountId, err)
+		return
+	}
+	if !first {
+		return
+	}
+	if err := n.Notify(s, e); err != nil {
+		logs.Warn("send notification %s to account %d error %s", key, s.AccountId, err)
+		db.ClearNotified(s.AccountId, key)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"ehang.io/nps/lib/common"
 	"ehang.io/nps/lib/conn"
 	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/goroutine"
 	"github.com/astaxie/beego/logs"
 )
 
@@ -112,6 +113,15 @@ func (s *BaseServer) DealClient(c *conn.Conn, client *file.Client, addr string,
 		return nil
 	}
 
+	// 账号到期或流量用尽时拒绝连接
+	accountId := s.accountOf(client, task)
+	if err := goroutine.CheckAccount(accountId); err != nil {
+		logs.Info("account %d refused: %s, client id %d, remote address %s", accountId, err.Error(), client.Id, c.RemoteAddr().String())
+		c.Close()
+		return err
+	}
+	goroutine.TrafficManager.Debit(accountId, int64(len(rb)))
+
 	link := conn.NewLink(tp, addr, client.Cnf.Crypt, client.Cnf.Compress, c.Conn.RemoteAddr().String(), localProxy)
 	if target, err := s.bridge.SendLinkInfo(client.Id, link, s.task); err != nil {
 		logs.Warn("get connection from client id %d  error %s", client.Id, err.Error())
@@ -121,11 +131,22 @@ func (s *BaseServer) DealClient(c *conn.Conn, client *file.Client, addr string,
 		if f != nil {
 			f()
 		}
-		conn.CopyWaitGroup(target, c.Conn, link.Crypt, link.Compress, client.Rate, flow, true, rb, task)
+		conn.CopyWaitGroup(target, goroutine.NewQuotaConn(c.Conn, accountId), link.Crypt, link.Compress, client.Rate, flow, true, rb, task)
 	}
 	return nil
 }
 
+// accountOf 返回承担流量的账号，依次取隧道、服务所属隧道与客户端的账号
+func (s *BaseServer) accountOf(client *file.Client, task *file.Tunnel) int {
+	if task != nil && task.AccountId > 0 {
+		return task.AccountId
+	}
+	if s.task != nil && s.task.AccountId > 0 {
+		return s.task.AccountId
+	}
+	return client.AccountId
+}
+
 // 判断访问地址是否在全局黑名单内
 func IsGlobalBlackIp(ipPort string) bool {
 	// 判断访问地址是否在全局黑名单内
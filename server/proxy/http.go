@@ -184,10 +184,8 @@ reset:
 		return
 	}
 
-	// <<20 = 1024 * 1024
-	flowLimit := goroutine.TrafficManager.GetFlowLimitFromCache(host.AccountId)
-	if flowLimit <= 0 {
-		logs.Info("流量已经超出限制")
+	if err = goroutine.CheckAccount(host.AccountId); err != nil {
+		logs.Info("account %d refused: %s, host %s", host.AccountId, err.Error(), r.Host)
 		c.Close()
 		return
 	}
@@ -217,6 +215,7 @@ reset:
 		return
 	}
 	connClient = conn.GetConn(target, lk.Crypt, lk.Compress, host.Client.Rate, true)
+	goroutine.TrafficHistory.AddHost(host, 0, 0, 1)
 
 	//read from inc-client
 	go func() {
@@ -230,7 +229,7 @@ reset:
 			}
 		}()
 
-		err1 := goroutine.CopyBuffer(c, connClient, host.Client.Flow, nil, "")
+		err1 := goroutine.CopyBuffer(goroutine.NewHostWriter(c, host), connClient, host.Client.Flow, nil, "")
 		if err1 != nil {
 			return
 		}
@@ -247,6 +246,7 @@ reset:
 				//break
 				return
 			}
+			goroutine.TrafficHistory.AddHost(host, 0, int64(lenConn.Len), 0)
 		}
 	}()
 
@@ -260,6 +260,7 @@ reset:
 				}
 				logs.Trace("%s request, method %s, host %s, url %s, remote address %s, return cache", r.URL.Scheme, r.Method, r.Host, r.URL.Path, c.RemoteAddr().String())
 				goroutine.TrafficManager.AccumulateTrafficData(host.AccountId, int64(n+n))
+				goroutine.TrafficHistory.AddHost(host, 0, int64(n), 0)
 				//if return cache and does not create a new conn with client and Connection is not set or close, close the connection.
 				if strings.ToLower(r.Header.Get("Connection")) == "close" || strings.ToLower(r.Header.Get("Connection")) == "" {
 					break
@@ -281,6 +282,7 @@ reset:
 			break
 		}
 		goroutine.TrafficManager.AccumulateTrafficData(host.AccountId, int64(lenConn.Len+lenConn.Len))
+		goroutine.TrafficHistory.AddHost(host, int64(lenConn.Len), 0, 0)
 
 	readReq:
 		//read req from connection
@@ -266,14 +266,6 @@ func (https *HttpsServer) handleHttps2(c net.Conn, hostName string, rb []byte, r
 		logs.Debug("the url %s can't be parsed!", hostName)
 		return
 	}
-	logs.Debug("the url %s GetFlowLimitFromCache start!", hostName)
-	flowLimit := goroutine.TrafficManager.GetFlowLimitFromCache(host.AccountId)
-	logs.Debug("the url %s GetFlowLimitFromCache end! ， %d", hostName, flowLimit)
-	if flowLimit <= 0 {
-		logs.Info("流量已经超出限制")
-		c.Close()
-		return
-	}
 	defer host.Client.AddConn()
 	if err = https.auth(r, conn.NewConn(c), host.Client.Cnf.U, host.Client.Cnf.P); err != nil {
 		logs.Warn("auth error", err, r.RemoteAddr)
@@ -283,7 +275,7 @@ func (https *HttpsServer) handleHttps2(c net.Conn, hostName string, rb []byte, r
 		logs.Warn(err.Error())
 	}
 	logs.Info("new https connection,clientId %d,host %s,remote address %s", host.Client.Id, r.Host, c.RemoteAddr().String())
-	https.DealClient(conn.NewConn(c), host.Client, targetAddr, rb, common.CONN_TCP, nil, host.Client.Flow, host.Target.LocalProxy, nil)
+	https.DealClient(conn.NewConn(goroutine.NewHostConn(c, host)), host.Client, targetAddr, rb, common.CONN_TCP, nil, host.Client.Flow, host.Target.LocalProxy, nil)
 }
 
 // close
@@ -325,7 +317,7 @@ func (https *HttpsServer) handleHttps(c net.Conn) {
 		logs.Warn(err.Error())
 	}
 	logs.Trace("new https connection,clientId %d,host %s,remote address %s", host.Client.Id, r.Host, c.RemoteAddr().String())
-	https.DealClient(conn.NewConn(c), host.Client, targetAddr, rb, common.CONN_TCP, nil, host.Client.Flow, host.Target.LocalProxy, nil)
+	https.DealClient(conn.NewConn(goroutine.NewHostConn(c, host)), host.Client, targetAddr, rb, common.CONN_TCP, nil, host.Client.Flow, host.Target.LocalProxy, nil)
 }
 
 type HttpsListener struct {
@@ -11,6 +11,7 @@ import (
 	"ehang.io/nps/lib/common"
 	"ehang.io/nps/lib/conn"
 	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/goroutine"
 	"github.com/astaxie/beego/logs"
 )
 
@@ -64,17 +65,30 @@ func (s *UdpModeServer) Start() error {
 }
 
 func (s *UdpModeServer) process(addr *net.UDPAddr, data []byte) {
+	accountId := s.accountOf(s.task.Client, s.task)
 	if v, ok := s.addrMap.Load(addr.String()); ok {
 		clientConn, ok := v.(io.ReadWriteCloser)
 		if ok {
+			// 账号到期或流量用尽时关闭会话，读取回包的协程随之退出
+			goroutine.TrafficManager.Debit(accountId, int64(len(data)))
+			if err := goroutine.CheckAccount(accountId); err != nil {
+				logs.Info("account %d refused: %s, udp task id %d, remote address %s", accountId, err.Error(), s.task.Id, addr)
+				clientConn.Close()
+				return
+			}
 			_, err := clientConn.Write(data)
 			if err != nil {
 				logs.Warn(err)
 				return
 			}
 			s.task.Client.Flow.Add(int64(len(data)), int64(len(data)))
+			goroutine.TrafficHistory.AddTunnel(s.task, int64(len(data)), 0, 0)
 		}
 	} else {
+		if err := goroutine.CheckAccount(accountId); err != nil {
+			logs.Info("account %d refused: %s, udp task id %d, remote address %s", accountId, err.Error(), s.task.Id, addr)
+			return
+		}
 		if err := s.CheckFlowAndConnNum(s.task.Client); err != nil {
 			logs.Warn("client id %d, task id %d,error %s, when udp connection", s.task.Client.Id, s.task.Id, err.Error())
 			return
@@ -98,6 +112,8 @@ func (s *UdpModeServer) process(addr *net.UDPAddr, data []byte) {
 			defer common.BufPoolUdp.Put(buf)
 
 			s.task.Client.Flow.Add(int64(len(data)), int64(len(data)))
+			goroutine.TrafficHistory.AddTunnel(s.task, int64(len(data)), 0, 1)
+			goroutine.TrafficManager.Debit(accountId, int64(len(data)))
 			for {
 				clientConn.SetReadDeadline(time.Now().Add(time.Duration(60) * time.Second))
 				if n, err := target.Read(buf); err != nil {
@@ -111,6 +127,13 @@ func (s *UdpModeServer) process(addr *net.UDPAddr, data []byte) {
 						return
 					}
 					s.task.Client.Flow.Add(int64(n), int64(n))
+					goroutine.TrafficHistory.AddTunnel(s.task, 0, int64(n), 0)
+					goroutine.TrafficManager.Debit(accountId, int64(n))
+					if err := goroutine.CheckAccount(accountId); err != nil {
+						s.addrMap.Delete(addr.String())
+						logs.Info("account %d refused: %s, udp task id %d, remote address %s", accountId, err.Error(), s.task.Id, addr)
+						return
+					}
 				}
 				//if err := s.CheckFlowAndConnNum(s.task.Client); err != nil {
 				//	logs.Warn("client id %d, task id %d,error %s, when udp connection", s.task.Client.Id, s.task.Id, err.Error())
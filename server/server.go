@@ -4,9 +4,7 @@ import (
 	"errors"
 	"math"
 	"os"
-	"sort"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -15,6 +13,8 @@ import (
 	"ehang.io/nps/bridge"
 	"ehang.io/nps/lib/common"
 	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/goroutine"
+	"ehang.io/nps/lib/notify"
 	"ehang.io/nps/server/proxy"
 	"ehang.io/nps/server/tool"
 	"github.com/astaxie/beego"
@@ -53,7 +53,7 @@ func InitFromCsv() {
 		return
 	}
 	for _, task := range tasks {
-		if task.Status {
+		if task.Status && !isTaskExpired(task) {
 			AddTask(task)
 		}
 	}
@@ -109,6 +109,17 @@ func StartNewServer(bridgePort int, cnf *file.Tunnel, bridgeType string, bridgeD
 	}
 	go DealBridgeTask()
 	go dealClientFlow()
+	// 启动隧道前先标记已到期的账号
+	expiryNotifier := notify.FromConfig()
+	checkAccountExpiry(expiryNotifier, time.Now())
+	go accountExpirySession(expiryNotifier)
+	resetAllowances(time.Now())
+	go allowanceSession()
+	go notifySession()
+	go loginSessionCleanup()
+	if minute, err := beego.AppConfig.Int("flow_store_interval"); err == nil && minute > 0 {
+		go flowSession(time.Minute * time.Duration(minute))
+	}
 	if svr := NewMode(Bridge, cnf); svr != nil {
 		if err := svr.Start(); err != nil {
 			logs.Error(err)
@@ -203,9 +214,6 @@ func AddTask(t *file.Tunnel) error {
 		logs.Error("taskId %d start error port %d open failed", t.Id, t.Port)
 		return errors.New("the port open error")
 	}
-	if minute, err := beego.AppConfig.Int("flow_store_interval"); err == nil && minute > 0 {
-		go flowSession(time.Minute * time.Duration(minute))
-	}
 	if svr := NewMode(Bridge, t); svr != nil {
 		logs.Info("tunnel task %s start mode：%s port %d", t.Remark, t.Mode, t.Port)
 		//RunList[t.Id] = svr
@@ -249,89 +257,21 @@ func DelTask(id int) error {
 
 // get task list by page num
 func GetTunnel(start, length int, typeVal string, clientId int, search string, sortField string, order string) ([]*file.Tunnel, int) {
-	all_list := make([]*file.Tunnel, 0) // store all Tunnel
-	list := make([]*file.Tunnel, 0)
-	var cnt int
-	tasks, err := file.GetDb().GetAllTasks()
+	list, cnt, err := file.GetDb().GetTunnelList(start, length, typeVal, clientId, search, sortField, order)
 	if err != nil {
 		logs.Error("Failed to get tasks:", err)
 		return nil, 0
 	}
-
-	// filter tasks
-	for _, v := range tasks {
-		// 确保Client、Target和Flow对象已初始化
-		if v.Client == nil {
-			v.Client = &file.Client{Id: v.ClientId}
-		}
-		if v.Target == nil {
-			v.Target = &file.Target{TargetStr: ""}
-		}
-		if v.Flow == nil {
-			v.Flow = &file.Flow{}
-		}
-
-		if (typeVal != "" && v.Mode != typeVal || (clientId != 0 && v.Client.Id != clientId)) || (typeVal == "" && clientId != v.Client.Id) {
-			continue
-		}
-		all_list = append(all_list, v)
-	}
-	// sort by Id, Remark, TargetStr, Port, asc or desc
-	if sortField == "Id" {
-		if order == "asc" {
-			sort.SliceStable(all_list, func(i, j int) bool { return all_list[i].Id < all_list[j].Id })
-		} else {
-			sort.SliceStable(all_list, func(i, j int) bool { return all_list[i].Id > all_list[j].Id })
-		}
-	} else if sortField == "ClientId" {
-		if order == "asc" {
-			sort.SliceStable(all_list, func(i, j int) bool { return all_list[i].Client.Id < all_list[j].Client.Id })
-		} else {
-			sort.SliceStable(all_list, func(i, j int) bool { return all_list[i].Client.Id > all_list[j].Client.Id })
-		}
-	} else if sortField == "Remark" {
-		if order == "asc" {
-			sort.SliceStable(all_list, func(i, j int) bool { return all_list[i].Remark < all_list[j].Remark })
-		} else {
-			sort.SliceStable(all_list, func(i, j int) bool { return all_list[i].Remark > all_list[j].Remark })
-		}
-	} else if sortField == "Client.VerifyKey" {
-		if order == "asc" {
-			sort.SliceStable(all_list, func(i, j int) bool { return all_list[i].Client.VerifyKey < all_list[j].Client.VerifyKey })
-		} else {
-			sort.SliceStable(all_list, func(i, j int) bool { return all_list[i].Client.VerifyKey > all_list[j].Client.VerifyKey })
-		}
-	} else if sortField == "Target" {
-		if order == "asc" {
-			sort.SliceStable(all_list, func(i, j int) bool { return all_list[i].Target.TargetStr < all_list[j].Target.TargetStr })
-		} else {
-			sort.SliceStable(all_list, func(i, j int) bool { return all_list[i].Target.TargetStr > all_list[j].Target.TargetStr })
-		}
-	}
-
-	// search
-	for _, v := range all_list {
-		if (typeVal != "" && v.Mode != typeVal || (clientId != 0 && v.Client.Id != clientId)) || (typeVal == "" && clientId != v.Client.Id) {
-			continue
-		}
-		if search != "" && !(v.Id == common.GetIntNoErrByStr(search) || v.Port == common.GetIntNoErrByStr(search) || strings.Contains(v.Password, search) || strings.Contains(v.Remark, search) || strings.Contains(v.Target.TargetStr, search)) {
-			continue
-		}
-		cnt++
+	for _, v := range list {
 		if _, ok := Bridge.Client.Load(v.Client.Id); ok {
 			v.Client.IsConnect = true
 		} else {
 			v.Client.IsConnect = false
 		}
-		if start--; start < 0 {
-			if length--; length >= 0 {
-				if _, ok := RunList.Load(v.Id); ok {
-					v.RunStatus = true
-				} else {
-					v.RunStatus = false
-				}
-				list = append(list, v)
-			}
+		if _, ok := RunList.Load(v.Id); ok {
+			v.RunStatus = true
+		} else {
+			v.RunStatus = false
 		}
 	}
 	return list, cnt
@@ -458,6 +398,9 @@ func DelTunnelAndHostByClientId(clientId int, justDelNoStore bool) {
 	for _, id := range ids {
 		file.GetDb().DelHost(id)
 	}
+	if len(ids) > 0 {
+		goroutine.TrafficManager.InvalidateHosts()
+	}
 }
 
 // close the client
@@ -578,16 +521,37 @@ func GetDashboardData() map[string]interface{} {
 	return data
 }
 
-// 定时更新流量数据到MySQL
+// flowSession 按 m 的间隔将内存中累计的流量写入流量历史
 func flowSession(m time.Duration) {
 	ticker := time.NewTicker(m)
 	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			// MySQL会自动持久化数据，这里只需要确保连接正常
-			// 可以添加一些必要的定期更新逻辑
-			logs.Info("Periodic data update check at", time.Now().Format("2006-01-02 15:04:05"))
+	for now := range ticker.C {
+		storeTrafficHistory(now.Add(-m).Truncate(m))
+	}
+}
+
+// 流量历史各精度的保留天数配置及默认值
+var trafficRetention = []struct {
+	resolution string
+	key        string
+	days       int
+}{
+	{file.TrafficRaw, "flow_retention_raw", 2},
+	{file.TrafficHour, "flow_retention_hour", 31},
+	{file.TrafficDay, "flow_retention_day", 366},
+}
+
+// storeTrafficHistory 将内存中累计的流量作为 bucket 时间段的采样写入流量历史，并清理超出保留期限的数据
+func storeTrafficHistory(bucket time.Time) {
+	if err := file.GetDb().AddTrafficSamples(goroutine.TrafficHistory.Flush(bucket)); err != nil {
+		logs.Error("store traffic history error", err)
+	}
+	now := time.Now()
+	for _, v := range trafficRetention {
+		if days := beego.AppConfig.DefaultInt(v.key, v.days); days > 0 {
+			if _, err := file.GetDb().PruneTrafficSamples(v.resolution, now.AddDate(0, 0, -days).Unix()); err != nil {
+				logs.Error("prune traffic history error", err)
+			}
 		}
 	}
 }
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"strings"
+
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/goroutine"
+)
+
+type AccountController struct {
+	BaseController
+}
+
+// manageable 当前用户是否可以查看或管理账号，全局角色可以管理所有账号，代理商只能管理自己创建的账号；
+// 权限高于当前用户的账号（例如运维人员之于管理员）不能管理，避免修改其密码后登录取得更高的权限
+func (s *AccountController) manageable(a *file.Account) bool {
+	if !file.RoleCovers(s.role(), a.Role) {
+		return false
+	}
+	if s.GetSession("isAdmin") == true {
+		return true
+	}
+	self := s.GetSessionIntNoErr("accountId", 0)
+	return s.role() == file.RoleReseller && self != 0 && a.ResellerId == self
+}
+
+// 账号列表，普通用户只能看到自己的账号
+func (s *AccountController) List() {
+	accounts, err := file.GetDb().GetAllAccounts()
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	self := s.GetSessionIntNoErr("accountId", 0)
+	search := s.getEscapeString("search")
+	list := make([]*file.Account, 0)
+	for _, a := range accounts {
+		if a.Id != self && !s.manageable(a) {
+			continue
+		}
+		if search != "" && !strings.Contains(a.WebUserName, search) && !strings.Contains(a.Remark, search) {
+			continue
+		}
+		a.WebPassword = ""
+		list = append(list, a)
+	}
+	cnt := len(list)
+	start, length := s.GetAjaxParams()
+	if start > cnt {
+		start = cnt
+	}
+	if length > 0 && start+length < cnt {
+		list = list[start : start+length]
+	} else {
+		list = list[start:]
+	}
+	s.AjaxTable(list, cnt, cnt, nil)
+}
+
+// 新增或修改账号，id 为 0 时新增，password 为空时不修改密码，未提交的字段不修改；
+// 代理商新增的账号归属于自己，流量、到期时间与每月额度只有全局角色可以修改
+func (s *AccountController) Save() {
+	id := s.GetIntNoErr("id")
+	global := s.GetSession("isAdmin") == true
+	a := file.NewAccount()
+	if id > 0 {
+		var err error
+		if a, err = file.GetDb().GetAccountInfo(id); err != nil {
+			s.AjaxErr(err.Error())
+		}
+		if !s.manageable(a) {
+			s.Forbidden()
+		}
+	} else if !global {
+		a.ResellerId = s.GetSessionIntNoErr("accountId", 0)
+	}
+	a.WebUserName = strings.TrimSpace(s.GetString("username"))
+	if a.WebUserName == "" || !file.GetDb().VerifyUserName(a.WebUserName, id) {
+		s.AjaxErr("username is empty or already exists")
+	}
+	if password := s.GetString("password"); password != "" {
+		a.WebPassword = password
+	} else if id == 0 {
+		s.AjaxErr("password is required")
+	}
+	// 未提交的字段保持原值，只修改备注或密码时不会清空流量、到期时间与每月额度
+	if s.hasParam("remark") {
+		a.Remark = s.getEscapeString("remark")
+	}
+	a.Status = s.GetBoolNoErr("status", a.Status)
+	if global {
+		a.Flow.FlowLimit = int64(s.GetIntNoErr("flow", int(a.Flow.FlowLimit)))
+		if s.hasParam("expire_time") {
+			a.ExpireTime = s.GetString("expire_time")
+		}
+		a.Allowance = int64(s.GetIntNoErr("allowance", int(a.Allowance)))
+		a.ResetDay = s.GetIntNoErr("reset_day", a.ResetDay)
+	}
+	if id == 0 {
+		if err := file.GetDb().NewAccount(a); err != nil {
+			s.AjaxErr(err.Error())
+		}
+	}
+	if err := file.GetDb().UpdateAccount(a); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	goroutine.TrafficManager.ResetFlowLimit(a.Id)
+	s.AjaxOkWithId("save success", a.Id)
+}
+
+// 修改账号的角色与所属代理商，修改后该账号重新登录生效
+func (s *AccountController) Role() {
+	if err := file.GetDb().SetAccountRole(s.GetIntNoErr("id"), s.GetString("role"), s.GetIntNoErr("reseller_id")); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("save success")
+}
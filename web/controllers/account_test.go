@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+
+	"ehang.io/nps/lib/crypt"
+	"ehang.io/nps/lib/file"
+)
+
+func TestOperatorCanNotEditAdmin(t *testing.T) {
+	db := newTestDb(t)
+	operator := newTestAccount(t, db, "operator", "password", file.RoleOperator)
+	admin := newTestAccount(t, db, "root", "password", file.RoleAdmin)
+	owner := newTestAccount(t, db, "alice", "password", file.RoleOwner)
+	session := map[string]interface{}{"auth": true, "username": operator.WebUserName, "accountId": operator.Id, "clientId": operator.Id, "role": file.RoleOperator, "isAdmin": true}
+
+	save := func(a *file.Account) (int, map[string]interface{}) {
+		form := url.Values{"id": {strconv.Itoa(a.Id)}, "username": {a.WebUserName}, "password": {"taken-over"}}
+		c := &AccountController{}
+		r := newTestRequest("192.0.2.80", form, session)
+		res := r.serve(t, c, "AccountController", "Save", c.Save)
+		return r.rec.Code, res
+	}
+	if status, res := save(admin); status != 403 || code(res) != 403 {
+		t.Fatalf("operator reset admin password: %d %v", status, res)
+	}
+	if a, _ := db.GetAccountInfo(admin.Id); a == nil {
+		t.Fatal("admin account missing")
+	} else if ok, _ := crypt.CheckPassword(a.WebPassword, "taken-over"); ok {
+		t.Fatal("admin password changed by operator")
+	}
+	if _, res := save(owner); code(res) != 200 {
+		t.Fatalf("operator edit owner: %v", res)
+	}
+}
+
+func TestSaveKeepsFieldsNotSubmitted(t *testing.T) {
+	db := newTestDb(t)
+	a := newTestAccount(t, db, "alice", "password", file.RoleOwner)
+	a.Flow.FlowLimit = 1024
+	a.ExpireTime = "2030-01-01 00:00:00"
+	a.Allowance = 2048
+	a.ResetDay = 5
+	a.Remark = "old"
+	if err := db.UpdateAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	session := map[string]interface{}{"auth": true, "username": "admin", "role": file.RoleAdmin, "isAdmin": true}
+	form := url.Values{"id": {strconv.Itoa(a.Id)}, "username": {a.WebUserName}, "remark": {"new"}, "password": {"changed"}}
+	c := &AccountController{}
+	if res := newTestRequest("192.0.2.81", form, session).serve(t, c, "AccountController", "Save", c.Save); code(res) != 200 {
+		t.Fatalf("save %v", res)
+	}
+	got, err := db.GetAccountInfo(a.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Remark != "new" || got.Flow.FlowLimit != 1024 || got.ExpireTime != a.ExpireTime || got.Allowance != 2048 || got.ResetDay != 5 || !got.Status {
+		t.Fatalf("fields not kept: remark %q flow %d expire %q allowance %d reset day %d status %v",
+			got.Remark, got.Flow.FlowLimit, got.ExpireTime, got.Allowance, got.ResetDay, got.Status)
+	}
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"ehang.io/nps/lib/crypt"
+	"ehang.io/nps/lib/file"
+	"github.com/astaxie/beego/logs"
+)
+
+// actionScopes API 密钥可以访问的接口及需要的授权范围，未列出的接口（页面、账号与密钥管理等）不能使用 API 密钥访问。
+// 密钥同时受所属账号角色的权限限制
+var actionScopes = map[string]string{
+	"index.gettunnel":     file.ScopeTunnelRead,
+	"index.gettunnelv2":   file.ScopeTunnelRead,
+	"index.getonetunnel":  file.ScopeTunnelRead,
+	"client.list":         file.ScopeTunnelRead,
+	"client.getclient":    file.ScopeTunnelRead,
+	"traffic.history":     file.ScopeTunnelRead,
+	"index.add":           file.ScopeTunnelWrite,
+	"index.edit":          file.ScopeTunnelWrite,
+	"index.stop":          file.ScopeTunnelWrite,
+	"index.start":         file.ScopeTunnelWrite,
+	"index.del":           file.ScopeTunnelWrite,
+	"client.add":          file.ScopeTunnelWrite,
+	"client.edit":         file.ScopeTunnelWrite,
+	"client.changestatus": file.ScopeTunnelWrite,
+	"client.del":          file.ScopeTunnelWrite,
+	"index.hostlist":      file.ScopeHostRead,
+	"index.gethost":       file.ScopeHostRead,
+	"index.addhost":       file.ScopeHostWrite,
+	"index.edithost":      file.ScopeHostWrite,
+	"index.delhost":       file.ScopeHostWrite,
+	"index.getpriceplan":  file.ScopeBillingRead,
+	"order.list":          file.ScopeBillingRead,
+	"order.invoice":       file.ScopeBillingRead,
+}
+
+// requestSession 只在本次请求内有效的会话，API 密钥请求的身份不会写入浏览器会话，
+// 避免之后不带密钥的请求沿用密钥所属账号的完整权限
+type requestSession map[interface{}]interface{}
+
+func (m requestSession) Set(key, value interface{}) error {
+	m[key] = value
+	return nil
+}
+
+func (m requestSession) Get(key interface{}) interface{} {
+	return m[key]
+}
+
+func (m requestSession) Delete(key interface{}) error {
+	delete(m, key)
+	return nil
+}
+
+func (m requestSession) SessionID() string {
+	return ""
+}
+
+func (m requestSession) SessionRelease(w http.ResponseWriter) {}
+
+func (m requestSession) Flush() error {
+	for k := range m {
+		delete(m, k)
+	}
+	return nil
+}
+
+// apiKeyFromRequest 从 X-Api-Key 或 Authorization: Bearer 头中取出 API 密钥
+func (s *BaseController) apiKeyFromRequest() string {
+	if key := s.Ctx.Input.Header("X-Api-Key"); key != "" {
+		return key
+	}
+	if key := strings.TrimPrefix(s.Ctx.Input.Header("Authorization"), "Bearer "); strings.HasPrefix(key, crypt.ApiKeyPrefix) {
+		return key
+	}
+	return ""
+}
+
+// checkApiKey 校验 API 密钥，通过后本次请求以密钥所属账号的身份与角色访问
+func (s *BaseController) checkApiKey(key string) bool {
+	if !crypt.IsApiKey(key) {
+		return false
+	}
+	now := time.Now()
+	k, err := file.GetDb().GetApiKeyByHash(crypt.HashApiKey(key))
+	if err != nil || k == nil || !k.Valid(now) {
+		return false
+	}
+	a, err := file.GetDb().GetAccountInfo(k.AccountId)
+	if err != nil || !a.Status {
+		return false
+	}
+	if err := file.GetDb().TouchApiKey(k.Id, s.Ctx.Input.IP(), now); err != nil {
+		logs.Warn("update api key %d last used error: %v", k.Id, err)
+	}
+	s.apiKey = k
+	sess := requestSession{}
+	s.CruSession, s.Ctx.Input.CruSession = sess, sess
+	s.SetSession("username", a.WebUserName)
+	s.SetSession("accountId", a.Id)
+	s.SetSession("clientId", a.Id)
+	setRoleSession(s, a.Role)
+	return true
+}
+
+type ApiKeyController struct {
+	BaseController
+}
+
+// apiKeyAccountId 普通用户只能管理自己的 API 密钥，可以管理所有账号的角色可以通过 account_id 指定账号
+func (s *ApiKeyController) apiKeyAccountId() int {
+	if s.GetSession("isAdmin") == true && s.can(file.PermAccount) {
+		if id := s.GetIntNoErr("account_id"); id > 0 {
+			return id
+		}
+	}
+	id := s.GetSessionIntNoErr("accountId", 0)
+	if id <= 0 {
+		s.AjaxErr("account is required")
+	}
+	return id
+}
+
+// 获取 API 密钥列表，包括已吊销与已过期的密钥
+func (s *ApiKeyController) List() {
+	list, err := file.GetDb().ListApiKeys(s.apiKeyAccountId())
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxTable(list, len(list), len(list), nil)
+}
+
+// 创建 API 密钥，密钥只在本次返回，之后无法再次查看
+func (s *ApiKeyController) Create() {
+	scopes, err := file.ParseScopes(s.GetString("scopes"))
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	key := crypt.NewApiKey()
+	k := &file.ApiKey{
+		AccountId: s.apiKeyAccountId(),
+		Name:      s.getEscapeString("name"),
+		Prefix:    key[:len(crypt.ApiKeyPrefix)+6],
+		KeyHash:   crypt.HashApiKey(key),
+		Scopes:    scopes,
+	}
+	if days := s.GetIntNoErr("expire_days"); days > 0 {
+		k.ExpireAt = time.Now().AddDate(0, 0, days).Unix()
+	}
+	if err := file.GetDb().CreateApiKey(k); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.Data["json"] = map[string]interface{}{
+		"code": 200,
+		"msg":  "success",
+		"data": map[string]interface{}{"id": k.Id, "key": key, "scopes": k.Scopes, "expire_at": k.ExpireAt},
+	}
+	s.ServeJSON()
+	s.StopRun()
+}
+
+// 吊销 API 密钥，立即生效
+func (s *ApiKeyController) Revoke() {
+	if err := file.GetDb().RevokeApiKey(s.apiKeyAccountId(), s.GetIntNoErr("id")); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("revoke success")
+}
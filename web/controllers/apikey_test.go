@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"net/url"
+	"testing"
+
+	"ehang.io/nps/lib/crypt"
+	"ehang.io/nps/lib/file"
+)
+
+func TestApiKeyScope(t *testing.T) {
+	db := newTestDb(t)
+	a := newTestAccount(t, db, "alice", "password", file.RoleAdmin)
+	key := crypt.NewApiKey()
+	if err := db.CreateApiKey(&file.ApiKey{AccountId: a.Id, KeyHash: crypt.HashApiKey(key), Scopes: []string{file.ScopeTunnelRead}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		controller, action string
+		allowed            bool
+	}{
+		{"IndexController", "GetTunnel", true},
+		{"IndexController", "Add", false},
+		{"IndexController", "AddHost", false},
+		// 未列出授权范围的接口即使角色拥有权限也不能使用密钥访问
+		{"ApiKeyController", "Create", false},
+		{"GlobalController", "Save", false},
+	} {
+		r := newTestRequest("192.0.2.30", url.Values{}, nil)
+		r.ctx.Request.Header.Set("X-Api-Key", key)
+		res := r.serve(t, &BaseController{}, c.controller, c.action, nil)
+		if denied := res != nil && res["msg"] == "permission denied"; denied == c.allowed {
+			t.Errorf("%s.%s allowed %v, response %v", c.controller, c.action, !denied, res)
+		}
+	}
+
+	r := newTestRequest("192.0.2.30", url.Values{}, nil)
+	r.ctx.Request.Header.Set("X-Api-Key", crypt.NewApiKey())
+	if res := r.serve(t, &BaseController{}, "IndexController", "GetTunnel", nil); code(res) != 401 {
+		t.Fatalf("unknown api key %v", res)
+	}
+}
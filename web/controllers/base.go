@@ -3,7 +3,10 @@ package controllers
 import (
 	"fmt"
 	"html"
+	"io"
 	"math"
+	"net"
+	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
@@ -14,6 +17,7 @@ import (
 	"ehang.io/nps/lib/common"
 	"ehang.io/nps/lib/crypt"
 	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/lockout"
 	"ehang.io/nps/server"
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/logs"
@@ -24,6 +28,8 @@ type BaseController struct {
 	beego.Controller
 	controllerName string
 	actionName     string
+	apiKey         *file.ApiKey // 使用 API 密钥访问时为对应的密钥
+	sessionId      string       // 使用 access token 访问时为 token 所在的登录会话
 }
 
 func (s *BaseController) TokenFail() {
@@ -36,6 +42,34 @@ func (s *BaseController) TokenFail() {
 	s.StopRun()
 }
 
+// Forbidden 没有权限操作目标数据
+func (s *BaseController) Forbidden() {
+	s.Ctx.Output.SetStatus(403)
+	s.Data["json"] = map[string]interface{}{
+		"code": 403,
+		"msg":  "permission denied",
+	}
+	s.ServeJSON()
+	s.StopRun()
+}
+
+// LockFail 失败次数过多被锁定
+func (s *BaseController) LockFail(err error) {
+	s.Ctx.Output.SetStatus(429)
+	s.Data["json"] = map[string]interface{}{
+		"code": 429,
+		"msg":  err.Error(),
+	}
+	s.ServeJSON()
+	s.StopRun()
+}
+
+// remoteIp 请求的来源地址，失败计数不使用可以伪造的 X-Forwarded-For
+func remoteIp(r *http.Request) string {
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return ip
+}
+
 // 初始化参数
 func (s *BaseController) Prepare() {
 	s.Data["web_base_url"] = beego.AppConfig.String("web_base_url")
@@ -45,16 +79,44 @@ func (s *BaseController) Prepare() {
 	if "paymentcallback" == s.actionName || "loginforwx" == s.actionName {
 		return
 	}
+	// 全局要求两步验证但尚未绑定的用户只能访问绑定接口
+	if s.GetSession("totpEnroll") == true && s.controllerName != "totp" {
+		s.AjaxErr("two-factor authentication must be enabled first")
+	}
 	md5Key := s.getEscapeString("auth_key")
 
+	// API 密钥只能访问授权范围内的接口
+	if key := s.apiKeyFromRequest(); key != "" {
+		ip := remoteIp(s.Ctx.Request)
+		if err := lockout.Check(file.LockScopeIp, ip); err != nil {
+			s.LockFail(err)
+		}
+		if !s.checkApiKey(key) {
+			lockout.Fail(file.LockScopeIp, ip)
+			s.TokenFail()
+		}
+		if s.GetSession("isAdmin") == true {
+			s.Data["isAdmin"] = true
+		} else {
+			s.restrictToAccount()
+		}
+		s.checkPermission()
+		s.setCommonConfigs()
+		return
+	}
+
 	// web api verify
 	// support both token and session auth
 	authToken := s.Ctx.Input.Header("Authorization")
 	if authToken != "" && strings.HasPrefix(authToken, "Bearer ") {
 		token := strings.TrimPrefix(authToken, "Bearer ")
 		if isValidToken(token, s) {
-			s.SetSession("isAdmin", true)
-			s.Data["isAdmin"] = true
+			if s.GetSession("isAdmin") == true {
+				s.Data["isAdmin"] = true
+			} else {
+				s.restrictToAccount()
+			}
+			s.checkPermission()
 			// Set common configs for both token and session auth
 			s.setCommonConfigs()
 			return
@@ -83,24 +145,32 @@ func (s *BaseController) Prepare() {
 	if configKey == "" {
 		configKey = crypt.GetRandomString(64)
 	}
+	ip := remoteIp(s.Ctx.Request)
+	if md5Key != "" {
+		if err := lockout.Check(file.LockScopeIp, ip); err != nil {
+			s.LockFail(err)
+		}
+	}
 	timeNowUnix := time.Now().Unix()
-	if !(md5Key != "" && (math.Abs(float64(timeNowUnix-int64(timestamp))) <= 20) && (crypt.Md5(configKey+strconv.Itoa(timestamp)) == md5Key)) {
+	if !(md5Key != "" && (math.Abs(float64(timeNowUnix-int64(timestamp))) <= 20) && crypt.EqualString(crypt.Md5(configKey+strconv.Itoa(timestamp)), md5Key)) {
+		if md5Key != "" {
+			lockout.Fail(file.LockScopeIp, ip)
+		}
 		if s.GetSession("auth") != true {
 			s.Redirect(beego.AppConfig.String("web_base_url")+"/login/index", 302)
 		}
 	} else {
-		s.SetSession("isAdmin", true)
+		setRoleSession(s, file.RoleAdmin)
 		s.Data["isAdmin"] = true
 	}
 	if s.GetSession("isAdmin") != nil && !s.GetSession("isAdmin").(bool) {
-		s.Ctx.Input.SetData("client_id", s.GetSession("clientId").(int))
-		s.Ctx.Input.SetParam("client_id", strconv.Itoa(s.GetSession("clientId").(int)))
-		s.Data["isAdmin"] = false
-		s.Data["username"] = s.GetSession("username")
-		s.CheckUserAuth()
+		s.restrictToAccount()
 	} else {
 		s.Data["isAdmin"] = true
 	}
+	if s.GetSession("auth") == true || s.GetSession("isAdmin") == true {
+		s.checkPermission()
+	}
 
 	if s.GetSessionIntNoErr("accountId", 0) == 0 && s.GetSession("username") != nil {
 		username := s.GetSession("username").(string)
@@ -128,18 +198,12 @@ func (s *BaseController) Prepare() {
 // 加载模板
 // isValidToken validates JWT token
 func isValidToken(token string, s *BaseController) bool {
-	// Use auth_key from config as JWT secret
-	secret := beego.AppConfig.String("auth_key")
-	if secret == "" {
-		secret = crypt.GetRandomString(64)
-	}
-
 	// Parse and validate the token
 	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, jwt.ErrSignatureInvalid
 		}
-		return []byte(secret), nil
+		return jwtSecret(), nil
 	})
 
 	if err != nil {
@@ -155,10 +219,28 @@ func isValidToken(token string, s *BaseController) bool {
 				return false
 			}
 		}
+		// 全局要求两步验证时拒绝登录时未通过两步验证的 token
+		if mfa, _ := claims["mfa"].(bool); !mfa && file.GetDb().GetGlobal().RequireTotp {
+			logs.Error("Token issued without two-factor authentication")
+			return false
+		}
+		// token 所在的登录会话退出或过期后立即失效，没有会话的旧 token 需要重新登录
+		sid, _ := claims["sid"].(string)
+		if l, err := file.GetDb().GetLoginSession(sid); sid == "" || err != nil || l == nil || !l.Active(time.Now()) {
+			logs.Error("Token session revoked or expired")
+			return false
+		}
+		s.sessionId = sid
 		s.Data["username"] = claims["username"]
 		s.Data["accountId"] = claims["accountId"]
 		s.SetSession("username", claims["username"])
 		s.SetSession("accountId", claims["accountId"])
+		// 没有角色的旧 token 按普通用户处理
+		role, _ := claims["role"].(string)
+		setRoleSession(s, role)
+		if accountId, ok := claims["accountId"].(float64); ok {
+			s.SetSession("clientId", int(accountId))
+		}
 		return true
 	}
 
@@ -224,6 +306,15 @@ func (s *BaseController) getEscapeString(key string) string {
 	return html.EscapeString(s.GetString(key))
 }
 
+// hasParam 请求中是否提交了参数，用于区分未提交与提交了空值
+func (s *BaseController) hasParam(key string) bool {
+	if s.Ctx.Request.Form == nil {
+		s.Ctx.Request.ParseForm()
+	}
+	_, ok := s.Ctx.Request.Form[key]
+	return ok
+}
+
 // 去掉没有err返回值的int
 func (s *BaseController) GetIntNoErr(key string, def ...int) int {
 	strv := s.Ctx.Input.Query(key)
@@ -272,6 +363,14 @@ func (s *BaseController) GetBoolNoErr(key string, def ...bool) bool {
 	return val
 }
 
+// requestBody 返回请求体，未开启 copyrequestbody 时从请求中读取
+func (s *BaseController) requestBody() []byte {
+	if len(s.Ctx.Input.RequestBody) == 0 && s.Ctx.Request.Body != nil {
+		s.Ctx.Input.RequestBody, _ = io.ReadAll(io.LimitReader(s.Ctx.Request.Body, 1<<20))
+	}
+	return s.Ctx.Input.RequestBody
+}
+
 // ajax正确返回
 func (s *BaseController) AjaxOk(str string) {
 	s.Data["json"] = ajax(str, 200)
@@ -347,6 +446,16 @@ func (s *BaseController) SetType(name string) {
 	s.Data["type"] = name
 }
 
+// restrictToAccount 非全局角色只能访问自己的客户端、隧道与域名解析
+func (s *BaseController) restrictToAccount() {
+	clientId, _ := s.GetSession("clientId").(int)
+	s.Ctx.Input.SetData("client_id", clientId)
+	s.Ctx.Input.SetParam("client_id", strconv.Itoa(clientId))
+	s.Data["isAdmin"] = false
+	s.Data["username"] = s.GetSession("username")
+	s.CheckUserAuth()
+}
+
 func (s *BaseController) CheckUserAuth() {
 	if s.controllerName == "client" {
 		if s.actionName == "add" {
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ehang.io/nps/lib/file"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+)
+
+// newTestDb 使用内存中的 sqlite 作为存储
+func newTestDb(t *testing.T) *file.SqliteDb {
+	db, err := file.NewSqliteDb(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.SqlDB.Close() })
+	file.SetDb(db)
+	beego.AppConfig.Set("auth_key", "test-auth-key")
+	beego.AppConfig.Set("web_username", "admin")
+	beego.AppConfig.Set("web_password", "admin-password")
+	return db
+}
+
+func newTestAccount(t *testing.T, db file.Store, name, password, role string) *file.Account {
+	a := file.NewAccount()
+	a.WebUserName = name
+	a.WebPassword = password
+	a.Role = role
+	if err := db.NewAccount(a); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// testRequest 模拟一次请求，会话只在本次请求内有效
+type testRequest struct {
+	ctx *context.Context
+	rec *httptest.ResponseRecorder
+}
+
+func newTestRequest(ip string, form url.Values, session map[string]interface{}) *testRequest {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.RemoteAddr = ip + ":40000"
+	r := &testRequest{ctx: context.NewContext(), rec: httptest.NewRecorder()}
+	r.ctx.Reset(r.rec, req)
+	sess := requestSession{}
+	for k, v := range session {
+		sess[k] = v
+	}
+	r.ctx.Input.CruSession = sess
+	return r
+}
+
+type testController interface {
+	Init(ctx *context.Context, controllerName, actionName string, app interface{})
+	Prepare()
+}
+
+// serve 与路由一样先执行 Prepare 再执行接口，接口中止时返回已写入的响应
+func (r *testRequest) serve(t *testing.T, c testController, controllerName, actionName string, action func()) map[string]interface{} {
+	c.Init(r.ctx, controllerName, actionName, c)
+	func() {
+		defer func() {
+			if err := recover(); err != nil && err != beego.ErrAbort {
+				panic(err)
+			}
+		}()
+		c.Prepare()
+		if action != nil {
+			action()
+		}
+	}()
+	if r.rec.Body.Len() == 0 {
+		return nil
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(r.rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("response %q: %v", r.rec.Body.String(), err)
+	}
+	return res
+}
+
+// code 响应中的 code，没有响应时返回 0
+func code(res map[string]interface{}) int {
+	c, _ := res["code"].(float64)
+	return int(c)
+}
@@ -43,6 +43,9 @@ func (s *ClientController) Add() {
 		vkey := s.getEscapeString("vkey")
 		clientId := file.GetDb().GetClientByVkeyAndAccountId(vkey, accountId)
 		if clientId == 0 {
+			if msg := accountLimitErr(accountId, true); msg != "" {
+				s.AjaxErr(msg)
+			}
 			clientId = int(file.GetDb().GetNewClientId())
 			t := &file.Client{
 				VerifyKey: vkey,
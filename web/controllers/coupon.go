@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"ehang.io/nps/lib/file"
+)
+
+type CouponController struct {
+	BaseController
+}
+
+// 优惠码列表，优惠码管理只对管理员开放
+func (s *CouponController) List() {
+	if s.GetSession("isAdmin") != true {
+		s.AjaxErr("permission denied")
+	}
+	list, err := file.GetDb().GetCoupons()
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxTable(list, len(list), len(list), nil)
+}
+
+// 保存优惠码，id 为 0 时新建
+func (s *CouponController) Save() {
+	if s.GetSession("isAdmin") != true {
+		s.AjaxErr("permission denied")
+	}
+	c := &file.Coupon{
+		Id:      s.GetIntNoErr("id"),
+		Code:    s.getEscapeString("code"),
+		Kind:    s.getEscapeString("kind"),
+		MaxUses: s.GetIntNoErr("max_uses"),
+		StartAt: int64(s.GetIntNoErr("start_at")),
+		EndAt:   int64(s.GetIntNoErr("end_at")),
+		Status:  s.GetBoolNoErr("status"),
+		Remark:  s.getEscapeString("remark"),
+	}
+	var err error
+	if c.Value, err = s.GetFloat("value", 0); err != nil {
+		s.AjaxErr("value must be a number")
+	}
+	if err := file.GetDb().SaveCoupon(c); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOkWithId("save success", c.Id)
+}
+
+// 删除优惠码
+func (s *CouponController) Del() {
+	if s.GetSession("isAdmin") != true {
+		s.AjaxErr("permission denied")
+	}
+	if err := file.GetDb().DelCoupon(s.GetIntNoErr("id")); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("delete success")
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"errors"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"ehang.io/nps/lib/crypt"
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/lockout"
+	"ehang.io/nps/lib/notify"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+)
+
+// 一次性链接的有效期
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = 30 * time.Minute
+)
+
+// emailVerifyRequired 注册的账号是否需要验证邮箱后才能添加客户端，配置了 SMTP 时默认开启
+func emailVerifyRequired() bool {
+	return beego.AppConfig.DefaultBool("register_email_verify", notify.MailerFromConfig() != nil)
+}
+
+// parseEmail 校验并返回邮箱地址
+func parseEmail(s string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(s))
+	if err != nil || addr.Name != "" {
+		return "", errors.New("invalid email address")
+	}
+	return addr.Address, nil
+}
+
+// accountLink 生成邮件中的一次性链接，使用配置的 web_public_url，不信任请求中的 Host
+func accountLink(path, token string) (string, error) {
+	base := strings.TrimSuffix(beego.AppConfig.String("web_public_url"), "/")
+	if base == "" {
+		return "", errors.New("web_public_url is not configured")
+	}
+	return base + path + "?token=" + url.QueryEscape(token), nil
+}
+
+// sendAccountToken 生成一次性链接并发送到账号邮箱
+func sendAccountToken(a *file.Account, purpose string) error {
+	m := notify.MailerFromConfig()
+	if m == nil {
+		return errors.New("smtp is not configured")
+	}
+	path, ttl, send := "/login/verifyemail", verifyEmailTTL, notify.SendVerifyEmail
+	if purpose == file.TokenResetPassword {
+		path, ttl, send = "/login/resetpassword", resetPasswordTTL, notify.SendResetPassword
+	}
+	token := crypt.RandomHex(32)
+	link, err := accountLink(path, token)
+	if err != nil {
+		return err
+	}
+	if err := file.GetDb().CreateAccountToken(a.Id, purpose, crypt.Sha256(token), a.Email, time.Now().Add(ttl).Unix()); err != nil {
+		return err
+	}
+	return send(m, a.Email, a.WebUserName, link, ttl)
+}
+
+// useAccountToken 使用一次性链接，生成链接后账号修改了邮箱时链接失效
+func useAccountToken(purpose, token string) (*file.Account, error) {
+	id, email, err := file.GetDb().UseAccountToken(purpose, crypt.Sha256(token), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	a, err := file.GetDb().GetAccountInfo(id)
+	if err != nil {
+		return nil, err
+	}
+	if !a.Status || a.Email != email {
+		return nil, file.ErrTokenInvalid
+	}
+	return a, nil
+}
+
+// 打开邮件中的验证链接
+func (self *LoginController) VerifyEmail() {
+	a, err := useAccountToken(file.TokenVerifyEmail, self.GetString("token"))
+	if err == nil {
+		err = file.GetDb().SetAccountEmail(a.Id, a.Email, true)
+	}
+	if strings.HasPrefix(self.Ctx.Input.Header("Accept"), "application/json") {
+		if err != nil {
+			self.Data["json"] = map[string]interface{}{"code": 400, "msg": err.Error()}
+		} else {
+			self.Data["json"] = map[string]interface{}{"code": 200, "msg": "email verified"}
+		}
+		self.ServeJSON()
+		return
+	}
+	if err != nil {
+		self.Ctx.Output.SetStatus(400)
+		self.Ctx.WriteString(err.Error())
+		return
+	}
+	self.Redirect(beego.AppConfig.String("web_base_url")+"/login/index", 302)
+}
+
+// 找回密码，提交用户名或邮箱，账号存在且邮箱已验证时发送重置链接；无论账号是否存在都返回相同结果
+func (self *LoginController) ForgotPassword() {
+	if self.Ctx.Request.Method == "GET" {
+		self.Data["web_base_url"] = beego.AppConfig.String("web_base_url")
+		self.TplName = "login/forgot.html"
+		return
+	}
+	name := strings.TrimSpace(self.GetString("username"))
+	// 每次申请都计入来源地址与用户名的失败次数，防止频繁发送邮件
+	ip := remoteIp(self.Ctx.Request)
+	err := lockout.Check(file.LockScopeIp, ip)
+	if err == nil && name != "" {
+		err = lockout.Check(file.LockScopeUser, name)
+	}
+	if err != nil {
+		self.Ctx.Output.SetStatus(429)
+		self.Data["json"] = map[string]interface{}{"code": 429, "msg": err.Error()}
+		self.ServeJSON()
+		return
+	}
+	lockout.Fail(file.LockScopeIp, ip)
+	id := 0
+	if name != "" {
+		lockout.Fail(file.LockScopeUser, name)
+		if a := file.GetDb().GetByUsernameNoErr(name); a.Id > 0 {
+			id = a.Id
+		} else if email, err := parseEmail(name); err == nil {
+			id, _ = file.GetDb().GetAccountIdByEmail(email)
+		}
+	}
+	if id > 0 {
+		if a, err := file.GetDb().GetAccountInfo(id); err == nil && a.Status && a.Email != "" && a.EmailVerified {
+			if err := sendAccountToken(a, file.TokenResetPassword); err != nil {
+				logs.Error("send reset password email to account %d error %v", a.Id, err)
+			}
+		}
+	}
+	self.Data["json"] = map[string]interface{}{"code": 200, "msg": "if the account exists and its email is verified, a reset link has been sent"}
+	self.ServeJSON()
+}
+
+// 打开邮件中的重置链接设置新密码，重置后账号所有的登录会话都会退出
+func (self *LoginController) ResetPassword() {
+	if self.Ctx.Request.Method == "GET" {
+		self.Data["web_base_url"] = beego.AppConfig.String("web_base_url")
+		self.Data["token"] = self.GetString("token")
+		self.TplName = "login/reset.html"
+		return
+	}
+	password := self.GetString("password")
+	if password == "" {
+		self.Data["json"] = map[string]interface{}{"code": 400, "msg": "password is required"}
+		self.ServeJSON()
+		return
+	}
+	a, err := useAccountToken(file.TokenResetPassword, self.GetString("token"))
+	if err == nil {
+		err = file.GetDb().UpdatePassword(a.Id, password)
+	}
+	if err != nil {
+		self.Data["json"] = map[string]interface{}{"code": 400, "msg": err.Error()}
+		self.ServeJSON()
+		return
+	}
+	if _, err := file.GetDb().RevokeAccountSessions(a.Id); err != nil {
+		logs.Warn("revoke sessions of account %d error %v", a.Id, err)
+	}
+	logs.Info("password of account %s reset by email", a.WebUserName)
+	self.Data["json"] = map[string]interface{}{"code": 200, "msg": "password reset success"}
+	self.ServeJSON()
+}
+
+type EmailController struct {
+	BaseController
+}
+
+// emailAccount 当前登录的账号
+func (s *EmailController) emailAccount() *file.Account {
+	id := s.GetSessionIntNoErr("accountId", 0)
+	if id <= 0 {
+		s.AjaxErr("account is required")
+	}
+	a, err := file.GetDb().GetAccountInfo(id)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	return a
+}
+
+// 获取当前账号的邮箱与验证状态
+func (s *EmailController) Info() {
+	a := s.emailAccount()
+	s.Data["json"] = map[string]interface{}{
+		"code": 200,
+		"msg":  "success",
+		"data": map[string]interface{}{"email": a.Email, "verified": a.Email != "" && a.EmailVerified, "required": emailVerifyRequired()},
+	}
+	s.ServeJSON()
+	s.StopRun()
+}
+
+// 修改邮箱，修改后需要重新验证
+func (s *EmailController) Save() {
+	a := s.emailAccount()
+	email, err := parseEmail(s.GetString("email"))
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	if email == a.Email && a.EmailVerified {
+		s.AjaxOk("email is already verified")
+	}
+	a.Email = email
+	if err := file.GetDb().SetAccountEmail(a.Id, email, false); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	if err := sendAccountToken(a, file.TokenVerifyEmail); err != nil {
+		s.AjaxErr("email saved, but sending verification email failed: " + err.Error())
+	}
+	s.AjaxOk("verification email sent")
+}
+
+// 重新发送验证邮件
+func (s *EmailController) Resend() {
+	a := s.emailAccount()
+	if a.Email == "" {
+		s.AjaxErr("email is not set")
+	}
+	if a.EmailVerified {
+		s.AjaxOk("email is already verified")
+	}
+	if err := sendAccountToken(a, file.TokenVerifyEmail); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("verification email sent")
+}
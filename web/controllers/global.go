@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/goroutine"
+	"ehang.io/nps/server"
 	"strings"
 )
 
@@ -37,7 +39,8 @@ func (s *GlobalController) Save() {
 		s.display()
 	} else {
 
-		t := &file.Glob{BlackIpList: RemoveRepeatedElement(strings.Split(s.getEscapeString("globalBlackIpList"), "\r\n"))}
+		t := file.GetDb().GetGlobal()
+		t.BlackIpList = RemoveRepeatedElement(strings.Split(s.getEscapeString("globalBlackIpList"), "\r\n"))
 
 		if err := file.GetDb().SaveGlobal(t); err != nil {
 			s.AjaxErr(err.Error())
@@ -45,3 +48,58 @@ func (s *GlobalController) Save() {
 		s.AjaxOk("save success")
 	}
 }
+
+// 导出完整配置文档，format 为 yaml（默认）或 json
+func (s *GlobalController) Export() {
+	if s.GetSession("isAdmin") != true {
+		s.AjaxErr("permission denied")
+	}
+	format := s.GetString("format", "yaml")
+	snap, err := file.ExportSnapshot(file.GetDb())
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	b, err := snap.Marshal(format)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.Ctx.Output.Header("Content-Disposition", "attachment; filename=nps-config."+format)
+	s.Ctx.Output.Body(b)
+	s.StopRun()
+}
+
+// 应用配置文档，文档通过 config 参数或请求体传入，dry_run 为 true 时只返回变更预览
+func (s *GlobalController) Apply() {
+	if s.GetSession("isAdmin") != true {
+		s.AjaxErr("permission denied")
+	}
+	data := []byte(s.GetString("config"))
+	if len(data) == 0 {
+		data = s.requestBody()
+	}
+	snap, err := file.ParseSnapshot(data)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	dryRun := s.GetBoolNoErr("dry_run")
+	plan, err := file.ApplySnapshot(file.GetDb(), snap, dryRun)
+	if !dryRun && plan != nil {
+		goroutine.TrafficManager.InvalidateHosts()
+		for _, c := range plan.Changes {
+			if c.Kind == "tunnel" && c.Id != 0 {
+				server.StopServer(c.Id)
+				server.StartTask(c.Id)
+			}
+		}
+	}
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.Data["json"] = map[string]interface{}{
+		"code": 200,
+		"msg":  "success",
+		"data": plan,
+	}
+	s.ServeJSON()
+	s.StopRun()
+}
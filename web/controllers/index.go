@@ -1,17 +1,19 @@
 package controllers
 
 import (
-	"encoding/json"
 	"fmt"
 	"math/rand"
 	"strconv"
 	"time"
 
 	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/goroutine"
+	"ehang.io/nps/lib/payment"
 	"ehang.io/nps/server"
 	"ehang.io/nps/server/tool"
 
 	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
 )
 
 type IndexController struct {
@@ -167,6 +169,9 @@ func (s *IndexController) Add() {
 		if t.Client.MaxTunnelNum != 0 && t.Client.GetTunnelNum() >= t.Client.MaxTunnelNum {
 			s.AjaxErr("The number of tunnels exceeds the limit")
 		}
+		if msg := accountLimitErr(t.AccountId, false); msg != "" {
+			s.AjaxErr(msg)
+		}
 		if err := file.GetDb().NewTask(t); err != nil {
 			s.AjaxErr(err.Error())
 		}
@@ -313,6 +318,7 @@ func (s *IndexController) DelHost() {
 	if err := file.GetDb().DelHost(id); err != nil {
 		s.AjaxErr("delete error")
 	}
+	goroutine.TrafficManager.InvalidateHosts()
 	s.AjaxOk("delete success")
 }
 
@@ -349,6 +355,7 @@ func (s *IndexController) AddHost() {
 		if err := file.GetDb().NewHost(h); err != nil {
 			s.AjaxErr("add fail" + err.Error())
 		}
+		goroutine.TrafficManager.InvalidateHosts()
 		s.AjaxOkWithId("add success", id)
 	}
 }
@@ -394,7 +401,10 @@ func (s *IndexController) EditHost() {
 			h.CertFilePath = s.getEscapeString("cert_file_path")
 			h.Target.LocalProxy = s.GetBoolNoErr("local_proxy")
 			h.AutoHttps = s.GetBoolNoErr("AutoHttps")
-			// No need to store to JSON file anymore as we're using MySQL
+			if err := file.GetDb().UpdateHost(h); err != nil {
+				s.AjaxErr("modified error " + err.Error())
+			}
+			goroutine.TrafficManager.InvalidateHosts()
 		}
 		s.AjaxOk("modified success")
 	}
@@ -414,12 +424,23 @@ func (s *IndexController) GetPricePlan() {
 		return
 	}
 
+	pricing := payment.GetPricing()
+	plans, err := file.GetDb().GetPlans(true)
+	if err != nil {
+		logs.Warn("load plans error: %s", err.Error())
+	}
 	data := map[string]interface{}{
 		"code": 200,
 		"msg":  "success",
 		"data": map[string]interface{}{
-			"pricePerGB": 0.8,                    // 每GB流量价格(元)
-			"userFlow":   account.Flow.FlowLimit, // 用户剩余流量(GB)
+			"pricePerGB":    pricing.PerGB,          // 每GB流量价格(元)
+			"pricePerMonth": pricing.PerMonth,       // 每月价格(元)
+			"userFlow":      account.Flow.FlowLimit, // 用户购买的流量余额(KB)
+			"allowance":     account.Allowance,      // 每月流量额度(KB)
+			"allowanceLeft": account.AllowanceLeft,  // 本周期剩余额度(KB)
+			"resetDay":      account.ResetDay,       // 每月重置额度的日期
+			"planId":        account.PlanId,         // 当前套餐
+			"plans":         plans,                  // 可购买的套餐
 		},
 	}
 	s.Data["json"] = data
@@ -429,29 +450,58 @@ func (s *IndexController) GetPricePlan() {
 func (s *IndexController) CreatePaymentOrder() {
 	paymentType := s.getEscapeString("paymentType")
 	months := s.GetIntNoErr("months")
-	flow := s.GetIntNoErr("flow")
+	flow := float64(s.GetIntNoErr("flow"))
+	planId := s.GetIntNoErr("plan_id")
 	accountId := s.GetSessionIntNoErr("accountId", 0)
 
-	// 计算订单金额
+	provider, err := payment.Default()
+	if err != nil {
+		s.AjaxErr("支付未配置: " + err.Error())
+		return
+	}
+	// 计算订单金额，购买套餐时按套餐定价
 	var orderAmount float64
-	orderAmount = float64(flow) * 0.8 // 每GB流量价格(元)
+	if planId > 0 {
+		plan, err := file.GetDb().GetPlan(planId)
+		if err != nil || !plan.Status {
+			s.AjaxErr("套餐不存在或已下架")
+			return
+		}
+		paymentType, orderAmount, flow, months = "plan", plan.Price, plan.Flow, plan.Months
+	} else if orderAmount, err = payment.GetPricing().Amount(paymentType, flow, months); err != nil {
+		s.AjaxErr("订单参数错误: " + err.Error())
+		return
+	}
 	// 创建订单对象
 	order := &file.Order{
-		AppId:                 "b8e47ca842ac4ce18d4e17b5bee46f91",
+		AppId:                 provider.AppId(),
 		OrderAmount:           orderAmount,
-		Flow:                  float64(flow),
+		Flow:                  flow,
 		Months:                months,
-		OrderStatus:           "pending",
+		PlanId:                planId,
+		OrderStatus:           payment.StatusPending,
 		PaymentType:           paymentType,
 		ExternalTransactionId: fmt.Sprintf("PAY%s%d", time.Now().Format("20060102150405"), rand.Intn(1000)),
 		AccountId:             strconv.Itoa(accountId),
 	}
 
+	if code := s.getEscapeString("coupon"); code != "" {
+		if err := payment.ApplyCoupon(file.GetDb(), order, accountId, code, time.Now()); err != nil {
+			s.AjaxErr("优惠码不可用: " + err.Error())
+			return
+		}
+	}
+
 	// 保存订单到数据库
 	if err := file.GetDb().CreateOrder(order); err != nil {
 		s.AjaxErr("创建订单失败: " + err.Error())
 		return
 	}
+	checkout, err := provider.CreateOrder(order)
+	if err != nil {
+		s.AjaxErr("创建支付订单失败: " + err.Error())
+		return
+	}
 
 	// 返回订单信息
 	data := make(map[string]interface{})
@@ -459,8 +509,12 @@ func (s *IndexController) CreatePaymentOrder() {
 	data["msg"] = "订单创建成功"
 	data["data"] = map[string]interface{}{
 		"orderAmount":           order.OrderAmount,
+		"discount":              order.Discount,
+		"bonusFlow":             order.BonusFlow,
 		"appId":                 order.AppId,
 		"externalTransactionId": order.ExternalTransactionId,
+		"payUrl":                checkout.PayUrl,
+		"params":                checkout.Params,
 	}
 
 	s.Data["json"] = data
@@ -468,28 +522,17 @@ func (s *IndexController) CreatePaymentOrder() {
 }
 
 func (s *IndexController) PaymentCallback() {
-	// 记录请求体内容
-	body := s.Ctx.Input.RequestBody
-	fmt.Printf("PaymentCallback request body: %s\n", string(body))
-
-	// 解析JSON参数
-	type CallbackRequest struct {
-		ExternalTransactionId string `json:"externalTransactionId"`
-		Secret                string `json:"secret"`
-	}
-	var req CallbackRequest
-
-	// 使用json.NewDecoder解析
-	decoder := json.NewDecoder(s.Ctx.Request.Body)
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&req); err != nil {
-		s.AjaxErr(fmt.Sprintf("参数解析失败: %v, body: %s", err, string(body)))
+	provider, err := payment.Default()
+	if err != nil {
+		s.AjaxErr("支付未配置")
 		return
 	}
-
-	// 验证secret
-	if req.Secret != "b8e47ca842ac4ce18d4e17b5bee46f911111111" {
-		s.AjaxErr("无效的secret")
+	// 校验回调签名
+	body := s.requestBody()
+	req, err := provider.VerifyCallback(s.Ctx.Request, body)
+	if err != nil {
+		logs.Warn("payment callback from %s rejected: %s", s.Ctx.Input.IP(), err.Error())
+		s.AjaxErr(err.Error())
 		return
 	}
 
@@ -499,33 +542,43 @@ func (s *IndexController) PaymentCallback() {
 		s.AjaxErr("订单不存在")
 		return
 	}
-	if order.OrderStatus == "paid" {
-		s.AjaxErr("订单已完成")
+	if req.Status != payment.StatusPaid {
+		if order.OrderStatus == payment.StatusPending && req.Status == payment.StatusFailed {
+			order.OrderStatus = payment.StatusFailed
+			file.GetDb().UpdateOrder(order)
+		}
+		s.AjaxOk("处理成功")
 		return
 	}
-
-	// 更新订单状态
-	order.OrderStatus = "paid"
-	if err := file.GetDb().UpdateOrder(order); err != nil {
-		s.AjaxErr("订单状态更新失败")
+	if !payment.AmountMatches(req.Amount, order.OrderAmount) {
+		logs.Warn("payment callback of order %s amount %.2f, want %.2f", order.ExternalTransactionId, req.Amount, order.OrderAmount)
+		s.AjaxErr("支付金额与订单金额不一致")
 		return
 	}
 
-	// 执行账号充值逻辑
-	accountId, _ := strconv.Atoi(order.AccountId)
-	if order.PaymentType == "traffic" {
-		// 流量充值逻辑
-		if err := file.GetDb().AddTraffic(accountId, order.Flow*1024*1024); err != nil {
-			s.AjaxErr("流量充值失败")
-			return
-		}
-	} else {
-		// 月费充值逻辑
-		if err := file.GetDb().AddMonths(accountId, order.Months); err != nil {
-			s.AjaxErr("月费充值失败")
+	// 订单状态与账号充值在同一事务中完成，重复回调不会重复充值
+	if _, err := file.GetDb().SettleOrder(order.ExternalTransactionId); err == file.ErrOrderSettled {
+		s.AjaxOk("订单已完成")
+		return
+	} else if err == file.ErrCouponLimit {
+		// 同一优惠码的其他订单先完成了结算，本订单不入账并全额退款
+		if err := provider.Refund(order, order.OrderAmount); err != nil {
+			logs.Error("refund order %s with used up coupon %s error: %s", order.ExternalTransactionId, order.CouponCode, err.Error())
+			s.AjaxErr("订单结算失败")
 			return
 		}
+		order.OrderStatus = payment.StatusFailed
+		file.GetDb().UpdateOrder(order)
+		logs.Warn("order %s refunded, coupon %s use limit reached", order.ExternalTransactionId, order.CouponCode)
+		s.AjaxOk("优惠码已失效，订单已退款")
+		return
+	} else if err != nil {
+		logs.Error("settle order %s error: %s", order.ExternalTransactionId, err.Error())
+		s.AjaxErr("订单结算失败")
+		return
 	}
+	accountId, _ := strconv.Atoi(order.AccountId)
+	goroutine.TrafficManager.ResetFlowLimit(accountId)
 
 	s.AjaxOk("处理成功")
 }
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"time"
+
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/lockout"
+	"github.com/astaxie/beego/logs"
+)
+
+type LockoutController struct {
+	BaseController
+}
+
+// 获取失败次数过多仍在锁定中的地址与用户名
+func (s *LockoutController) List() {
+	list, err := file.GetDb().ListLoginLocks(time.Now())
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	if list == nil {
+		list = []*file.LoginLock{}
+	}
+	s.Data["json"] = map[string]interface{}{"code": 200, "msg": "success", "data": list}
+	s.ServeJSON()
+	s.StopRun()
+}
+
+// 解除锁定并清除失败记录
+func (s *LockoutController) Unban() {
+	scope, subject := s.getEscapeString("scope"), s.GetString("subject")
+	switch scope {
+	case file.LockScopeIp, file.LockScopeUser, file.LockScopeBridge:
+	default:
+		s.AjaxErr("scope must be ip, user or bridge")
+	}
+	ok, err := lockout.Unban(scope, subject)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	if !ok {
+		s.AjaxErr("no lock record found")
+	}
+	logs.Info("%s %s unbanned", scope, subject)
+	s.AjaxOk("unban success")
+}
@@ -1,36 +1,32 @@
 package controllers
 
 import (
-	"fmt"
-	"math/rand"
-	"net"
-	"sync"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/astaxie/beego/cache"
 	"github.com/astaxie/beego/logs"
 	"github.com/astaxie/beego/utils/captcha"
-	"github.com/golang-jwt/jwt/v4"
 
 	"ehang.io/nps/lib/common"
 	"ehang.io/nps/lib/crypt"
 	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/lockout"
+	"ehang.io/nps/lib/notify"
 	"ehang.io/nps/server"
 	"github.com/astaxie/beego"
 )
 
 type LoginController struct {
 	beego.Controller
+	totpErr    error // 两步验证未通过的原因
+	totpEnroll bool  // 全局要求两步验证但账号尚未绑定
+	lockErr    error // 失败次数过多，来源地址或用户名被锁定
 }
 
-var ipRecord sync.Map
 var cpt *captcha.Captcha
 
-type record struct {
-	hasLoginFailTimes int
-	lastLoginTime     time.Time
-}
-
 func init() {
 	// use beego cache system store the captcha data
 	store := cache.NewMemoryCache()
@@ -46,23 +42,30 @@ func (self *LoginController) Index() {
 	self.Data["web_base_url"] = webBaseUrl
 	self.Data["register_allow"], _ = beego.AppConfig.Bool("allow_user_register")
 	self.Data["captcha_open"], _ = beego.AppConfig.Bool("open_captcha")
+	self.Data["oidc_open"] = oidcEnabled()
+	self.Data["forgot_open"] = notify.MailerFromConfig() != nil
 	self.TplName = "login/index.html"
 }
 
 func (self *LoginController) Verify() {
 	username := self.GetString("username")
 	password := self.GetString("password")
-	fmt.Println("Verify1:")
 	if self.doLogin(username, password, true) {
-		fmt.Println("Verify2:")
-		token := generateToken(username)
-		account, err := file.GetDb().GetByUsername(username)
-		fmt.Println(err)
+		// 使用配置文件中的管理员账号登录时没有账号记录
+		account, _ := file.GetDb().GetByUsername(username)
 		data := make(map[string]interface{})
-		data["token"] = token
 		data["account"] = account
+		accountId := 0
+		if account != nil {
+			accountId = account.Id
+		}
+		self.setTokens(data, accountId, username)
 
 		self.Data["json"] = map[string]interface{}{"code": 200, "msg": "login success", "data": data}
+	} else if self.lockErr != nil {
+		self.Data["json"] = map[string]interface{}{"code": 429, "msg": self.lockErr.Error()}
+	} else if self.totpErr != nil {
+		self.Data["json"] = map[string]interface{}{"code": 400, "msg": self.totpErr.Error(), "data": map[string]interface{}{"totp_required": true}}
 	} else {
 		self.Data["json"] = map[string]interface{}{"code": 400, "msg": "username or password incorrect"}
 	}
@@ -70,40 +73,25 @@ func (self *LoginController) Verify() {
 }
 
 func (self *LoginController) doLogin(username, password string, explicit bool) bool {
-	clearIprecord()
-	ip, _, _ := net.SplitHostPort(self.Ctx.Request.RemoteAddr)
-	fmt.Println("doLogin1:")
-	if v, ok := ipRecord.Load(ip); ok {
-		vv := v.(*record)
-		if (time.Now().Unix() - vv.lastLoginTime.Unix()) >= 60 {
-			vv.hasLoginFailTimes = 0
+	ip := remoteIp(self.Ctx.Request)
+	// 来源地址与用户名分别计数，任一锁定时不校验密码
+	if explicit {
+		if self.lockErr = lockout.Check(file.LockScopeIp, ip); self.lockErr == nil {
+			self.lockErr = lockout.Check(file.LockScopeUser, username)
 		}
-		if vv.hasLoginFailTimes >= 10 {
+		if self.lockErr != nil {
 			return false
 		}
 	}
-	fmt.Println("doLogin2:")
-	var auth bool
-	if password == beego.AppConfig.String("web_password") && username == beego.AppConfig.String("web_username") {
-		self.SetSession("isAdmin", true)
-		self.DelSession("clientId")
-		self.DelSession("username")
-		auth = true
-		server.Bridge.Register.Store(common.GetIpByAddr(self.Ctx.Input.IP()), time.Now().Add(time.Hour*time.Duration(2)))
+	var auth, isAdmin bool
+	// 配置文件中的 web_password 可以是明文或 nps hash-password 生成的哈希
+	adminOk, _ := crypt.CheckPassword(beego.AppConfig.String("web_password"), password)
+	if crypt.EqualString(username, beego.AppConfig.String("web_username")) && adminOk {
+		auth, isAdmin = true, true
 	}
-	fmt.Println("doLogin3:")
 	b, err := beego.AppConfig.Bool("allow_user_login")
 	account, err := file.GetDb().GetByUsername(username)
-	fmt.Println("doLogin31:", err, b, auth)
 	if err == nil && b && !auth {
-		fmt.Println("doLogin4:")
-
-		fmt.Println("doLogin5:")
-		if err != nil {
-			logs.Error("Failed to get account from MySQL:", err)
-			return false
-		}
-
 		// 检查账户凭据
 		if account.WebUserName == "" && account.WebPassword == "" {
 			// 特殊情况：用户名为"user"且密码为验证密钥
@@ -111,58 +99,116 @@ func (self *LoginController) doLogin(username, password string, explicit bool) b
 			if username == "user" {
 				auth = true
 			}
-		} else if account.WebPassword == password && account.WebUserName == username {
+		} else if ok, upgrade := crypt.CheckPassword(account.WebPassword, password); ok && account.WebUserName == username {
 			auth = true
+			// 旧版本保存的明文密码在登录成功后改为保存哈希
+			if upgrade {
+				if err := file.GetDb().UpdatePassword(account.Id, password); err != nil {
+					logs.Warn("upgrade password hash of account %d error %s", account.Id, err)
+				}
+			}
 		}
-
-		if auth {
-			self.SetSession("isAdmin", false)
-			self.SetSession("clientId", account.Id)
-			self.SetSession("username", account.WebUserName)
+	}
+	// 密码校验通过后再校验两步验证码，未提交验证码不计入失败次数
+	if auth {
+		var verified bool
+		totpId := file.AdminTotpId
+		if !isAdmin {
+			totpId = account.Id
+		}
+		if verified, self.totpErr = checkTotp(totpId, self.GetString("totp")); self.totpErr == errTotpRequired {
+			return false
+		} else if self.totpErr != nil {
+			auth = false
+		} else {
+			self.setTotpSession(totpId, verified)
 		}
 	}
-	fmt.Println("doLogin6:")
 	if auth {
-		fmt.Println("doLogin61:")
+		if isAdmin {
+			setRoleSession(self, file.RoleAdmin)
+			self.DelSession("clientId")
+			self.DelSession("username")
+			server.Bridge.Register.Store(common.GetIpByAddr(self.Ctx.Input.IP()), time.Now().Add(time.Hour*time.Duration(2)))
+		} else {
+			setRoleSession(self, account.Role)
+			self.SetSession("clientId", account.Id)
+			self.SetSession("username", account.WebUserName)
+		}
 		self.SetSession("auth", true)
-		self.SetSession("clientId", account.Id)
-		self.SetSession("username", account.WebUserName)
-		ipRecord.Delete(ip)
-		fmt.Println("doLogin62:")
+		if explicit {
+			lockout.Succeed(file.LockScopeIp, ip)
+			lockout.Succeed(file.LockScopeUser, username)
+		}
 		return true
 	}
-	fmt.Println("doLogin7:")
-	if v, load := ipRecord.LoadOrStore(ip, &record{hasLoginFailTimes: 1, lastLoginTime: time.Now()}); load && explicit {
-		vv := v.(*record)
-		vv.lastLoginTime = time.Now()
-		vv.hasLoginFailTimes += 1
-		ipRecord.Store(ip, vv)
+	if explicit {
+		lockout.Fail(file.LockScopeIp, ip)
+		lockout.Fail(file.LockScopeUser, username)
 	}
 	return false
 }
 
+// setTotpSession 记录登录用户的两步验证状态，全局要求两步验证但尚未绑定时只能访问绑定接口
+func (self *LoginController) setTotpSession(totpId int, verified bool) {
+	self.totpEnroll = !verified && file.GetDb().GetGlobal().RequireTotp
+	self.SetSession("totpId", totpId)
+	self.SetSession("totpVerified", verified)
+	if self.totpEnroll {
+		self.SetSession("totpEnroll", true)
+	} else {
+		self.DelSession("totpEnroll")
+	}
+}
+
 func (self *LoginController) VerifyForWx() {
 	openId := self.GetString("openId")
 	headImgUrl := self.GetString("headImgUrl")
 	nickName := self.GetString("nickname")
-	fmt.Println("Verify1:")
-	if self.doLoginForWx(openId, headImgUrl, nickName) {
-		fmt.Println("Verify2:")
-		token := generateToken(openId)
+	if err := self.doLoginForWx(openId, headImgUrl, nickName); err == nil {
 		account := file.GetDb().GetByUsernameNoErr(openId)
 		data := make(map[string]interface{})
-		data["token"] = token
 		data["account"] = account
+		self.setTokens(data, account.Id, openId)
 
 		self.Data["json"] = map[string]interface{}{"code": 200, "msg": "login success", "data": data}
+	} else if self.lockErr != nil {
+		self.Data["json"] = map[string]interface{}{"code": 429, "msg": self.lockErr.Error()}
+	} else if self.totpErr != nil {
+		self.Data["json"] = map[string]interface{}{"code": 400, "msg": self.totpErr.Error(), "data": map[string]interface{}{"totp_required": true}}
 	} else {
-		self.Data["json"] = map[string]interface{}{"code": 400, "msg": "username or password incorrect"}
+		self.Data["json"] = map[string]interface{}{"code": 400, "msg": err.Error()}
 	}
 	self.ServeJSON()
 }
 
-func (self *LoginController) doLoginForWx(username, headImgUrl, nickName string) bool {
-	fmt.Println("doLogin3:")
+// doLoginForWx 微信登录只能登录普通用户，全局角色与代理商的账号需要使用密码登录
+// 与密码登录共用失败计数，来源地址或用户名锁定时拒绝登录
+func (self *LoginController) doLoginForWx(username, headImgUrl, nickName string) (err error) {
+	if username == "" {
+		return errors.New("openId is required")
+	}
+	ip := remoteIp(self.Ctx.Request)
+	if self.lockErr = lockout.Check(file.LockScopeIp, ip); self.lockErr == nil {
+		self.lockErr = lockout.Check(file.LockScopeUser, username)
+	}
+	if self.lockErr != nil {
+		return self.lockErr
+	}
+	defer func() {
+		if err == nil {
+			lockout.Succeed(file.LockScopeIp, ip)
+			lockout.Succeed(file.LockScopeUser, username)
+		} else if err != errTotpRequired {
+			lockout.Fail(file.LockScopeIp, ip)
+			lockout.Fail(file.LockScopeUser, username)
+		}
+	}()
+	// 配置文件中的管理员没有账号记录，不能创建同名账号
+	if username == beego.AppConfig.String("web_username") {
+		logs.Warn("wechat login of admin username %s refused", username)
+		return errors.New("account can not login with wechat")
+	}
 	account := file.GetDb().GetByUsernameNoErr(username)
 	if account == nil || account.Id == 0 {
 		t := &file.Account{
@@ -173,20 +219,44 @@ func (self *LoginController) doLoginForWx(username, headImgUrl, nickName string)
 			NickName:    nickName,
 			HeadImgUrl:  headImgUrl,
 			Flow:        &file.Flow{},
+			// 微信登录的账号没有邮箱，不需要验证
+			EmailVerified: true,
 		}
 		if err := file.GetDb().NewAccount(t); err != nil {
-			fmt.Println("doLogin30:", err)
+			logs.Error("create account %s for wechat login error %v", username, err)
+			return err
 		}
-		account = file.GetDb().GetByUsernameNoErr(username)
+		account = t
+	} else if err := wxAccountErr(account); err != nil {
+		logs.Warn("wechat login of account %s refused: %v", account.WebUserName, err)
+		return errors.New("account can not login with wechat")
+	}
+	verified, err := checkTotp(account.Id, self.GetString("totp"))
+	if err != nil {
+		self.totpErr = err
+		return err
 	}
-	fmt.Println("doLogin5:")
+	self.setTotpSession(account.Id, verified)
 
-	self.SetSession("isAdmin", false)
+	setRoleSession(self, file.RoleOwner)
 	self.SetSession("clientId", account.Id)
 	self.SetSession("username", account.WebUserName)
 	self.SetSession("auth", true)
-	fmt.Println("doLogin62:")
-	return true
+	return nil
+}
+
+// wxAccountErr 只有微信登录创建的账号可以使用微信登录，这类账号没有密码、没有关联身份提供方，且是普通用户
+func wxAccountErr(a *file.Account) error {
+	if a.WebPassword != "" {
+		return errors.New("account has a password")
+	}
+	if a.Role != file.RoleOwner {
+		return errors.New("role " + a.Role + " is not allowed")
+	}
+	if linked, err := file.GetDb().HasOidcIdentity(a.Id); err != nil || linked {
+		return errors.New("account is linked to an identity provider")
+	}
+	return nil
 }
 
 func (self *LoginController) Register() {
@@ -204,17 +274,34 @@ func (self *LoginController) Register() {
 			self.ServeJSON()
 			return
 		}
+		email := strings.TrimSpace(self.GetString("email"))
+		if email != "" || emailVerifyRequired() {
+			var err error
+			if email, err = parseEmail(email); err != nil {
+				self.Data["json"] = map[string]interface{}{"code": 400, "msg": err.Error()}
+				self.ServeJSON()
+				return
+			}
+		}
 		t := &file.Account{
 			Status:      true,
 			Cnf:         &file.Config{},
 			WebUserName: self.GetString("username"),
 			WebPassword: self.GetString("password"),
 			Flow:        &file.Flow{},
+			Email:       email,
+			// 填写了邮箱时需要验证后才能用于找回密码
+			EmailVerified: email == "",
 		}
 		if err := file.GetDb().NewAccount(t); err != nil {
 			self.Data["json"] = map[string]interface{}{"code": 400, "msg": err.Error()}
-		} else {
+		} else if email == "" {
 			self.Data["json"] = map[string]interface{}{"code": 200, "msg": "register success"}
+		} else if err := sendAccountToken(t, file.TokenVerifyEmail); err != nil {
+			logs.Error("send verification email to account %s error %v", t.WebUserName, err)
+			self.Data["json"] = map[string]interface{}{"code": 200, "msg": "register success, but sending verification email failed, please resend it after login"}
+		} else {
+			self.Data["json"] = map[string]interface{}{"code": 200, "msg": "register success, please check your email to verify it"}
 		}
 		self.ServeJSON()
 	}
@@ -225,42 +312,30 @@ func (self *LoginController) Out() {
 	self.Redirect(beego.AppConfig.String("web_base_url")+"/login/index", 302)
 }
 
-// generateToken creates a JWT token for the user
-func generateToken(username string) string {
-	// Use auth_key from config as JWT secret
-	secret := beego.AppConfig.String("auth_key")
-	if secret == "" {
-		secret = crypt.GetRandomString(64)
+// 使用 refresh token 换取新的 access token，refresh token 同时轮换，旧的 refresh token 失效
+func (self *LoginController) Refresh() {
+	data, err := refreshTokens(self.GetString("refresh_token"))
+	if err != nil {
+		self.Ctx.Output.SetStatus(401)
+		self.Data["json"] = map[string]interface{}{"code": 401, "msg": err.Error()}
+	} else {
+		self.Data["json"] = map[string]interface{}{"code": 200, "msg": "success", "data": data}
 	}
-	account, err := file.GetDb().GetByUsername(username)
-	fmt.Println("generateToken1:", account)
-	// Create token with 24h expiration
-	fmt.Println("accountId:", account.Id)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username":  username,
-		"accountId": account.Id,
-		"exp":       time.Now().Add(time.Hour * 2400000).Unix(),
-	})
+	self.ServeJSON()
+}
 
-	// Sign and get the complete encoded token as a string
-	tokenString, err := token.SignedString([]byte(secret))
+// setTokens 登录成功后签发 token，尚未绑定两步验证时只能访问绑定接口，不签发 token
+func (self *LoginController) setTokens(data map[string]interface{}, accountId int, username string) {
+	if self.totpEnroll {
+		data["totp_enroll_required"] = true
+		return
+	}
+	tokens, err := issueTokens(self.Ctx, accountId, username, self.GetSession("role").(string), self.GetSession("totpVerified") == true)
 	if err != nil {
 		logs.Error("Failed to generate token:", err)
-		return ""
+		return
 	}
-	return tokenString
-}
-
-func clearIprecord() {
-	rand.Seed(time.Now().UnixNano())
-	x := rand.Intn(100)
-	if x == 1 {
-		ipRecord.Range(func(key, value interface{}) bool {
-			v := value.(*record)
-			if time.Now().Unix()-v.lastLoginTime.Unix() >= 60 {
-				ipRecord.Delete(key)
-			}
-			return true
-		})
+	for k, v := range tokens {
+		data[k] = v
 	}
 }
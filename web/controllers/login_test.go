@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"ehang.io/nps/bridge"
+	"ehang.io/nps/lib/crypt"
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/lockout"
+	"ehang.io/nps/server"
+	"github.com/astaxie/beego"
+)
+
+func TestWxLoginNeverGrantsGlobalRole(t *testing.T) {
+	db := newTestDb(t)
+	newTestAccount(t, db, "wx-admin", "", file.RoleAdmin)
+
+	r := newTestRequest("192.0.2.20", url.Values{"openId": {"wx-admin"}}, nil)
+	c := &LoginController{}
+	if res := r.serve(t, c, "LoginController", "VerifyForWx", c.VerifyForWx); code(res) != 400 {
+		t.Fatalf("global role account logged in with wechat: %v", res)
+	}
+	if role := c.GetSession("role"); role != nil {
+		t.Fatalf("role %v set after refused login", role)
+	}
+
+	r = newTestRequest("192.0.2.20", url.Values{"openId": {"wx-new"}}, nil)
+	c = &LoginController{}
+	if res := r.serve(t, c, "LoginController", "VerifyForWx", c.VerifyForWx); code(res) != 200 {
+		t.Fatalf("wechat login %v", res)
+	}
+	if role := c.GetSession("role"); role != file.RoleOwner || c.GetSession("isAdmin") != false {
+		t.Fatalf("wechat login role %v", role)
+	}
+}
+
+func TestLockedUserRefusedBeforePassword(t *testing.T) {
+	db := newTestDb(t)
+	beego.AppConfig.Set("allow_user_login", "true")
+	newTestAccount(t, db, "bob", "password", file.RoleOwner)
+	p := lockout.PolicyFromConfig()
+	p.MaxFailures = 1
+	if _, err := db.RecordLoginFailure(file.LockScopeUser, "bob", p, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 正确的密码也被拒绝，且不再计入失败
+	c := &LoginController{}
+	r := newTestRequest("192.0.2.50", url.Values{"username": {"bob"}, "password": {"password"}}, nil)
+	if res := r.serve(t, c, "LoginController", "Verify", c.Verify); code(res) != 429 {
+		t.Fatalf("locked user logged in %v", res)
+	}
+	if c.GetSession("auth") != nil {
+		t.Fatal("session authenticated for locked user")
+	}
+	if locks, _ := db.ListLoginLocks(time.Now()); len(locks) != 1 || locks[0].Failures != 0 {
+		t.Fatalf("locks %+v", locks)
+	}
+
+	// 锁定来源地址后微信登录同样被拒绝
+	if _, err := db.RecordLoginFailure(file.LockScopeIp, "192.0.2.51", p, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	c = &LoginController{}
+	r = newTestRequest("192.0.2.51", url.Values{"openId": {"wx-new"}}, nil)
+	if res := r.serve(t, c, "LoginController", "VerifyForWx", c.VerifyForWx); code(res) != 429 {
+		t.Fatalf("wechat login from locked ip %v", res)
+	}
+	if a := db.GetByUsernameNoErr("wx-new"); a.Id != 0 {
+		t.Fatal("account created from locked ip")
+	}
+}
+
+func TestWxLoginRefusesOtherAccounts(t *testing.T) {
+	db := newTestDb(t)
+	newTestAccount(t, db, "local", "password", file.RoleOwner)
+	oidc := newTestAccount(t, db, "oidc", "", file.RoleOwner)
+	if err := db.LinkOidcIdentity("https://idp", "u-1", oidc.Id); err != nil {
+		t.Fatal(err)
+	}
+	newTestAccount(t, db, "wx-user", "", file.RoleOwner)
+	for name, ok := range map[string]bool{"local": false, "oidc": false, "admin": false, "wx-user": true} {
+		c := &LoginController{}
+		r := newTestRequest("192.0.2.60", url.Values{"openId": {name}}, nil)
+		if res := r.serve(t, c, "LoginController", "VerifyForWx", c.VerifyForWx); (code(res) == 200) != ok {
+			t.Errorf("wechat login of %s: %v", name, res)
+		}
+	}
+}
+
+func TestAdminLogin(t *testing.T) {
+	newTestDb(t)
+	server.Bridge = &bridge.Bridge{}
+	hash, err := crypt.HashPassword("admin-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stored := range []string{"admin-password", hash} {
+		beego.AppConfig.Set("web_password", stored)
+		c := &LoginController{}
+		r := newTestRequest("192.0.2.70", url.Values{"username": {"admin"}, "password": {"admin-password"}}, map[string]interface{}{"clientId": 5, "username": "alice"})
+		if res := r.serve(t, c, "LoginController", "Verify", c.Verify); code(res) != 200 {
+			t.Fatalf("admin login with web_password %q: %v", stored, res)
+		}
+		if c.GetSession("role") != file.RoleAdmin || c.GetSession("isAdmin") != true {
+			t.Fatalf("admin role %v", c.GetSession("role"))
+		}
+		if c.GetSession("clientId") != nil || c.GetSession("username") != nil {
+			t.Fatal("account session kept after admin login")
+		}
+	}
+	beego.AppConfig.Set("web_password", "admin-password")
+
+	c := &LoginController{}
+	r := newTestRequest("192.0.2.70", url.Values{"username": {"admin"}, "password": {"wrong"}}, nil)
+	if res := r.serve(t, c, "LoginController", "Verify", c.Verify); code(res) != 400 || c.GetSession("auth") != nil {
+		t.Fatalf("admin login with wrong password %v", res)
+	}
+}
+
+// 找回密码计入失败次数，同一用户名或来源地址申请过多时被锁定
+func TestForgotPasswordRateLimited(t *testing.T) {
+	newTestDb(t)
+	forgot := func(ip, name string) int {
+		c := &LoginController{}
+		r := newTestRequest(ip, url.Values{"username": {name}}, nil)
+		return code(r.serve(t, c, "LoginController", "ForgotPassword", c.ForgotPassword))
+	}
+	max := lockout.PolicyFromConfig().MaxFailures
+	for i := 0; i < max; i++ {
+		if c := forgot(fmt.Sprintf("192.0.2.%d", 100+i), "carol"); c != 200 {
+			t.Fatalf("request %d refused %d", i, c)
+		}
+	}
+	if c := forgot("192.0.2.99", "carol"); c != 429 {
+		t.Fatalf("username not limited %d", c)
+	}
+	for i := 0; i < max; i++ {
+		if c := forgot("192.0.2.98", fmt.Sprintf("dave%d", i)); c != 200 {
+			t.Fatalf("request %d refused %d", i, c)
+		}
+	}
+	if c := forgot("192.0.2.98", "erin"); c != 429 {
+		t.Fatalf("ip not limited %d", c)
+	}
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"strings"
+
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/notify"
+)
+
+type NotifyController struct {
+	BaseController
+}
+
+// notifyAccountId 普通用户只能操作自己账号的通知设置，可以管理所有账号的角色可以通过 account_id 指定账号
+func (s *NotifyController) notifyAccountId() int {
+	if s.GetSession("isAdmin") == true && s.can(file.PermAccount) {
+		if id := s.GetIntNoErr("account_id"); id > 0 {
+			return id
+		}
+	}
+	id := s.GetSessionIntNoErr("accountId", 0)
+	if id <= 0 {
+		s.AjaxErr("account is required")
+	}
+	return id
+}
+
+// 获取通知设置
+func (s *NotifyController) Get() {
+	setting, err := file.GetDb().GetNotifySetting(s.notifyAccountId())
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.Data["json"] = map[string]interface{}{
+		"code": 200,
+		"msg":  "success",
+		"data": setting,
+	}
+	s.ServeJSON()
+	s.StopRun()
+}
+
+// 保存通知设置
+func (s *NotifyController) Save() {
+	setting := &file.NotifySetting{
+		AccountId:  s.notifyAccountId(),
+		Email:      s.getEscapeString("email"),
+		WebhookUrl: strings.TrimSpace(s.GetString("webhook_url")),
+		Thresholds: s.GetString("thresholds", file.DefaultThresholds),
+		Expiry:     s.GetBoolNoErr("expiry", true),
+		Enabled:    s.GetBoolNoErr("enabled", true),
+	}
+	if err := file.GetDb().SaveNotifySetting(setting); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("save success")
+}
+
+// 按已保存的设置发送一条测试通知
+func (s *NotifyController) Test() {
+	id := s.notifyAccountId()
+	setting, err := file.GetDb().GetNotifySetting(id)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	if setting.Email == "" && setting.WebhookUrl == "" {
+		s.AjaxErr("no email or webhook url configured")
+	}
+	e := &notify.Event{Kind: notify.KindTest, AccountId: id, Subject: "测试通知", Message: "这是一条测试通知，收到说明通知设置正确。"}
+	if a, err := file.GetDb().GetAccountInfo(id); err == nil {
+		e.UserName = a.WebUserName
+	}
+	if err := notify.FromConfig().Notify(setting, e); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("test notification sent")
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"ehang.io/nps/lib/crypt"
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/oidc"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+)
+
+var (
+	oidcMu       sync.Mutex
+	oidcProvider *oidc.Provider
+)
+
+// oidcEnabled 是否配置了 OpenID Connect 登录
+func oidcEnabled() bool {
+	return beego.AppConfig.String("oidc_issuer") != ""
+}
+
+// getOidcProvider 第一次使用时读取身份提供方的 discovery 文档，失败时下次登录重试
+func getOidcProvider() (*oidc.Provider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+	cfg, err := oidc.FromConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return nil, errors.New("oidc login is not enabled")
+	}
+	if oidcProvider, err = oidc.NewProvider(cfg, nil); err != nil {
+		return nil, err
+	}
+	return oidcProvider, nil
+}
+
+// oidcAccount 返回身份关联的账号，第一次登录时创建账号并关联，配置了用户组映射时每次登录同步角色
+func oidcAccount(id *oidc.Identity) (*file.Account, error) {
+	db := file.GetDb()
+	accountId, err := db.GetOidcAccountId(id.Issuer, id.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if accountId == 0 {
+		if !beego.AppConfig.DefaultBool("oidc_allow_register", true) {
+			return nil, fmt.Errorf("user %s is not registered", id.Username)
+		}
+		if id.Username == beego.AppConfig.String("web_username") || !db.VerifyUserName(id.Username, 0) {
+			return nil, fmt.Errorf("username %s is already used by another account", id.Username)
+		}
+		a := file.NewAccount()
+		a.WebUserName = id.Username
+		// 通过身份提供方登录的账号不能使用密码登录
+		a.WebPassword = crypt.RandomHex(32)
+		a.NickName = id.Name
+		a.Remark = id.Email
+		if file.ValidRole(id.Role) {
+			a.Role = id.Role
+		}
+		if err := db.NewAccount(a); err != nil {
+			return nil, err
+		}
+		if err := db.LinkOidcIdentity(id.Issuer, id.Subject, a.Id); err != nil {
+			return nil, err
+		}
+		logs.Info("account %s created by oidc login", a.WebUserName)
+		accountId = a.Id
+	}
+	a, err := db.GetAccountInfo(accountId)
+	if err != nil {
+		return nil, err
+	}
+	if !a.Status {
+		return nil, fmt.Errorf("account %s is disabled", a.WebUserName)
+	}
+	if id.Role != "" && id.Role != a.Role {
+		if !file.ValidRole(id.Role) {
+			logs.Warn("oidc role %s of %s is invalid, ignored", id.Role, a.WebUserName)
+		} else if err := db.SetAccountRole(a.Id, id.Role, a.ResellerId); err != nil {
+			return nil, err
+		} else {
+			a.Role = id.Role
+		}
+	}
+	return a, nil
+}
+
+// 跳转到身份提供方登录
+func (self *LoginController) OidcLogin() {
+	p, err := getOidcProvider()
+	if err != nil {
+		logs.Error("oidc login unavailable: %v", err)
+		self.Ctx.Output.SetStatus(503)
+		self.Ctx.WriteString("oidc login unavailable")
+		return
+	}
+	state, nonce := crypt.RandomHex(16), crypt.RandomHex(16)
+	self.SetSession("oidcState", state)
+	self.SetSession("oidcNonce", nonce)
+	self.Redirect(p.AuthCodeUrl(state, nonce), 302)
+}
+
+// 身份提供方登录后的回调，浏览器访问时登录后跳转到首页，Accept 为 application/json 时与 /login/verify 一样返回 token
+func (self *LoginController) OidcCallback() {
+	account, err := self.doLoginForOidc()
+	asJson := strings.HasPrefix(self.Ctx.Input.Header("Accept"), "application/json")
+	if err != nil {
+		logs.Warn("oidc login failed: %v", err)
+		if asJson {
+			self.Data["json"] = map[string]interface{}{"code": 400, "msg": err.Error()}
+			self.ServeJSON()
+			return
+		}
+		self.Ctx.Output.SetStatus(403)
+		self.Ctx.WriteString("oidc login failed: " + err.Error())
+		return
+	}
+	if asJson {
+		account.WebPassword = ""
+		data := map[string]interface{}{"account": account}
+		self.setTokens(data, account.Id, account.WebUserName)
+		self.Data["json"] = map[string]interface{}{"code": 200, "msg": "login success", "data": data}
+		self.ServeJSON()
+		return
+	}
+	self.Redirect(beego.AppConfig.String("web_base_url")+"/index/index", 302)
+}
+
+// doLoginForOidc 校验回调参数并换取 ID token，身份提供方负责两步验证，登录后视为已通过两步验证
+func (self *LoginController) doLoginForOidc() (*file.Account, error) {
+	state, _ := self.GetSession("oidcState").(string)
+	nonce, _ := self.GetSession("oidcNonce").(string)
+	self.DelSession("oidcState")
+	self.DelSession("oidcNonce")
+	if e := self.GetString("error"); e != "" {
+		return nil, fmt.Errorf("%s: %s", e, self.GetString("error_description"))
+	}
+	if state == "" || !crypt.EqualString(state, self.GetString("state")) {
+		return nil, errors.New("invalid state")
+	}
+	p, err := getOidcProvider()
+	if err != nil {
+		return nil, err
+	}
+	id, err := p.Exchange(self.GetString("code"), nonce)
+	if err != nil {
+		return nil, err
+	}
+	account, err := oidcAccount(id)
+	if err != nil {
+		return nil, err
+	}
+	self.setTotpSession(account.Id, true)
+	setRoleSession(self, account.Role)
+	self.SetSession("clientId", account.Id)
+	self.SetSession("accountId", account.Id)
+	self.SetSession("username", account.WebUserName)
+	self.SetSession("auth", true)
+	return account, nil
+}
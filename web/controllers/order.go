@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"bytes"
+	"strconv"
+
+	"ehang.io/nps/lib/file"
+	"ehang.io/nps/lib/goroutine"
+	"ehang.io/nps/lib/payment"
+	"github.com/astaxie/beego/logs"
+)
+
+type OrderController struct {
+	BaseController
+}
+
+// 订单列表，普通用户只能查看自己的订单，管理员可以通过 account_id 筛选账号
+func (s *OrderController) List() {
+	start, length := s.GetAjaxParams()
+	f := file.OrderFilter{
+		Status:      s.getEscapeString("status"),
+		PaymentType: s.getEscapeString("payment_type"),
+		Search:      s.getEscapeString("search"),
+		From:        int64(s.GetIntNoErr("start_time")),
+		To:          int64(s.GetIntNoErr("end_time")),
+	}
+	if s.GetSession("isAdmin") == true {
+		f.AccountId = s.GetIntNoErr("account_id")
+	} else if f.AccountId = s.GetSessionIntNoErr("accountId", 0); f.AccountId == 0 {
+		s.AjaxErr("permission denied")
+	}
+	list, cnt, err := file.GetDb().GetOrderList(start, length, f, s.getEscapeString("sort"), s.getEscapeString("order"))
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxTable(list, cnt, cnt, nil)
+}
+
+// 已支付或已退款订单的可打印收据
+func (s *OrderController) Invoice() {
+	o := s.ownOrder()
+	c, err := file.GetDb().GetOrderCredit(o.OrderId)
+	if err != nil {
+		s.AjaxErr("invoices are only available for paid orders")
+	}
+	accountId, _ := strconv.Atoi(o.AccountId)
+	account, _ := file.GetDb().GetAccountInfo(accountId)
+	var plan *file.Plan
+	if o.PlanId > 0 {
+		plan, _ = file.GetDb().GetPlan(o.PlanId)
+	}
+	inv, err := payment.NewInvoice(o, c, account, plan)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	var buf bytes.Buffer
+	if err := inv.Render(&buf); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.Ctx.Output.Header("Content-Type", "text/html; charset=utf-8")
+	s.Ctx.Output.Body(buf.Bytes())
+	s.StopRun()
+}
+
+// 管理员全额退款，支付渠道退款成功后撤销账号的流量、月数与套餐限制
+func (s *OrderController) Refund() {
+	if s.GetSession("isAdmin") != true {
+		s.AjaxErr("permission denied")
+	}
+	o := s.ownOrder()
+	provider, err := payment.Default()
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	o, err = file.GetDb().RefundOrder(o.ExternalTransactionId, func(o *file.Order) error {
+		return provider.Refund(o, o.OrderAmount)
+	})
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	logs.Info("order %s refunded by %v", o.ExternalTransactionId, s.GetSession("username"))
+	accountId, _ := strconv.Atoi(o.AccountId)
+	goroutine.TrafficManager.ResetFlowLimit(accountId)
+	s.AjaxOk("refund success")
+}
+
+// ownOrder 根据 id 参数（外部交易号）获取订单，普通用户只能获取自己的订单
+func (s *OrderController) ownOrder() *file.Order {
+	o, err := file.GetDb().GetOrderByExternalId(s.getEscapeString("id"))
+	if err != nil {
+		s.AjaxErr("order not found")
+	}
+	if s.GetSession("isAdmin") != true && o.AccountId != strconv.Itoa(s.GetSessionIntNoErr("accountId", 0)) {
+		s.AjaxErr("order not found")
+	}
+	return o
+}
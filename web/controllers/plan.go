@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"ehang.io/nps/lib/file"
+)
+
+type PlanController struct {
+	BaseController
+}
+
+// 套餐列表，包含已下架的套餐，套餐管理只对管理员开放
+func (s *PlanController) List() {
+	if s.GetSession("isAdmin") != true {
+		s.AjaxErr("permission denied")
+	}
+	list, err := file.GetDb().GetPlans(false)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxTable(list, len(list), len(list), nil)
+}
+
+// 保存套餐，id 为 0 时新建
+func (s *PlanController) Save() {
+	if s.GetSession("isAdmin") != true {
+		s.AjaxErr("permission denied")
+	}
+	p := &file.Plan{
+		Id:         s.GetIntNoErr("id"),
+		Name:       s.getEscapeString("name"),
+		RateLimit:  s.GetIntNoErr("rate_limit"),
+		MaxClients: s.GetIntNoErr("max_clients"),
+		MaxTunnels: s.GetIntNoErr("max_tunnels"),
+		Months:     s.GetIntNoErr("months"),
+		Status:     s.GetBoolNoErr("status"),
+		Remark:     s.getEscapeString("remark"),
+	}
+	var err error
+	if p.Flow, err = s.GetFloat("flow", 0); err != nil {
+		s.AjaxErr("flow must be a number")
+	}
+	if p.Price, err = s.GetFloat("price", 0); err != nil {
+		s.AjaxErr("price must be a number")
+	}
+	if err := file.GetDb().SavePlan(p); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOkWithId("save success", p.Id)
+}
+
+// 删除套餐
+func (s *PlanController) Del() {
+	if s.GetSession("isAdmin") != true {
+		s.AjaxErr("permission denied")
+	}
+	if err := file.GetDb().DelPlan(s.GetIntNoErr("id")); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("delete success")
+}
+
+// accountLimitErr 检查账号套餐的客户端与隧道数上限，addClient 为 true 时检查客户端数，否则检查隧道数
+func accountLimitErr(accountId int, addClient bool) string {
+	if accountId <= 0 {
+		return ""
+	}
+	account, err := file.GetDb().GetAccountInfo(accountId)
+	if err != nil {
+		return err.Error()
+	}
+	if addClient {
+		if !account.EmailVerified && emailVerifyRequired() {
+			return "Please verify your email address before adding clients"
+		}
+		if account.MaxClientNum == 0 {
+			return ""
+		}
+		clients, err := file.GetDb().GetAllClients()
+		if err != nil {
+			return err.Error()
+		}
+		n := 0
+		for _, c := range clients {
+			if c.AccountId == accountId {
+				n++
+			}
+		}
+		if n >= account.MaxClientNum {
+			return "The number of clients exceeds the limit of your plan"
+		}
+		return ""
+	}
+	if account.MaxTunnelNum == 0 {
+		return ""
+	}
+	if tasks, err := file.GetDb().GetUserTasks(accountId, 0); err != nil {
+		return err.Error()
+	} else if len(tasks) >= account.MaxTunnelNum {
+		return "The number of tunnels exceeds the limit of your plan"
+	}
+	return ""
+}
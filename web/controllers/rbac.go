@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"ehang.io/nps/lib/file"
+	"github.com/astaxie/beego/logs"
+)
+
+// actionPerms 每个接口需要的权限，键为小写的 控制器.方法，未列出的接口只有拥有 file.PermSystem 的角色可以访问。
+// 非全局角色的数据范围仍由 CheckUserAuth 与各接口按账号限制
+var actionPerms = map[string]string{
+	"index.index":              file.PermRead,
+	"index.help":               file.PermRead,
+	"index.tcp":                file.PermRead,
+	"index.udp":                file.PermRead,
+	"index.socks5":             file.PermRead,
+	"index.http":               file.PermRead,
+	"index.file":               file.PermRead,
+	"index.secret":             file.PermRead,
+	"index.p2p":                file.PermRead,
+	"index.host":               file.PermRead,
+	"index.all":                file.PermRead,
+	"index.gettunnel":          file.PermRead,
+	"index.gettunnelv2":        file.PermRead,
+	"index.getonetunnel":       file.PermRead,
+	"index.hostlist":           file.PermRead,
+	"index.gethost":            file.PermRead,
+	"index.add":                file.PermWrite,
+	"index.edit":               file.PermWrite,
+	"index.stop":               file.PermWrite,
+	"index.start":              file.PermWrite,
+	"index.del":                file.PermWrite,
+	"index.addhost":            file.PermWrite,
+	"index.edithost":           file.PermWrite,
+	"index.delhost":            file.PermWrite,
+	"index.getpriceplan":       file.PermSelf,
+	"index.createpaymentorder": file.PermSelf,
+	"client.list":              file.PermRead,
+	"client.getclient":         file.PermRead,
+	"client.add":               file.PermWrite,
+	"client.edit":              file.PermWrite,
+	"client.changestatus":      file.PermWrite,
+	"client.del":               file.PermWrite,
+	"traffic.history":          file.PermRead,
+	"account.list":             file.PermRead,
+	"account.save":             file.PermAccount,
+	"account.role":             file.PermSystem,
+	"order.list":               file.PermSelf,
+	"order.invoice":            file.PermSelf,
+	"order.refund":             file.PermBilling,
+	"plan.list":                file.PermBilling,
+	"plan.save":                file.PermBilling,
+	"plan.del":                 file.PermBilling,
+	"coupon.list":              file.PermBilling,
+	"coupon.save":              file.PermBilling,
+	"coupon.del":               file.PermBilling,
+	"notify.get":               file.PermSelf,
+	"notify.save":              file.PermSelf,
+	"notify.test":              file.PermSelf,
+	"totp.status":              file.PermSelf,
+	"totp.enroll":              file.PermSelf,
+	"totp.confirm":             file.PermSelf,
+	"totp.disable":             file.PermSelf,
+	"totp.require":             file.PermSystem,
+	"apikey.list":              file.PermSelf,
+	"apikey.create":            file.PermSelf,
+	"apikey.revoke":            file.PermSelf,
+	"session.list":             file.PermSelf,
+	"session.revoke":           file.PermSelf,
+	"session.revokeall":        file.PermSelf,
+	"session.logout":           file.PermSelf,
+	"email.info":               file.PermSelf,
+	"email.save":               file.PermSelf,
+	"email.resend":             file.PermSelf,
+	"global.index":             file.PermSystem,
+	"global.save":              file.PermSystem,
+	"global.export":            file.PermSystem,
+	"global.apply":             file.PermSystem,
+	"lockout.list":             file.PermSystem,
+	"lockout.unban":            file.PermSystem,
+}
+
+// role 当前登录用户的角色，升级前建立的管理员会话与 auth_key 访问视为管理员
+func (s *BaseController) role() string {
+	if r, ok := s.GetSession("role").(string); ok && r != "" {
+		return r
+	}
+	if s.GetSession("isAdmin") == true {
+		return file.RoleAdmin
+	}
+	return file.RoleOwner
+}
+
+// can 当前登录用户是否拥有权限
+func (s *BaseController) can(perm string) bool {
+	return file.RoleHas(s.role(), perm)
+}
+
+// checkPermission 校验当前用户是否可以访问本接口
+func (s *BaseController) checkPermission() {
+	perm, ok := actionPerms[s.controllerName+"."+s.actionName]
+	if !ok {
+		perm = file.PermSystem
+	}
+	if !s.can(perm) {
+		logs.Warn("role %s has no %s permission for %s.%s", s.role(), perm, s.controllerName, s.actionName)
+		s.AjaxErr("permission denied")
+	}
+	if s.apiKey != nil && !s.apiKey.HasScope(actionScopes[s.controllerName+"."+s.actionName]) {
+		logs.Warn("api key %d has no scope for %s.%s", s.apiKey.Id, s.controllerName, s.actionName)
+		s.AjaxErr("permission denied")
+	}
+}
+
+// setRoleSession 登录成功后记录角色，全局角色可以访问所有账号的数据
+func setRoleSession(s interface {
+	SetSession(name interface{}, value interface{})
+}, role string) {
+	if !file.ValidRole(role) {
+		role = file.RoleOwner
+	}
+	s.SetSession("role", role)
+	s.SetSession("isAdmin", file.RoleIsGlobal(role))
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"net/url"
+	"testing"
+
+	"ehang.io/nps/lib/file"
+)
+
+func TestUnlistedActionRequiresSystem(t *testing.T) {
+	db := newTestDb(t)
+	a := newTestAccount(t, db, "operator", "password", file.RoleOperator)
+	session := func(role string) map[string]interface{} {
+		return map[string]interface{}{"auth": true, "username": a.WebUserName, "accountId": a.Id, "clientId": a.Id, "role": role, "isAdmin": file.RoleIsGlobal(role)}
+	}
+	for _, c := range []struct {
+		role, controller, action string
+		allowed                  bool
+	}{
+		{file.RoleOperator, "IndexController", "Add", true},
+		{file.RoleOperator, "IndexController", "Unlisted", false},
+		{file.RoleOperator, "GlobalController", "Save", false},
+		{file.RoleAdmin, "IndexController", "Unlisted", true},
+		{file.RoleOwner, "AccountController", "Role", false},
+	} {
+		res := newTestRequest("192.0.2.1", url.Values{}, session(c.role)).serve(t, &BaseController{}, c.controller, c.action, nil)
+		if denied := res != nil && res["msg"] == "permission denied"; denied == c.allowed {
+			t.Errorf("%s %s.%s allowed %v, response %v", c.role, c.controller, c.action, !denied, res)
+		}
+	}
+}
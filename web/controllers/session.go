@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"ehang.io/nps/lib/crypt"
+	"ehang.io/nps/lib/file"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/context"
+	"github.com/astaxie/beego/logs"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// accessTokenTTL access token 的有效期，过期后使用 refresh token 刷新
+func accessTokenTTL() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("web_access_token_ttl", 15)) * time.Minute
+}
+
+// refreshTokenTTL refresh token 的有效期，每次刷新后重新计算
+func refreshTokenTTL() time.Duration {
+	return time.Duration(beego.AppConfig.DefaultInt("web_refresh_token_ttl", 720)) * time.Hour
+}
+
+// jwtSecret 使用配置文件中的 auth_key 作为 JWT 密钥
+func jwtSecret() []byte {
+	secret := beego.AppConfig.String("auth_key")
+	if secret == "" {
+		secret = crypt.GetRandomString(64)
+	}
+	return []byte(secret)
+}
+
+// newRefreshToken 生成会话的 refresh token，格式为 会话id.随机数，返回 token 与其 sha256
+func newRefreshToken(sid string) (string, string) {
+	token := sid + "." + crypt.RandomHex(24)
+	return token, crypt.Sha256(token)
+}
+
+// signAccessToken 为登录会话签发 access token
+func signAccessToken(l *file.LoginSession, now time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username":  l.Username,
+		"accountId": l.AccountId,
+		"role":      l.Role,
+		"mfa":       l.Mfa,
+		"sid":       l.Id,
+		"exp":       now.Add(accessTokenTTL()).Unix(),
+	})
+	return token.SignedString(jwtSecret())
+}
+
+// tokenData 登录与刷新接口返回的 token 信息
+func tokenData(access, refresh string) map[string]interface{} {
+	return map[string]interface{}{
+		"token":         access,
+		"refresh_token": refresh,
+		"expires_in":    int64(accessTokenTTL() / time.Second),
+	}
+}
+
+// issueTokens 创建登录会话并签发 access token 与 refresh token
+// role 为登录用户的角色，mfa 表示登录时已通过两步验证，全局要求两步验证时未通过的 token 会被拒绝
+func issueTokens(ctx *context.Context, accountId int, username, role string, mfa bool) (map[string]interface{}, error) {
+	now := time.Now()
+	l := &file.LoginSession{
+		Id:        crypt.RandomHex(16),
+		AccountId: accountId,
+		Username:  username,
+		Role:      role,
+		Mfa:       mfa,
+		UserAgent: ctx.Input.UserAgent(),
+		Ip:        ctx.Input.IP(),
+		CreatedAt: now.Unix(),
+		ExpireAt:  now.Add(refreshTokenTTL()).Unix(),
+	}
+	if len(l.UserAgent) > 255 {
+		l.UserAgent = l.UserAgent[:255]
+	}
+	refresh, hash := newRefreshToken(l.Id)
+	l.RefreshHash = hash
+	if err := file.GetDb().CreateLoginSession(l); err != nil {
+		return nil, err
+	}
+	access, err := signAccessToken(l, now)
+	if err != nil {
+		return nil, err
+	}
+	return tokenData(access, refresh), nil
+}
+
+// refreshTokens 校验 refresh token 并轮换，已使用过的 refresh token 再次使用时视为泄露，退出该会话
+func refreshTokens(token string) (map[string]interface{}, error) {
+	errInvalid := errors.New("invalid or expired refresh token")
+	sid, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInvalid
+	}
+	now := time.Now()
+	l, err := file.GetDb().GetLoginSession(sid)
+	if err != nil || l == nil || !l.Active(now) {
+		return nil, errInvalid
+	}
+	// 账号被禁用后不能再刷新，角色按账号当前的角色签发
+	if l.AccountId != 0 {
+		a, err := file.GetDb().GetAccountInfo(l.AccountId)
+		if err != nil || !a.Status {
+			return nil, errInvalid
+		}
+		l.Role = a.Role
+	}
+	refresh, hash := newRefreshToken(l.Id)
+	l.ExpireAt = now.Add(refreshTokenTTL()).Unix()
+	if ok, err := file.GetDb().RotateRefreshToken(l.Id, crypt.Sha256(token), hash, l.ExpireAt, now); err != nil {
+		return nil, err
+	} else if !ok {
+		logs.Warn("refresh token of session %s reused, session revoked", l.Id)
+		file.GetDb().RevokeLoginSession(l.AccountId, l.Id)
+		return nil, errInvalid
+	}
+	access, err := signAccessToken(l, now)
+	if err != nil {
+		return nil, err
+	}
+	return tokenData(access, refresh), nil
+}
+
+type SessionController struct {
+	BaseController
+}
+
+// sessionAccountId 普通用户只能管理自己的登录会话，可以管理所有账号的角色可以通过 account_id 指定账号，
+// account_id 为 0 表示配置文件中的管理员
+func (s *SessionController) sessionAccountId() int {
+	if s.GetSession("isAdmin") == true && s.can(file.PermAccount) && s.GetString("account_id") != "" {
+		return s.GetIntNoErr("account_id")
+	}
+	id := s.GetSessionIntNoErr("accountId", 0)
+	if id <= 0 && s.role() != file.RoleAdmin {
+		s.AjaxErr("account is required")
+	}
+	return id
+}
+
+// 获取账号仍有效的登录会话
+func (s *SessionController) List() {
+	list, err := file.GetDb().ListLoginSessions(s.sessionAccountId(), time.Now())
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxTable(list, len(list), len(list), nil)
+}
+
+// 退出一个登录会话
+func (s *SessionController) Revoke() {
+	if err := file.GetDb().RevokeLoginSession(s.sessionAccountId(), s.GetString("id")); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("revoke success")
+}
+
+// 退出账号的所有登录会话，包括当前会话
+func (s *SessionController) RevokeAll() {
+	id := s.sessionAccountId()
+	n, err := file.GetDb().RevokeAccountSessions(id)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	if id == s.GetSessionIntNoErr("accountId", 0) {
+		s.DelSession("auth")
+	}
+	s.AjaxOkWithId("revoke success", int(n))
+}
+
+// 退出当前 token 所在的登录会话
+func (s *SessionController) Logout() {
+	if s.sessionId == "" {
+		s.AjaxErr("no token session")
+	}
+	if err := file.GetDb().RevokeLoginSession(s.GetSessionIntNoErr("accountId", 0), s.sessionId); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("logout success")
+}
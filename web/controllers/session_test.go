@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	db := newTestDb(t)
+	a := newTestAccount(t, db, "alice", "password", file.RoleOwner)
+	tokens, err := issueTokens(newTestRequest("192.0.2.40", nil, nil).ctx, a.Id, a.WebUserName, a.Role, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := func(token string) map[string]interface{} {
+		c := &LoginController{}
+		return newTestRequest("192.0.2.40", url.Values{"refresh_token": {token}}, nil).serve(t, c, "LoginController", "Refresh", c.Refresh)
+	}
+	old := tokens["refresh_token"].(string)
+	res := refresh(old)
+	if code(res) != 200 {
+		t.Fatalf("refresh %v", res)
+	}
+	rotated := res["data"].(map[string]interface{})["refresh_token"].(string)
+	if rotated == old {
+		t.Fatal("refresh token not rotated")
+	}
+	if res := refresh(old); code(res) != 401 {
+		t.Fatalf("reused refresh token accepted %v", res)
+	}
+	sid, _, _ := strings.Cut(old, ".")
+	if l, err := db.GetLoginSession(sid); err != nil || l == nil || l.Active(time.Now()) {
+		t.Fatalf("session not revoked after reuse %+v %v", l, err)
+	}
+	if res := refresh(rotated); code(res) != 401 {
+		t.Fatalf("rotated refresh token still valid after reuse %v", res)
+	}
+}
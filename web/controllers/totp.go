@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"ehang.io/nps/lib/crypt"
+	"ehang.io/nps/lib/file"
+	"github.com/astaxie/beego"
+	"github.com/astaxie/beego/logs"
+	"github.com/skip2/go-qrcode"
+)
+
+// recoveryCodeNum 每次绑定生成的恢复码数量
+const recoveryCodeNum = 10
+
+var (
+	errTotpRequired = errors.New("two-factor authentication code required")
+	errTotpInvalid  = errors.New("two-factor authentication code incorrect")
+)
+
+// checkTotp 校验登录时提交的两步验证码或恢复码，账号未开启两步验证时返回 verified 为 false 且无错误
+func checkTotp(totpId int, code string) (verified bool, err error) {
+	t, err := file.GetDb().GetTotp(totpId)
+	if err != nil {
+		return false, err
+	}
+	if t == nil || !t.Enabled {
+		return false, nil
+	}
+	if code == "" {
+		return false, errTotpRequired
+	}
+	if step, ok := crypt.ValidateTotp(t.Secret, code, time.Now()); ok {
+		if ok, err := file.GetDb().UseTotpStep(totpId, step); err != nil || !ok {
+			return false, errTotpInvalid
+		}
+		return true, nil
+	}
+	if ok, err := file.GetDb().UseRecoveryCode(totpId, crypt.HashRecoveryCode(code)); err == nil && ok {
+		logs.Warn("recovery code used by account %d", totpId)
+		return true, nil
+	}
+	return false, errTotpInvalid
+}
+
+type TotpController struct {
+	BaseController
+}
+
+// totpId 当前登录用户的两步验证记录 id，配置文件中的管理员为 file.AdminTotpId
+func (s *TotpController) totpId() int {
+	if v, ok := s.GetSession("totpId").(int); ok {
+		return v
+	}
+	id := s.GetSessionIntNoErr("accountId", 0)
+	if id == file.AdminTotpId && s.GetSession("isAdmin") != true {
+		s.AjaxErr("account is required")
+	}
+	return id
+}
+
+// 获取两步验证状态
+func (s *TotpController) Status() {
+	id := s.totpId()
+	t, err := file.GetDb().GetTotp(id)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	left, _ := file.GetDb().CountRecoveryCodes(id)
+	s.Data["json"] = map[string]interface{}{
+		"code": 200,
+		"msg":  "success",
+		"data": map[string]interface{}{
+			"enabled":       t != nil && t.Enabled,
+			"required":      file.GetDb().GetGlobal().RequireTotp,
+			"recovery_left": left,
+		},
+	}
+	s.ServeJSON()
+	s.StopRun()
+}
+
+// 生成新的密钥并返回 otpauth URI 与二维码，提交验证码确认后才会生效
+func (s *TotpController) Enroll() {
+	id := s.totpId()
+	if t, err := file.GetDb().GetTotp(id); err != nil {
+		s.AjaxErr(err.Error())
+	} else if t != nil && t.Enabled {
+		s.AjaxErr("two-factor authentication is already enabled, disable it first")
+	}
+	name, _ := s.GetSession("username").(string)
+	if name == "" {
+		name = beego.AppConfig.String("web_username")
+	}
+	secret := crypt.NewTotpSecret()
+	uri := crypt.TotpUri(beego.AppConfig.DefaultString("appname", "nps"), name, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	if err := file.GetDb().SaveTotpSecret(id, secret); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.Data["json"] = map[string]interface{}{
+		"code": 200,
+		"msg":  "success",
+		"data": map[string]interface{}{
+			"secret": secret,
+			"uri":    uri,
+			"qr":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		},
+	}
+	s.ServeJSON()
+	s.StopRun()
+}
+
+// 提交验证器应用中的验证码完成绑定，返回只显示一次的恢复码
+func (s *TotpController) Confirm() {
+	id := s.totpId()
+	t, err := file.GetDb().GetTotp(id)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	if t == nil || t.Enabled {
+		s.AjaxErr("no pending two-factor authentication enrollment")
+	}
+	step, ok := crypt.ValidateTotp(t.Secret, s.GetString("code"), time.Now())
+	if !ok {
+		s.AjaxErr(errTotpInvalid.Error())
+	}
+	codes := crypt.NewRecoveryCodes(recoveryCodeNum)
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = crypt.HashRecoveryCode(c)
+	}
+	if err := file.GetDb().EnableTotp(id, step, hashes); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.DelSession("totpEnroll")
+	s.Data["json"] = map[string]interface{}{
+		"code": 200,
+		"msg":  "two-factor authentication enabled",
+		"data": map[string]interface{}{"recovery_codes": codes},
+	}
+	s.ServeJSON()
+	s.StopRun()
+}
+
+// 关闭两步验证，需要提交验证码或恢复码；可以管理所有账号的角色可以通过 account_id 重置丢失设备的账号
+func (s *TotpController) Disable() {
+	if s.GetSession("isAdmin") == true && s.can(file.PermAccount) {
+		if id := s.GetIntNoErr("account_id"); id > 0 {
+			if err := file.GetDb().DisableTotp(id); err != nil {
+				s.AjaxErr(err.Error())
+			}
+			s.AjaxOk("two-factor authentication disabled")
+		}
+	} else if file.GetDb().GetGlobal().RequireTotp {
+		s.AjaxErr("two-factor authentication is required by the administrator")
+	}
+	id := s.totpId()
+	if _, err := checkTotp(id, s.GetString("code")); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	if err := file.GetDb().DisableTotp(id); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("two-factor authentication disabled")
+}
+
+// 设置是否要求所有用户开启两步验证，仅管理员可用
+func (s *TotpController) Require() {
+	if s.GetSession("isAdmin") != true {
+		s.AjaxErr("permission denied")
+	}
+	g := file.GetDb().GetGlobal()
+	g.RequireTotp = s.GetBoolNoErr("require")
+	if err := file.GetDb().SaveGlobal(g); err != nil {
+		s.AjaxErr(err.Error())
+	}
+	s.AjaxOk("save success")
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"time"
+
+	"ehang.io/nps/lib/file"
+)
+
+type TrafficController struct {
+	BaseController
+}
+
+// 各精度默认查询的时间范围
+var trafficDefaultRange = map[string]time.Duration{
+	file.TrafficRaw:  24 * time.Hour,
+	file.TrafficHour: 7 * 24 * time.Hour,
+	file.TrafficDay:  90 * 24 * time.Hour,
+}
+
+// 查询流量历史，kind 为 tunnel、host、client 或 account，resolution 为 raw、hour（默认）或 day，
+// start 与 end 为 unix 时间戳，返回 [start, end) 内按时间排序的流量及合计
+func (s *TrafficController) History() {
+	kind := s.getEscapeString("kind")
+	id := s.GetIntNoErr("id")
+	resolution := s.GetString("resolution", file.TrafficHour)
+	def, ok := trafficDefaultRange[resolution]
+	if !ok {
+		s.AjaxErr("resolution must be raw, hour or day")
+	}
+	if !s.canViewTraffic(kind, id) {
+		s.AjaxErr("permission denied")
+	}
+	end := int64(s.GetIntNoErr("end"))
+	if end <= 0 {
+		end = time.Now().Unix()
+	}
+	start := int64(s.GetIntNoErr("start"))
+	if start <= 0 {
+		start = end - int64(def/time.Second)
+	}
+	list, err := file.GetDb().GetTrafficSamples(kind, id, resolution, start, end)
+	if err != nil {
+		s.AjaxErr(err.Error())
+	}
+	var in, out, conns int64
+	for _, v := range list {
+		in += v.InBytes
+		out += v.OutBytes
+		conns += v.Conns
+	}
+	s.Data["json"] = map[string]interface{}{
+		"code": 200,
+		"msg":  "success",
+		"data": map[string]interface{}{
+			"rows":      list,
+			"in_bytes":  in,
+			"out_bytes": out,
+			"conns":     conns,
+		},
+	}
+	s.ServeJSON()
+	s.StopRun()
+}
+
+// canViewTraffic 管理员可以查询全部对象，普通用户只能查询自己账号下的对象
+func (s *TrafficController) canViewTraffic(kind string, id int) bool {
+	var accountId int
+	switch kind {
+	case file.TrafficKindAccount:
+		accountId = id
+	case file.TrafficKindClient:
+		c, err := file.GetDb().GetClient(id)
+		if err != nil {
+			return false
+		}
+		accountId = c.AccountId
+	case file.TrafficKindTunnel, file.TrafficKindHost:
+		// 隧道与域名解析保存在同一张表中
+		t, err := file.GetDb().GetTask(id)
+		if err != nil {
+			return false
+		}
+		accountId = t.AccountId
+	default:
+		return false
+	}
+	if s.GetSession("isAdmin") == true {
+		return true
+	}
+	return accountId != 0 && accountId == s.GetSessionIntNoErr("accountId", 0)
+}
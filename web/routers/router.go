@@ -15,6 +15,17 @@ func Init() {
 			beego.NSAutoRouter(&controllers.ClientController{}),
 			beego.NSAutoRouter(&controllers.AuthController{}),
 			beego.NSAutoRouter(&controllers.GlobalController{}),
+			beego.NSAutoRouter(&controllers.TrafficController{}),
+			beego.NSAutoRouter(&controllers.PlanController{}),
+			beego.NSAutoRouter(&controllers.OrderController{}),
+			beego.NSAutoRouter(&controllers.CouponController{}),
+			beego.NSAutoRouter(&controllers.NotifyController{}),
+			beego.NSAutoRouter(&controllers.TotpController{}),
+			beego.NSAutoRouter(&controllers.AccountController{}),
+			beego.NSAutoRouter(&controllers.ApiKeyController{}),
+			beego.NSAutoRouter(&controllers.SessionController{}),
+			beego.NSAutoRouter(&controllers.EmailController{}),
+			beego.NSAutoRouter(&controllers.LockoutController{}),
 		)
 		beego.AddNamespace(ns)
 	} else {
@@ -24,6 +35,17 @@ func Init() {
 		beego.AutoRouter(&controllers.ClientController{})
 		beego.AutoRouter(&controllers.AuthController{})
 		beego.AutoRouter(&controllers.GlobalController{})
+		beego.AutoRouter(&controllers.TrafficController{})
+		beego.AutoRouter(&controllers.PlanController{})
+		beego.AutoRouter(&controllers.OrderController{})
+		beego.AutoRouter(&controllers.CouponController{})
+		beego.AutoRouter(&controllers.NotifyController{})
+		beego.AutoRouter(&controllers.TotpController{})
+		beego.AutoRouter(&controllers.AccountController{})
+		beego.AutoRouter(&controllers.ApiKeyController{})
+		beego.AutoRouter(&controllers.SessionController{})
+		beego.AutoRouter(&controllers.EmailController{})
+		beego.AutoRouter(&controllers.LockoutController{})
 
 	}
 }